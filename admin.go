@@ -0,0 +1,344 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourusername/e2b-api-gateway/apierror"
+)
+
+// 管理接口相关环境变量
+const (
+	ENV_ADMIN_KEY = "E2B_ADMIN_KEY" // 管理接口凭证，留空则关闭管理接口
+)
+
+// registerAdminRoutes 注册 /admin 管理接口
+func registerAdminRoutes(r *gin.Engine) {
+	if CONFIG.ADMIN.KEY == "" {
+		return
+	}
+
+	admin := r.Group("/admin", adminAuthMiddleware())
+	admin.GET("/keys", handleAdminListKeys)
+	admin.POST("/keys", handleAdminCreateKey)
+	admin.PATCH("/keys/:id", handleAdminUpdateKey)
+	admin.DELETE("/keys/:id", handleAdminRevokeKey)
+	admin.POST("/keys/:id/rotate", handleAdminRotateKey)
+	admin.GET("/models", handleAdminListModels)
+	admin.GET("/prompts", handleAdminListPrompts)
+	admin.POST("/models/:name/enable", handleAdminSetModelEnabled(true))
+	admin.POST("/models/:name/disable", handleAdminSetModelEnabled(false))
+	admin.GET("/config", handleAdminConfig)
+	admin.GET("/state", handleAdminState)
+	admin.GET("/metrics", handleAdminMetrics)
+}
+
+// adminAuthMiddleware 校验管理凭证，支持 Authorization: Bearer 和 X-Admin-Key 两种方式
+func adminAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.GetHeader("X-Admin-Key")
+		if token == "" {
+			token = strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(CONFIG.ADMIN.KEY)) != 1 {
+			requestID := GenerateUUID()
+			logError(requestID, fmt.Sprintf("管理接口认证失败，提供的令牌: %s", maskString(token, 8)), nil)
+			writeAPIError(c, apierror.InvalidAdminKey())
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// bindAdminJSON 读取并解析管理接口的请求体，大小限制与补全接口相同
+func bindAdminJSON(c *gin.Context, v interface{}) error {
+	body, err := readRequestBody(c)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return apierror.InvalidRequest("", apierror.MsgInvalidBody, err.Error())
+	}
+	return nil
+}
+
+// 列出所有API密钥
+func handleAdminListKeys(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"object": "list", "data": keyStore.List()})
+}
+
+// 创建API密钥，完整密钥只在创建时返回一次
+func handleAdminCreateKey(c *gin.Context) {
+	requestID := GenerateUUID()
+
+	var body struct {
+		Name string `json:"name"`
+		KeySettings
+	}
+	if err := bindAdminJSON(c, &body); err != nil {
+		writeAPIError(c, err)
+		return
+	}
+	if strings.TrimSpace(body.Name) == "" {
+		writeAPIError(c, apierror.InvalidRequest("name", apierror.MsgKeyNameRequired))
+		return
+	}
+	if err := validateKeySettings(body.KeySettings); err != nil {
+		writeAPIError(c, err)
+		return
+	}
+
+	key, err := keyStore.Create(strings.TrimSpace(body.Name), body.KeySettings)
+	if err != nil {
+		logError(requestID, "创建API密钥失败", err)
+		writeAPIError(c, err)
+		return
+	}
+	logInfo(requestID, fmt.Sprintf("创建API密钥: %s (%s, 等级: %s)", key.ID, key.Name, key.EffectiveTier()))
+	c.JSON(http.StatusCreated, gin.H{"key": key.View(), "secret": key.Key})
+}
+
+// 修改API密钥的等级和默认提示词预设
+func handleAdminUpdateKey(c *gin.Context) {
+	requestID := GenerateUUID()
+
+	var settings KeySettings
+	if err := bindAdminJSON(c, &settings); err != nil {
+		writeAPIError(c, err)
+		return
+	}
+	if err := validateKeySettings(settings); err != nil {
+		writeAPIError(c, err)
+		return
+	}
+
+	key, err := keyStore.Update(c.Param("id"), settings)
+	if err != nil {
+		adminKeyError(c, requestID, err)
+		return
+	}
+	logInfo(requestID, fmt.Sprintf("修改API密钥: %s (等级: %s, 提示词预设: %s)", key.ID, key.EffectiveTier(), key.PromptPreset))
+	c.JSON(http.StatusOK, gin.H{"key": key.View()})
+}
+
+// 吊销API密钥
+func handleAdminRevokeKey(c *gin.Context) {
+	requestID := GenerateUUID()
+	key, err := keyStore.Revoke(c.Param("id"))
+	if err != nil {
+		adminKeyError(c, requestID, err)
+		return
+	}
+	logInfo(requestID, fmt.Sprintf("吊销API密钥: %s (%s)", key.ID, key.Name))
+	c.JSON(http.StatusOK, gin.H{"key": key.View()})
+}
+
+// 轮换API密钥，新密钥只在此时返回一次
+func handleAdminRotateKey(c *gin.Context) {
+	requestID := GenerateUUID()
+	key, err := keyStore.Rotate(c.Param("id"))
+	if err != nil {
+		adminKeyError(c, requestID, err)
+		return
+	}
+	logInfo(requestID, fmt.Sprintf("轮换API密钥: %s (%s)", key.ID, key.Name))
+	response := gin.H{"key": key.View(), "secret": key.Key}
+	if key.PreviousExpiresAt != nil {
+		response["previous_expires_at"] = key.PreviousExpiresAt
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 列出所有模型及启用状态
+func handleAdminListModels(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"object": "list", "data": listModelStatus()})
+}
+
+// 启用或禁用模型
+func handleAdminSetModelEnabled(enabled bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := GenerateUUID()
+		name := c.Param("name")
+		if err := setModelEnabled(name, enabled); err != nil {
+			writeAPIError(c, apierror.ModelNotFound(name))
+			return
+		}
+		logInfo(requestID, fmt.Sprintf("模型 %s 已%s", name, map[bool]string{true: "启用", false: "禁用"}[enabled]))
+		c.JSON(http.StatusOK, gin.H{"name": name, "enabled": enabled})
+	}
+}
+
+// 列出提示词预设
+func handleAdminListPrompts(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"object": "list", "default": CONFIG.PROMPTS.DEFAULT, "data": listPromptPresets()})
+}
+
+// 查看生效中的配置，敏感信息经过掩码处理
+func handleAdminConfig(c *gin.Context) {
+	c.JSON(http.StatusOK, effectiveConfig())
+}
+
+// 查看运行时状态
+func handleAdminState(c *gin.Context) {
+	state := gin.H{}
+	if responseCache != nil {
+		state["cache"] = responseCache.Stats()
+	} else {
+		state["cache"] = nil
+	}
+	state["key_concurrency"] = keyLimiter.Stats()
+	state["breaker"] = upstreamBreaker.Stats()
+	state["probe"] = prober.Status()
+	state["build"] = buildInfo()
+	if tlsCertificates != nil {
+		state["tls"] = tlsCertificates.Status()
+	} else {
+		state["tls"] = nil
+	}
+	c.JSON(http.StatusOK, state)
+}
+
+// effectiveConfig 返回生效中的配置，所有密钥都经过 maskString 处理
+func effectiveConfig() gin.H {
+	return gin.H{
+		"api": gin.H{
+			"base_url": CONFIG.API.BASE_URL,
+			"api_key":  maskString(CONFIG.API.API_KEY, 8),
+		},
+		"admin": gin.H{
+			"key": maskString(CONFIG.ADMIN.KEY, 0),
+		},
+		"retry": gin.H{
+			"max_attempts": CONFIG.RETRY.MAX_ATTEMPTS,
+			"delay_base":   CONFIG.RETRY.DELAY_BASE,
+			"max_delay":    CONFIG.RETRY.MAX_DELAY.String(),
+			"timeout":      CONFIG.RETRY.TIMEOUT.String(),
+		},
+		"id": gin.H{
+			"uuid_version":     CONFIG.ID.UUID_VERSION,
+			"user_id_strategy": CONFIG.ID.USER_ID_STRATEGY,
+			"user_id":          maskString(CONFIG.ID.USER_ID, 8),
+			"user_id_salt":     maskString(CONFIG.ID.USER_ID_SALT, 0),
+		},
+		"keys": gin.H{
+			"file":           CONFIG.KEYS.FILE,
+			"rotation_grace": CONFIG.KEYS.ROTATION_GRACE.String(),
+		},
+		"upstream": gin.H{
+			"mode":           CONFIG.UPSTREAM.MODE,
+			"cassette_file":  CONFIG.UPSTREAM.CASSETTE_FILE,
+			"cassette_match": CONFIG.UPSTREAM.CASSETTE_MATCH,
+		},
+		"cache": gin.H{
+			"backend":     CONFIG.CACHE.BACKEND,
+			"ttl":         CONFIG.CACHE.TTL.String(),
+			"max_entries": CONFIG.CACHE.MAX_ENTRIES,
+			"max_bytes":   CONFIG.CACHE.MAX_BYTES,
+			"dir":         CONFIG.CACHE.DIR,
+		},
+		"limits": gin.H{
+			"key_concurrency":    CONFIG.LIMITS.KEY_CONCURRENCY,
+			"key_queue_timeout":  CONFIG.LIMITS.KEY_QUEUE_TIMEOUT.String(),
+			"max_n":              CONFIG.LIMITS.MAX_N,
+			"fanout_concurrency": CONFIG.LIMITS.FANOUT_CONCURRENCY,
+			"max_body_bytes":     CONFIG.LIMITS.MAX_BODY_BYTES,
+			"max_messages":       CONFIG.LIMITS.MAX_MESSAGES,
+			"max_message_chars":  CONFIG.LIMITS.MAX_MESSAGE_CHARS,
+			"max_images":         CONFIG.LIMITS.MAX_IMAGES,
+			"max_image_bytes":    CONFIG.LIMITS.MAX_IMAGE_BYTES,
+		},
+		"models":          enabledModelNames(),
+		"model_aliases":   CONFIG.MODEL_ALIASES,
+		"model_fallbacks": CONFIG.MODEL_FALLBACKS,
+		"errors": gin.H{
+			"language": CONFIG.ERRORS.LANGUAGE,
+		},
+		"breaker": gin.H{
+			"threshold": CONFIG.BREAKER.THRESHOLD,
+			"cooldown":  CONFIG.BREAKER.COOLDOWN.String(),
+		},
+		"probe": gin.H{
+			"interval": CONFIG.PROBE.INTERVAL.String(),
+			"timeout":  CONFIG.PROBE.TIMEOUT.String(),
+			"model":    CONFIG.PROBE.MODEL,
+		},
+		"server": gin.H{
+			"listeners":        CONFIG.SERVER.LISTENERS,
+			"h2c":              CONFIG.SERVER.H2C,
+			"unix_socket_mode": fmt.Sprintf("%04o", CONFIG.SERVER.UNIX_SOCKET_MODE),
+			"tls": gin.H{
+				"cert_file":       CONFIG.SERVER.TLS.CERT_FILE,
+				"key_file":        CONFIG.SERVER.TLS.KEY_FILE,
+				"reload_interval": CONFIG.SERVER.TLS.RELOAD_INTERVAL.String(),
+			},
+		},
+		"shutdown": gin.H{
+			"grace":           CONFIG.SHUTDOWN.GRACE.String(),
+			"readiness_delay": CONFIG.SHUTDOWN.READINESS_DELAY.String(),
+		},
+		"schema": gin.H{
+			"mode":   CONFIG.SCHEMA.MODE,
+			"fields": listRequestFields(),
+		},
+		"params": gin.H{
+			"mode":   CONFIG.PARAMS.MODE,
+			"ranges": modelParamRangesByModel(),
+		},
+		"context": gin.H{
+			"strategy":        CONFIG.CONTEXT.STRATEGY,
+			"output_reserve":  CONFIG.CONTEXT.OUTPUT_RESERVE,
+			"summary_max_len": CONFIG.CONTEXT.SUMMARY_MAX_LEN,
+		},
+		"messages": gin.H{
+			"system_strategy": CONFIG.MESSAGES.SYSTEM_STRATEGY,
+			"separator":       CONFIG.MESSAGES.SEPARATOR,
+		},
+		"prompts": gin.H{
+			"default":   CONFIG.PROMPTS.DEFAULT,
+			"available": promptPresetNames(),
+		},
+		"extraction": gin.H{
+			"default":   CONFIG.EXTRACTION.DEFAULT,
+			"separator": CONFIG.EXTRACTION.SEPARATOR,
+		},
+		"templates": gin.H{
+			"default":   CONFIG.DEFAULT_TEMPLATE,
+			"available": templateIDs(),
+		},
+		"routing": gin.H{
+			"timezone":       CONFIG.ROUTING.TIMEZONE,
+			"virtual_models": CONFIG.ROUTING.VIRTUAL_MODELS,
+		},
+	}
+}
+
+// validateKeySettings 检查密钥属性引用的提示词预设是否存在，并发上限不能为负数
+func validateKeySettings(settings KeySettings) error {
+	if settings.MaxConcurrency != nil && *settings.MaxConcurrency < 0 {
+		return apierror.InvalidRequest("max_concurrency", apierror.MsgNegativeConcurrency)
+	}
+	if settings.PromptPreset != nil {
+		if preset := strings.TrimSpace(*settings.PromptPreset); preset != "" && !validPromptPreset(preset) {
+			return apierror.InvalidRequest("prompt_preset", apierror.MsgPromptPresetNotFound, preset)
+		}
+	}
+	return nil
+}
+
+func adminKeyError(c *gin.Context, requestID string, err error) {
+	switch {
+	case errors.Is(err, errKeyNotFound):
+		writeAPIError(c, apierror.New(http.StatusNotFound, apierror.TypeInvalidRequest, apierror.CodeKeyNotFound, apierror.MsgKeyNotFound))
+	case errors.Is(err, errKeyRevoked):
+		writeAPIError(c, apierror.New(http.StatusConflict, apierror.TypeInvalidRequest, apierror.CodeKeyRevoked, apierror.MsgKeyRevoked))
+	default:
+		logError(requestID, "管理API密钥失败", err)
+		writeAPIError(c, err)
+	}
+}
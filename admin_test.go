@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newAdminTestRouter 使用独立的密钥存储和管理凭证创建管理接口
+func newAdminTestRouter(t *testing.T) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	savedKey, savedStore := CONFIG.ADMIN.KEY, keyStore
+	t.Cleanup(func() { CONFIG.ADMIN.KEY, keyStore = savedKey, savedStore })
+	CONFIG.ADMIN.KEY = "adm"
+	keyStore, _ = NewKeyStore("", 0)
+
+	r := gin.New()
+	registerAdminRoutes(r)
+	return r
+}
+
+func adminRequest(r *gin.Engine, method, path, body string) (int, map[string]interface{}) {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("X-Admin-Key", "adm")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	var decoded map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &decoded)
+	return w.Code, decoded
+}
+
+func TestAdminAuth(t *testing.T) {
+	r := newAdminTestRouter(t)
+	tests := []struct {
+		header, value string
+		want          int
+	}{
+		{"", "", http.StatusUnauthorized},
+		{"X-Admin-Key", "wrong", http.StatusUnauthorized},
+		{"X-Admin-Key", "adm", http.StatusOK},
+		{"Authorization", "Bearer adm", http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/admin/keys", nil)
+		if tt.header != "" {
+			req.Header.Set(tt.header, tt.value)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%s: %q 状态码 = %d，期望 %d", tt.header, tt.value, w.Code, tt.want)
+		}
+	}
+}
+
+func TestAdminKeys(t *testing.T) {
+	r := newAdminTestRouter(t)
+
+	for _, body := range []string{``, `{}`, `{"name":"  "}`, `not json`} {
+		if status, _ := adminRequest(r, http.MethodPost, "/admin/keys", body); status != http.StatusBadRequest {
+			t.Errorf("创建请求体 %q 状态码 = %d，期望 400", body, status)
+		}
+	}
+
+	status, created := adminRequest(r, http.MethodPost, "/admin/keys", `{"name":"ci","tier":"pro"}`)
+	if status != http.StatusCreated {
+		t.Fatalf("创建状态码 = %d", status)
+	}
+	secret, _ := created["secret"].(string)
+	id, _ := created["key"].(map[string]interface{})["id"].(string)
+	if tier := created["key"].(map[string]interface{})["tier"]; tier != "pro" {
+		t.Errorf("等级 = %v，期望 pro", tier)
+	}
+	if _, ok := keyStore.Authenticate(secret); !ok || id == "" {
+		t.Fatalf("创建返回的密钥不可用: %v", created)
+	}
+
+	_, list := adminRequest(r, http.MethodGet, "/admin/keys", "")
+	data, _ := list["data"].([]interface{})
+	if len(data) != 1 || strings.Contains(mustJSON(data), secret) {
+		t.Fatalf("列表应只包含掩码后的密钥: %v", list)
+	}
+
+	status, updated := adminRequest(r, http.MethodPatch, "/admin/keys/"+id, `{"prompt_preset":"concise"}`)
+	if key, _ := updated["key"].(map[string]interface{}); status != http.StatusOK || key["prompt_preset"] != "concise" || key["tier"] != "pro" {
+		t.Fatalf("修改 = %d %v", status, updated)
+	}
+	if status, _ := adminRequest(r, http.MethodPatch, "/admin/keys/"+id, `{"prompt_preset":"missing"}`); status != http.StatusBadRequest {
+		t.Errorf("不存在的提示词预设状态码 = %d，期望 400", status)
+	}
+
+	status, rotated := adminRequest(r, http.MethodPost, "/admin/keys/"+id+"/rotate", "")
+	if status != http.StatusOK || rotated["secret"] == secret {
+		t.Fatalf("轮换 = %d %v", status, rotated)
+	}
+
+	tests := []struct {
+		method, path, body string
+		want               int
+	}{
+		{http.MethodDelete, "/admin/keys/" + id, "", http.StatusOK},
+		{http.MethodDelete, "/admin/keys/" + id, "", http.StatusConflict},
+		{http.MethodPost, "/admin/keys/" + id + "/rotate", "", http.StatusConflict},
+		{http.MethodDelete, "/admin/keys/missing", "", http.StatusNotFound},
+		{http.MethodPost, "/admin/keys/missing/rotate", "", http.StatusNotFound},
+		{http.MethodPatch, "/admin/keys/missing", "{}", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if status, _ := adminRequest(r, tt.method, tt.path, tt.body); status != tt.want {
+			t.Errorf("%s %s 状态码 = %d，期望 %d", tt.method, tt.path, status, tt.want)
+		}
+	}
+}
+
+func TestAdminModels(t *testing.T) {
+	r := newAdminTestRouter(t)
+	name := "claude-3-haiku-20240307"
+	defer setModelEnabled(name, true)
+
+	if status, _ := adminRequest(r, http.MethodPost, "/admin/models/"+name+"/disable", ""); status != http.StatusOK {
+		t.Fatalf("禁用状态码 = %d", status)
+	}
+	if _, ok := lookupModel(name); ok {
+		t.Fatal("禁用后模型不应可用")
+	}
+	if status, _ := adminRequest(r, http.MethodPost, "/admin/models/"+name+"/enable", ""); status != http.StatusOK {
+		t.Fatalf("启用状态码 = %d", status)
+	}
+	if _, ok := lookupModel(name); !ok {
+		t.Fatal("启用后模型应可用")
+	}
+	if status, _ := adminRequest(r, http.MethodPost, "/admin/models/missing/disable", ""); status != http.StatusNotFound {
+		t.Fatalf("不存在的模型状态码 = %d，期望 404", status)
+	}
+}
+
+func TestAdminConfigMasksSecrets(t *testing.T) {
+	r := newAdminTestRouter(t)
+	_, config := adminRequest(r, http.MethodGet, "/admin/config", "")
+	if encoded := mustJSON(config); strings.Contains(encoded, CONFIG.API.API_KEY) || strings.Contains(encoded, `"adm"`) {
+		t.Fatalf("配置中不应出现完整密钥: %s", encoded)
+	}
+}
+
+func mustJSON(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+func TestAdminBodyLimit(t *testing.T) {
+	r := newAdminTestRouter(t)
+	withLimits(t, func() { CONFIG.LIMITS.MAX_BODY_BYTES = 32 })
+
+	large := `{"name":"` + strings.Repeat("x", 64) + `"}`
+	for _, tt := range []struct{ method, path string }{
+		{http.MethodPost, "/admin/keys"},
+		{http.MethodPatch, "/admin/keys/" + DEFAULT_KEY_ID},
+	} {
+		if status, _ := adminRequest(r, tt.method, tt.path, large); status != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s %s 超出大小限制时状态码 = %d，期望 413", tt.method, tt.path, status)
+		}
+	}
+	if status, _ := adminRequest(r, http.MethodPost, "/admin/keys", `{"name":"ci"}`); status != http.StatusCreated {
+		t.Errorf("未超出限制时状态码 = %d，期望 201", status)
+	}
+}
@@ -0,0 +1,241 @@
+// Package apierror 定义网关对外返回的 OpenAI 风格错误：HTTP 状态码、type、code、param，
+// 以及按 Accept-Language 本地化的错误信息
+package apierror
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// OpenAI 错误 type
+const (
+	TypeInvalidRequest = "invalid_request_error"
+	TypeAuthentication = "authentication_error"
+	TypeRateLimit      = "rate_limit_error"
+	TypeServer         = "server_error"
+)
+
+// 错误 code
+const (
+	CodeInvalidAPIKey            = "invalid_api_key"
+	CodeInvalidAdminKey          = "invalid_admin_key"
+	CodeModelNotFound            = "model_not_found"
+	CodeContextLengthExceeded    = "context_length_exceeded"
+	CodeParameterOutOfRange      = "parameter_out_of_range"
+	CodeUnsupportedParameter     = "unsupported_parameter"
+	CodeUnknownParameter         = "unknown_parameter"
+	CodeInvalidValue             = "invalid_value"
+	CodeContentPolicyViolation   = "content_policy_violation"
+	CodeConcurrencyLimitExceeded = "concurrency_limit_exceeded"
+	CodeRateLimitExceeded        = "rate_limit_exceeded"
+	CodeUpstreamError            = "upstream_error"
+	CodeTimeout                  = "timeout"
+	CodeInternalError            = "internal_error"
+	CodeKeyNotFound              = "key_not_found"
+	CodeKeyRevoked               = "key_revoked"
+	CodeRequestTooLarge          = "request_too_large"
+	CodeTooManyMessages          = "too_many_messages"
+	CodeMessageTooLong           = "message_too_long"
+	CodeTooManyImages            = "too_many_images"
+	CodeImageTooLarge            = "image_too_large"
+)
+
+// Error 对外返回的错误。信息由消息键和参数组成，在写入响应时按语言渲染
+type Error struct {
+	Status int
+	Type   string
+	Code   string
+	Param  string
+	Key    string
+	Args   []interface{}
+	// Extra 附加到错误对象中的字段，例如 context_length_exceeded 的 tokens 和 limit
+	Extra map[string]interface{}
+	// Detail 上游返回的原始信息（已脱敏），附加在本地化信息之后
+	Detail string
+
+	cause error
+}
+
+// New 创建错误，key 为消息目录中的消息键
+func New(status int, errType, code, key string, args ...interface{}) *Error {
+	return &Error{Status: status, Type: errType, Code: code, Key: key, Args: args}
+}
+
+// Error 返回默认语言的信息，用于日志
+func (e *Error) Error() string {
+	message := e.Message(DefaultLanguage)
+	if e.cause != nil {
+		return message + ": " + e.cause.Error()
+	}
+	return message
+}
+
+// Unwrap 返回导致该错误的原始错误
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// WithParam 设置出错的请求字段
+func (e *Error) WithParam(param string) *Error {
+	e.Param = param
+	return e
+}
+
+// WithExtra 在错误对象中附加字段
+func (e *Error) WithExtra(key string, value interface{}) *Error {
+	if e.Extra == nil {
+		e.Extra = make(map[string]interface{})
+	}
+	e.Extra[key] = value
+	return e
+}
+
+// WithDetail 附加上游返回的信息，调用方需要先脱敏
+func (e *Error) WithDetail(detail string) *Error {
+	e.Detail = detail
+	return e
+}
+
+// Wrap 记录原始错误，原始错误只出现在日志中，不返回给调用方
+func (e *Error) Wrap(cause error) *Error {
+	e.cause = cause
+	return e
+}
+
+// Message 按语言渲染错误信息，不支持的语言使用默认语言
+func (e *Error) Message(lang string) string {
+	message := e.Key
+	if format, ok := lookup(lang, e.Key); ok {
+		message = format
+		if len(e.Args) > 0 {
+			message = fmt.Sprintf(format, e.Args...)
+		}
+	}
+	if e.Detail != "" {
+		format, _ := lookup(lang, msgUpstreamDetail)
+		message = fmt.Sprintf(format, message, e.Detail)
+	}
+	return message
+}
+
+// Body 返回响应体，结构与 OpenAI 一致: {"error": {"message", "type", "param", "code"}}
+func (e *Error) Body(lang string) map[string]interface{} {
+	body := map[string]interface{}{
+		"message": e.Message(lang),
+		"type":    e.Type,
+		"param":   nullable(e.Param),
+		"code":    nullable(e.Code),
+	}
+	for key, value := range e.Extra {
+		body[key] = value
+	}
+	return map[string]interface{}{"error": body}
+}
+
+func nullable(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// From 把任意错误转换为对外错误，不是 *Error 的错误视为内部错误
+func From(err error) *Error {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	return Internal().Wrap(err)
+}
+
+// InvalidRequest 请求内容无效，返回400
+func InvalidRequest(param, key string, args ...interface{}) *Error {
+	return New(http.StatusBadRequest, TypeInvalidRequest, "", key, args...).WithParam(param)
+}
+
+// InvalidValue 字段取值不在可选范围内
+func InvalidValue(param, value, options string) *Error {
+	e := InvalidRequest(param, MsgInvalidOption, param, value, options)
+	e.Code = CodeInvalidValue
+	return e
+}
+
+// RequestTooLarge 请求体超过大小上限，返回413
+func RequestTooLarge(limit int64) *Error {
+	return New(http.StatusRequestEntityTooLarge, TypeInvalidRequest, CodeRequestTooLarge, MsgRequestTooLarge, limit)
+}
+
+// InvalidAPIKey 调用方API密钥无效
+func InvalidAPIKey() *Error {
+	return New(http.StatusUnauthorized, TypeAuthentication, CodeInvalidAPIKey, MsgInvalidAPIKey)
+}
+
+// InvalidAdminKey 管理凭证无效
+func InvalidAdminKey() *Error {
+	return New(http.StatusUnauthorized, TypeAuthentication, CodeInvalidAdminKey, MsgInvalidAdminKey)
+}
+
+// ModelNotFound 模型不存在或已禁用，与 OpenAI 一致返回404
+func ModelNotFound(model string) *Error {
+	return New(http.StatusNotFound, TypeInvalidRequest, CodeModelNotFound, MsgModelNotFound, model).WithParam("model")
+}
+
+// ContextLengthExceeded 提示词超出模型上下文窗口
+func ContextLengthExceeded(model string, tokens, limit int) *Error {
+	return New(http.StatusBadRequest, TypeInvalidRequest, CodeContextLengthExceeded, MsgContextLengthExceeded, model, limit, tokens).
+		WithParam("messages").
+		WithExtra("tokens", tokens).
+		WithExtra("limit", limit)
+}
+
+// ConcurrencyLimitExceeded 密钥的并发名额不足
+func ConcurrencyLimitExceeded() *Error {
+	return New(http.StatusTooManyRequests, TypeRateLimit, CodeConcurrencyLimitExceeded, MsgConcurrencyLimitExceeded)
+}
+
+// Timeout 上游在限定时间内没有响应
+func Timeout() *Error {
+	return New(http.StatusGatewayTimeout, TypeServer, CodeTimeout, MsgTimeout)
+}
+
+// UpstreamUnavailable 无法连接上游
+func UpstreamUnavailable() *Error {
+	return New(http.StatusBadGateway, TypeServer, CodeUpstreamError, MsgUpstreamUnavailable)
+}
+
+// UpstreamCircuitOpen 上游连续失败后熔断，暂时不再请求上游
+func UpstreamCircuitOpen() *Error {
+	return New(http.StatusServiceUnavailable, TypeServer, CodeUpstreamError, MsgUpstreamCircuitOpen)
+}
+
+// UpstreamInvalidResponse 上游响应无法解析或没有内容
+func UpstreamInvalidResponse(key string) *Error {
+	return New(http.StatusBadGateway, TypeServer, CodeUpstreamError, key)
+}
+
+// UpstreamRateLimited 上游限流
+func UpstreamRateLimited() *Error {
+	return New(http.StatusTooManyRequests, TypeRateLimit, CodeRateLimitExceeded, MsgUpstreamRateLimited)
+}
+
+// UpstreamContextLength 上游认为请求超出上下文长度
+func UpstreamContextLength() *Error {
+	return New(http.StatusBadRequest, TypeInvalidRequest, CodeContextLengthExceeded, MsgUpstreamTooLarge).WithParam("messages")
+}
+
+// ContentPolicyViolation 上游因内容政策拒绝请求，与 OpenAI 一致返回400
+func ContentPolicyViolation() *Error {
+	return New(http.StatusBadRequest, TypeInvalidRequest, CodeContentPolicyViolation, MsgUpstreamContentPolicy).WithParam("messages")
+}
+
+// UpstreamStatus 上游返回了无法归类的错误状态码。
+// 上游的认证和路径错误是网关自身的配置问题，对调用方统一表现为 502
+func UpstreamStatus(status int) *Error {
+	return New(http.StatusBadGateway, TypeServer, CodeUpstreamError, MsgUpstreamStatus, status)
+}
+
+// Internal 网关内部错误
+func Internal() *Error {
+	return New(http.StatusInternalServerError, TypeServer, CodeInternalError, MsgInternalError)
+}
@@ -0,0 +1,208 @@
+package apierror
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 支持的语言
+const (
+	LangEN = "en"
+	LangZH = "zh"
+)
+
+// DefaultLanguage 请求未指定或指定了不支持的语言时使用的语言，可由 SetDefaultLanguage 修改
+var DefaultLanguage = LangZH
+
+// SetDefaultLanguage 设置默认语言，不支持的语言返回 false
+func SetDefaultLanguage(lang string) bool {
+	if _, ok := catalog[lang]; !ok {
+		return false
+	}
+	DefaultLanguage = lang
+	return true
+}
+
+// 消息键
+const (
+	MsgInvalidAPIKey            = "invalid_api_key"
+	MsgInvalidAdminKey          = "invalid_admin_key"
+	MsgInvalidBody              = "invalid_body"
+	MsgRequestTooLarge          = "request_too_large"
+	MsgMessagesEmpty            = "messages_empty"
+	MsgNoConversationMessages   = "no_conversation_messages"
+	MsgTooManyMessages          = "too_many_messages"
+	MsgMessageTooLong           = "message_too_long"
+	MsgTooManyImages            = "too_many_images"
+	MsgImageTooLarge            = "image_too_large"
+	MsgInvalidOption            = "invalid_option"
+	MsgUnsupportedParameter     = "unsupported_parameter"
+	MsgUnknownParameter         = "unknown_parameter"
+	MsgMaxTokensConflict        = "max_tokens_conflict"
+	MsgMaxTokensTooSmall        = "max_tokens_too_small"
+	MsgStreamOptionsNeedsStream = "stream_options_needs_stream"
+	MsgStopInvalidType          = "stop_invalid_type"
+	MsgStopTooMany              = "stop_too_many"
+	MsgPromptPresetNotFound     = "prompt_preset_not_found"
+	MsgNTooSmall                = "n_too_small"
+	MsgNTooLarge                = "n_too_large"
+	MsgNNotSupported            = "n_not_supported"
+	MsgModelNotFound            = "model_not_found"
+	MsgContextLengthExceeded    = "context_length_exceeded"
+	MsgParameterOutOfRange      = "parameter_out_of_range"
+	MsgConcurrencyLimitExceeded = "concurrency_limit_exceeded"
+	MsgUpstreamRateLimited      = "upstream_rate_limited"
+	MsgUpstreamTooLarge         = "upstream_too_large"
+	MsgUpstreamContentPolicy    = "upstream_content_policy"
+	MsgUpstreamStatus           = "upstream_status"
+	MsgUpstreamUnavailable      = "upstream_unavailable"
+	MsgUpstreamCircuitOpen      = "upstream_circuit_open"
+	MsgUpstreamEmpty            = "upstream_empty"
+	MsgUpstreamInvalidResponse  = "upstream_invalid_response"
+	MsgTimeout                  = "timeout"
+	MsgInternalError            = "internal_error"
+	MsgKeyNameRequired          = "key_name_required"
+	MsgKeyNotFound              = "key_not_found"
+	MsgKeyRevoked               = "key_revoked"
+	MsgNegativeConcurrency      = "negative_concurrency"
+
+	msgUpstreamDetail = "upstream_detail"
+)
+
+// catalog 各语言的消息模板，参数使用 %[n] 指定位置，以便不同语言调整语序
+var catalog = map[string]map[string]string{
+	LangEN: {
+		MsgInvalidAPIKey:            "Incorrect API key provided.",
+		MsgInvalidAdminKey:          "Invalid admin credentials.",
+		MsgInvalidBody:              "Could not parse the request body: %[1]s",
+		MsgRequestTooLarge:          "The request body exceeds the maximum size of %[1]d bytes.",
+		MsgMessagesEmpty:            "'messages' must contain at least one message.",
+		MsgNoConversationMessages:   "'messages' must contain at least one non-empty user or assistant message; system messages alone do not form a conversation.",
+		MsgTooManyMessages:          "'messages' supports at most %[1]d messages, got %[2]d.",
+		MsgMessageTooLong:           "messages[%[1]d] is too long: at most %[2]d characters are allowed, got %[3]d.",
+		MsgTooManyImages:            "The request contains more than %[1]d images.",
+		MsgImageTooLarge:            "An image in messages[%[1]d] is %[3]d bytes, exceeding the maximum of %[2]d bytes.",
+		MsgInvalidOption:            "Invalid value for '%[1]s': %[2]s. Supported values: %[3]s.",
+		MsgUnsupportedParameter:     "Unsupported parameter: '%[1]s' is not supported by this gateway.",
+		MsgUnknownParameter:         "Unrecognized request argument supplied: %[1]s",
+		MsgMaxTokensConflict:        "max_tokens and max_completion_tokens cannot be set to different values.",
+		MsgMaxTokensTooSmall:        "'%[1]s' must be at least 1, got %[2]d.",
+		MsgStreamOptionsNeedsStream: "stream_options is only allowed when stream is true.",
+		MsgStopInvalidType:          "'stop' must be a string or an array of strings.",
+		MsgStopTooMany:              "'stop' supports at most %[1]d sequences, got %[2]d.",
+		MsgPromptPresetNotFound:     "Prompt preset not found: %[1]s",
+		MsgNTooSmall:                "'n' must be at least 1.",
+		MsgNTooLarge:                "'n' must be at most %[1]d, got %[2]d.",
+		MsgNNotSupported:            "This endpoint does not support n > 1.",
+		MsgModelNotFound:            "The model '%[1]s' does not exist or is not available.",
+		MsgContextLengthExceeded:    "This model's maximum context length is %[2]d tokens, but your messages resulted in about %[3]d tokens (model %[1]s). Please reduce the length of the messages or max_tokens.",
+		MsgParameterOutOfRange:      "Invalid value for '%[2]s': %[4]s is outside the range %[3]s supported by model %[1]s.",
+		MsgConcurrencyLimitExceeded: "Too many concurrent requests for this API key. Please retry later.",
+		MsgUpstreamRateLimited:      "The upstream service is rate limiting requests. Please retry later.",
+		MsgUpstreamTooLarge:         "The upstream service rejected the request as too large. Please reduce the length of the messages.",
+		MsgUpstreamContentPolicy:    "The upstream service rejected the request under its content policy.",
+		MsgUpstreamStatus:           "The upstream service returned an error (HTTP %[1]d).",
+		MsgUpstreamUnavailable:      "Could not reach the upstream service.",
+		MsgUpstreamCircuitOpen:      "The upstream service is temporarily unavailable after repeated failures. Please retry later.",
+		MsgUpstreamEmpty:            "The upstream service returned an empty response.",
+		MsgUpstreamInvalidResponse:  "The upstream service returned an invalid response.",
+		MsgTimeout:                  "The upstream service did not respond in time.",
+		MsgInternalError:            "The server had an error while processing your request.",
+		MsgKeyNameRequired:          "The request body must include a non-empty 'name'.",
+		MsgKeyNotFound:              "API key not found.",
+		MsgKeyRevoked:               "API key has been revoked.",
+		MsgNegativeConcurrency:      "'max_concurrency' must not be negative.",
+		msgUpstreamDetail:           "%[1]s Upstream message: %[2]s",
+	},
+	LangZH: {
+		MsgInvalidAPIKey:            "API密钥无效。",
+		MsgInvalidAdminKey:          "管理凭证无效。",
+		MsgInvalidBody:              "无法解析请求体: %[1]s",
+		MsgRequestTooLarge:          "请求体超过 %[1]d 字节的上限",
+		MsgMessagesEmpty:            "messages 至少需要包含一条消息",
+		MsgNoConversationMessages:   "messages 至少需要包含一条非空的 user 或 assistant 消息，仅有系统消息时无法构成对话",
+		MsgTooManyMessages:          "messages 最多支持 %[1]d 条消息，实际为 %[2]d 条",
+		MsgMessageTooLong:           "messages[%[1]d] 过长：最多 %[2]d 个字符，实际为 %[3]d 个",
+		MsgTooManyImages:            "请求中的图片超过 %[1]d 张",
+		MsgImageTooLarge:            "messages[%[1]d] 中的图片为 %[3]d 字节，超过 %[2]d 字节的上限",
+		MsgInvalidOption:            "%[1]s 的取值无效: %[2]s，可选: %[3]s",
+		MsgUnsupportedParameter:     "不支持的请求字段: %[1]s",
+		MsgUnknownParameter:         "未知的请求字段: %[1]s",
+		MsgMaxTokensConflict:        "max_tokens 和 max_completion_tokens 不能同时设置为不同的值",
+		MsgMaxTokensTooSmall:        "%[1]s 必须大于0，实际为 %[2]d",
+		MsgStreamOptionsNeedsStream: "stream_options 只能在 stream 为 true 时使用",
+		MsgStopInvalidType:          "stop 必须是字符串或字符串数组",
+		MsgStopTooMany:              "stop 最多支持 %[1]d 个序列，实际为 %[2]d 个",
+		MsgPromptPresetNotFound:     "提示词预设不存在: %[1]s",
+		MsgNTooSmall:                "n 必须大于0",
+		MsgNTooLarge:                "n 最大为 %[1]d，实际为 %[2]d",
+		MsgNNotSupported:            "该接口不支持 n > 1",
+		MsgModelNotFound:            "模型不存在或不可用: %[1]s",
+		MsgContextLengthExceeded:    "模型 %[1]s 的上下文窗口为 %[2]d 个token，但请求的消息约为 %[3]d 个token，请减少消息或 max_tokens",
+		MsgParameterOutOfRange:      "模型 %[1]s 的 %[2]s 取值范围为 %[3]s，实际为 %[4]s",
+		MsgConcurrencyLimitExceeded: "该API密钥的并发请求过多，请稍后重试",
+		MsgUpstreamRateLimited:      "上游服务限流，请稍后重试",
+		MsgUpstreamTooLarge:         "上游服务认为请求过大，请减少消息长度",
+		MsgUpstreamContentPolicy:    "上游服务因内容政策拒绝了请求",
+		MsgUpstreamStatus:           "上游服务返回错误 (HTTP %[1]d)",
+		MsgUpstreamUnavailable:      "无法连接上游服务",
+		MsgUpstreamCircuitOpen:      "上游服务连续失败，暂时停止请求，请稍后重试",
+		MsgUpstreamEmpty:            "未从上游服务获取到响应",
+		MsgUpstreamInvalidResponse:  "上游服务响应无法解析",
+		MsgTimeout:                  "上游服务响应超时",
+		MsgInternalError:            "服务器处理请求时出错",
+		MsgKeyNameRequired:          "请求体需要包含非空的 name 字段",
+		MsgKeyNotFound:              "API密钥不存在",
+		MsgKeyRevoked:               "API密钥已被吊销",
+		MsgNegativeConcurrency:      "max_concurrency 不能为负数",
+		msgUpstreamDetail:           "%[1]s。上游信息: %[2]s",
+	},
+}
+
+func lookup(lang, key string) (string, bool) {
+	if messages, ok := catalog[lang]; ok {
+		if format, ok := messages[key]; ok {
+			return format, true
+		}
+	}
+	format, ok := catalog[DefaultLanguage][key]
+	return format, ok
+}
+
+// NegotiateLanguage 按 Accept-Language 的权重选择支持的语言，例如 "zh-CN,zh;q=0.9,en;q=0.8"。
+// 没有匹配的语言时返回默认语言
+func NegotiateLanguage(acceptLanguage string) string {
+	type candidate struct {
+		lang    string
+		quality float64
+	}
+	var candidates []candidate
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if tag == "" {
+			continue
+		}
+		quality := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); err == nil {
+					quality = q
+				}
+			}
+		}
+		primary := strings.SplitN(tag, "-", 2)[0]
+		if _, ok := catalog[primary]; ok && quality > 0 {
+			candidates = append(candidates, candidate{lang: primary, quality: quality})
+		}
+	}
+	if len(candidates) == 0 {
+		return DefaultLanguage
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].quality > candidates[j].quality
+	})
+	return candidates[0].lang
+}
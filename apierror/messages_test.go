@@ -0,0 +1,110 @@
+package apierror
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateLanguage(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", DefaultLanguage},
+		{"en", LangEN},
+		{"EN-us", LangEN},
+		{"zh-CN,zh;q=0.9,en;q=0.8", LangZH},
+		{"en-US,en;q=0.9,zh;q=0.8", LangEN},
+		{"fr-FR,fr;q=0.9", DefaultLanguage},
+		{"fr;q=1.0, en;q=0.5", LangEN},
+		{"zh;q=0.3, en;q=0.7", LangEN},
+		{"en;q=0, zh;q=0.1", LangZH},
+		{"en;q=0", DefaultLanguage},
+		{"en;q=abc", LangEN},
+		{" , ;q=0.5, zh-TW ", LangZH},
+		{"*", DefaultLanguage},
+		{"zh;q=0.8, en;q=0.8", LangZH},
+	}
+	for _, tt := range tests {
+		if got := NegotiateLanguage(tt.header); got != tt.want {
+			t.Errorf("NegotiateLanguage(%q) = %s，期望 %s", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestSetDefaultLanguage(t *testing.T) {
+	saved := DefaultLanguage
+	defer func() { DefaultLanguage = saved }()
+
+	if SetDefaultLanguage("fr") || DefaultLanguage != saved {
+		t.Fatal("不支持的语言不应生效")
+	}
+	if !SetDefaultLanguage(LangEN) || NegotiateLanguage("fr") != LangEN {
+		t.Fatal("默认语言未生效")
+	}
+}
+
+func TestCatalogComplete(t *testing.T) {
+	// 每种语言的消息键和格式参数都应一致，否则切换语言时会退回默认语言或渲染出 %!
+	for lang, messages := range catalog {
+		for other, otherMessages := range catalog {
+			for key, format := range messages {
+				otherFormat, ok := otherMessages[key]
+				if !ok {
+					t.Errorf("%s 缺少消息 %s（%s 中有）", other, key, lang)
+					continue
+				}
+				for i := 1; i <= 4; i++ {
+					verb := "%[" + string(rune('0'+i)) + "]"
+					if strings.Contains(format, verb) != strings.Contains(otherFormat, verb) {
+						t.Errorf("消息 %s 的参数 %s 在 %s 和 %s 中不一致", key, verb, lang, other)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestErrorMessage(t *testing.T) {
+	err := InvalidRequest("n", MsgNTooLarge, 8, 10)
+	if got := err.Message(LangEN); got != "'n' must be at most 8, got 10." {
+		t.Errorf("英文 = %q", got)
+	}
+	if got := err.Message(LangZH); got != "n 最大为 8，实际为 10" {
+		t.Errorf("中文 = %q", got)
+	}
+	if got, want := err.Message("fr"), err.Message(DefaultLanguage); got != want {
+		t.Errorf("不支持的语言 = %q，期望默认语言 %q", got, want)
+	}
+
+	detailed := UpstreamStatus(503).WithDetail("overloaded")
+	if got := detailed.Message(LangEN); !strings.HasSuffix(got, "overloaded") || !strings.Contains(got, "503") {
+		t.Errorf("带上游信息 = %q", got)
+	}
+
+	if got := New(http.StatusBadRequest, TypeInvalidRequest, "", "no_such_key").Message(LangEN); got != "no_such_key" {
+		t.Errorf("未知消息键 = %q", got)
+	}
+}
+
+func TestErrorBodyAndFrom(t *testing.T) {
+	body := ModelNotFound("x").Body(LangEN)["error"].(map[string]interface{})
+	if body["param"] != "model" || body["code"] != CodeModelNotFound || body["type"] != TypeInvalidRequest {
+		t.Errorf("Body = %v", body)
+	}
+	if body := InvalidRequest("", MsgMessagesEmpty).Body(LangEN)["error"].(map[string]interface{}); body["param"] != nil || body["code"] != nil {
+		t.Errorf("空的 param 和 code 应为 null: %v", body)
+	}
+
+	cause := errors.New("boom")
+	internal := From(cause)
+	if internal.Status != http.StatusInternalServerError || !errors.Is(internal, cause) {
+		t.Errorf("From(普通错误) = %+v", internal)
+	}
+	original := Timeout()
+	if From(original) != original {
+		t.Error("From 应原样返回 *Error")
+	}
+}
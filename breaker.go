@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// 熔断相关环境变量
+const (
+	ENV_BREAKER_THRESHOLD = "E2B_BREAKER_THRESHOLD" // 上游连续失败多少次后熔断，0表示不熔断
+	ENV_BREAKER_COOLDOWN  = "E2B_BREAKER_COOLDOWN"  // 熔断后等待多久放行一次试探请求
+)
+
+// 熔断器状态
+const (
+	BREAKER_CLOSED    = "closed"    // 正常放行
+	BREAKER_OPEN      = "open"      // 熔断中，直接拒绝
+	BREAKER_HALF_OPEN = "half_open" // 冷却结束，放行一次试探请求
+)
+
+// Breaker 上游熔断器。连续的不可用、超时或无效响应达到阈值后熔断，
+// 冷却期结束后放行一次试探请求，成功则恢复，失败则重新熔断
+type Breaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	state     string
+	failures  int
+	openedAt  time.Time
+	trial     bool
+	opens     int64
+}
+
+// BreakerStats 熔断器状态，用于健康检查和管理接口
+type BreakerStats struct {
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	Threshold           int        `json:"threshold"`
+	OpenedAt            *time.Time `json:"opened_at,omitempty"`
+	RetryAt             *time.Time `json:"retry_at,omitempty"`
+	Opens               int64      `json:"opens"`
+}
+
+// upstreamBreaker 全局上游熔断器，在 init 中按配置创建
+var upstreamBreaker = NewBreaker(0, 0)
+
+// NewBreaker 创建熔断器，threshold 不大于0时总是放行
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{threshold: threshold, cooldown: cooldown, state: BREAKER_CLOSED}
+}
+
+// Allow 判断是否放行一次上游调用。熔断中返回 false 和距离冷却结束的时间
+func (b *Breaker) Allow() (bool, time.Duration) {
+	if b.threshold <= 0 {
+		return true, 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BREAKER_OPEN:
+		if wait := b.cooldown - time.Since(b.openedAt); wait > 0 {
+			return false, wait
+		}
+		b.state = BREAKER_HALF_OPEN
+		b.trial = true
+		return true, 0
+	case BREAKER_HALF_OPEN:
+		// 同一时间只放行一个试探请求
+		if b.trial {
+			return false, b.cooldown
+		}
+		b.trial = true
+		return true, 0
+	}
+	return true, 0
+}
+
+// Success 记录一次上游正常响应
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = BREAKER_CLOSED
+	b.failures = 0
+	b.trial = false
+}
+
+// Failure 记录一次上游不可用，返回熔断器是否因此进入熔断
+func (b *Breaker) Failure() bool {
+	if b.threshold <= 0 {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.trial = false
+	if b.state == BREAKER_HALF_OPEN || (b.state == BREAKER_CLOSED && b.failures >= b.threshold) {
+		b.state = BREAKER_OPEN
+		b.openedAt = time.Now()
+		b.opens++
+		return true
+	}
+	return false
+}
+
+// Release 记录一次既不说明上游恢复、也不说明上游不可用的结果，例如限流、请求被拒绝或调用方取消。
+// 连续失败次数保持不变，试探请求的名额交还给下一个请求
+func (b *Breaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+}
+
+// Stats 返回熔断器状态
+func (b *Breaker) Stats() BreakerStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stats := BreakerStats{
+		State:               b.state,
+		ConsecutiveFailures: b.failures,
+		Threshold:           b.threshold,
+		Opens:               b.opens,
+	}
+	if b.state != BREAKER_CLOSED {
+		openedAt := b.openedAt
+		retryAt := openedAt.Add(b.cooldown)
+		stats.OpenedAt = &openedAt
+		stats.RetryAt = &retryAt
+	}
+	return stats
+}
+
+// recordBreakerResult 按上游调用结果更新熔断器。只有解析成功且有内容的2xx响应才算成功，
+// 不可用、超时和无效响应算作失败，限流、内容政策、上下文长度等错误不改变熔断器状态
+func recordBreakerResult(requestID string, failure *upstreamError) {
+	switch {
+	case failure == nil:
+		upstreamBreaker.Success()
+	case failure.Class == UPSTREAM_ERROR_OUTAGE || failure.Class == UPSTREAM_ERROR_TIMEOUT || failure.Class == UPSTREAM_ERROR_INVALID_RESPONSE:
+		if upstreamBreaker.Failure() {
+			stats := upstreamBreaker.Stats()
+			logError(requestID, fmt.Sprintf("上游连续失败 %d 次，已熔断，%s 后放行试探请求", stats.ConsecutiveFailures, CONFIG.BREAKER.COOLDOWN), failure)
+		}
+	default:
+		upstreamBreaker.Release()
+	}
+}
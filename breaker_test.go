@@ -0,0 +1,150 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yourusername/e2b-api-gateway/apierror"
+)
+
+func TestBreakerTransitions(t *testing.T) {
+	type step struct {
+		action    string // allow, success, failure, release, wait
+		wantAllow bool
+		wantState string
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"未达阈值保持关闭", []step{
+			{action: "failure", wantState: BREAKER_CLOSED},
+			{action: "allow", wantAllow: true, wantState: BREAKER_CLOSED},
+		}},
+		{"达到阈值后熔断", []step{
+			{action: "failure", wantState: BREAKER_CLOSED},
+			{action: "failure", wantState: BREAKER_OPEN},
+			{action: "allow", wantAllow: false, wantState: BREAKER_OPEN},
+		}},
+		{"成功清零连续失败", []step{
+			{action: "failure", wantState: BREAKER_CLOSED},
+			{action: "success", wantState: BREAKER_CLOSED},
+			{action: "failure", wantState: BREAKER_CLOSED},
+		}},
+		{"非失败非成功的结果不清零", []step{
+			{action: "failure", wantState: BREAKER_CLOSED},
+			{action: "release", wantState: BREAKER_CLOSED},
+			{action: "failure", wantState: BREAKER_OPEN},
+		}},
+		{"冷却后只放行一个试探请求，成功则恢复", []step{
+			{action: "failure"}, {action: "failure", wantState: BREAKER_OPEN},
+			{action: "wait"},
+			{action: "allow", wantAllow: true, wantState: BREAKER_HALF_OPEN},
+			{action: "allow", wantAllow: false, wantState: BREAKER_HALF_OPEN},
+			{action: "success", wantState: BREAKER_CLOSED},
+			{action: "allow", wantAllow: true, wantState: BREAKER_CLOSED},
+		}},
+		{"试探失败重新熔断", []step{
+			{action: "failure"}, {action: "failure", wantState: BREAKER_OPEN},
+			{action: "wait"},
+			{action: "allow", wantAllow: true, wantState: BREAKER_HALF_OPEN},
+			{action: "failure", wantState: BREAKER_OPEN},
+			{action: "allow", wantAllow: false, wantState: BREAKER_OPEN},
+		}},
+		{"试探得到限流等结果时不恢复，名额交给下一个请求", []step{
+			{action: "failure"}, {action: "failure", wantState: BREAKER_OPEN},
+			{action: "wait"},
+			{action: "allow", wantAllow: true, wantState: BREAKER_HALF_OPEN},
+			{action: "release", wantState: BREAKER_HALF_OPEN},
+			{action: "allow", wantAllow: true, wantState: BREAKER_HALF_OPEN},
+			{action: "success", wantState: BREAKER_CLOSED},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBreaker(2, 20*time.Millisecond)
+			for i, s := range tt.steps {
+				switch s.action {
+				case "allow":
+					if ok, _ := b.Allow(); ok != s.wantAllow {
+						t.Fatalf("第%d步 Allow = %v，期望 %v", i, ok, s.wantAllow)
+					}
+				case "success":
+					b.Success()
+				case "failure":
+					b.Failure()
+				case "release":
+					b.Release()
+				case "wait":
+					time.Sleep(30 * time.Millisecond)
+				}
+				if s.wantState != "" {
+					if state := b.Stats().State; state != s.wantState {
+						t.Fatalf("第%d步(%s)后状态 = %s，期望 %s", i, s.action, state, s.wantState)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestBreakerDisabled(t *testing.T) {
+	b := NewBreaker(0, time.Minute)
+	for i := 0; i < 10; i++ {
+		if b.Failure() {
+			t.Fatal("阈值为0时不应熔断")
+		}
+	}
+	if ok, _ := b.Allow(); !ok {
+		t.Fatal("阈值为0时应总是放行")
+	}
+}
+
+func TestBreakerOpenWaitAndStats(t *testing.T) {
+	b := NewBreaker(1, time.Minute)
+	b.Failure()
+	ok, wait := b.Allow()
+	if ok || wait <= 0 || wait > time.Minute {
+		t.Fatalf("Allow = %v, %s", ok, wait)
+	}
+	stats := b.Stats()
+	if stats.Opens != 1 || stats.OpenedAt == nil || stats.RetryAt == nil || stats.RetryAt.Sub(*stats.OpenedAt) != time.Minute {
+		t.Fatalf("Stats = %+v", stats)
+	}
+}
+
+func TestRecordBreakerResult(t *testing.T) {
+	saved := upstreamBreaker
+	defer func() { upstreamBreaker = saved }()
+
+	tests := []struct {
+		class     string
+		wantState string
+	}{
+		{UPSTREAM_ERROR_OUTAGE, BREAKER_OPEN},
+		{UPSTREAM_ERROR_TIMEOUT, BREAKER_OPEN},
+		{UPSTREAM_ERROR_INVALID_RESPONSE, BREAKER_OPEN},
+		{UPSTREAM_ERROR_RATE_LIMIT, BREAKER_HALF_OPEN},
+		{UPSTREAM_ERROR_CONTEXT_LENGTH, BREAKER_HALF_OPEN},
+		{UPSTREAM_ERROR_REJECTED, BREAKER_HALF_OPEN},
+		{"", BREAKER_CLOSED},
+	}
+	for _, tt := range tests {
+		t.Run(tt.class, func(t *testing.T) {
+			upstreamBreaker = NewBreaker(1, time.Millisecond)
+			upstreamBreaker.Failure()
+			time.Sleep(2 * time.Millisecond)
+			if ok, _ := upstreamBreaker.Allow(); !ok {
+				t.Fatal("冷却后应放行试探请求")
+			}
+			var failure *upstreamError
+			if tt.class != "" {
+				failure = &upstreamError{Class: tt.class, api: apierror.UpstreamUnavailable()}
+			}
+			recordBreakerResult("test", failure)
+			if state := upstreamBreaker.Stats().State; state != tt.wantState {
+				t.Errorf("半开状态下 %q 之后状态 = %s，期望 %s", tt.class, state, tt.wantState)
+			}
+		})
+	}
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 缓存相关环境变量
+const (
+	ENV_CACHE_BACKEND     = "E2B_CACHE_BACKEND"     // 缓存后端: 空(关闭), memory, disk
+	ENV_CACHE_TTL         = "E2B_CACHE_TTL"         // 缓存有效期，例如 10m
+	ENV_CACHE_MAX_ENTRIES = "E2B_CACHE_MAX_ENTRIES" // 最大缓存条目数
+	ENV_CACHE_MAX_BYTES   = "E2B_CACHE_MAX_BYTES"   // 最大缓存字节数
+	ENV_CACHE_DIR         = "E2B_CACHE_DIR"         // disk 后端的缓存目录
+)
+
+// 缓存后端类型
+const (
+	CACHE_BACKEND_MEMORY = "memory"
+	CACHE_BACKEND_DISK   = "disk"
+)
+
+// x-cache 响应头的取值
+const (
+	CACHE_STATUS_HIT    = "HIT"
+	CACHE_STATUS_MISS   = "MISS"
+	CACHE_STATUS_BYPASS = "BYPASS"
+)
+
+// ResponseCache 上游响应缓存
+type ResponseCache interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte)
+	Stats() CacheStats
+}
+
+// CacheStats 缓存统计信息
+type CacheStats struct {
+	Backend   string `json:"backend"`
+	Entries   int    `json:"entries"`
+	Bytes     int64  `json:"bytes"`
+	Hits      int64  `json:"hits"`
+	Misses    int64  `json:"misses"`
+	Evictions int64  `json:"evictions"`
+}
+
+// responseCache 全局缓存实例，未启用时为nil
+var responseCache ResponseCache
+
+// initResponseCache 根据配置创建缓存后端
+func initResponseCache() {
+	switch CONFIG.CACHE.BACKEND {
+	case "":
+		return
+	case CACHE_BACKEND_MEMORY:
+		responseCache = NewMemoryCache(CONFIG.CACHE.TTL, CONFIG.CACHE.MAX_ENTRIES, CONFIG.CACHE.MAX_BYTES)
+	case CACHE_BACKEND_DISK:
+		cache, err := NewDiskCache(CONFIG.CACHE.DIR, CONFIG.CACHE.TTL, CONFIG.CACHE.MAX_ENTRIES, CONFIG.CACHE.MAX_BYTES)
+		if err != nil {
+			log.Printf("警告: 初始化磁盘缓存失败，缓存已关闭: %v", err)
+			return
+		}
+		responseCache = cache
+	default:
+		log.Printf("警告: 未知的缓存后端 %q，缓存已关闭", CONFIG.CACHE.BACKEND)
+		return
+	}
+	log.Printf("响应缓存已启用: 后端=%s, TTL=%s, 最大条目=%d, 最大字节=%d",
+		CONFIG.CACHE.BACKEND, CONFIG.CACHE.TTL, CONFIG.CACHE.MAX_ENTRIES, CONFIG.CACHE.MAX_BYTES)
+}
+
+// CacheKey 由规范化后的E2B请求计算缓存键，userID不参与计算
+func CacheKey(request E2BRequest) (string, error) {
+	request.UserID = ""
+	data, err := json.Marshal(request)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// cacheDirectives 解析请求的 Cache-Control 头，返回是否允许读取和写入缓存
+func cacheDirectives(header string) (read bool, write bool) {
+	read, write = true, true
+	for _, directive := range strings.Split(header, ",") {
+		switch strings.ToLower(strings.TrimSpace(directive)) {
+		case "no-cache":
+			read = false
+		case "no-store":
+			read, write = false, false
+		}
+	}
+	return read, write
+}
+
+// memoryEntry 内存缓存条目
+type memoryEntry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time
+}
+
+// MemoryCache 带TTL和容量限制的内存LRU缓存
+type MemoryCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	maxBytes   int64
+	bytes      int64
+	ll         *list.List
+	items      map[string]*list.Element
+	hits       int64
+	misses     int64
+	evictions  int64
+}
+
+// NewMemoryCache 创建内存LRU缓存
+func NewMemoryCache(ttl time.Duration, maxEntries int, maxBytes int64) *MemoryCache {
+	return &MemoryCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+// Get 读取缓存，过期条目会被删除
+func (m *MemoryCache) Get(key string) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	elem, ok := m.items[key]
+	if !ok {
+		m.misses++
+		return nil, false
+	}
+	entry := elem.Value.(*memoryEntry)
+	if time.Now().After(entry.expiresAt) {
+		m.removeElement(elem)
+		m.misses++
+		return nil, false
+	}
+	m.ll.MoveToFront(elem)
+	m.hits++
+	return entry.value, true
+}
+
+// Set 写入缓存，超出容量时淘汰最久未使用的条目
+func (m *MemoryCache) Set(key string, value []byte) {
+	if m.maxBytes > 0 && int64(len(value)) > m.maxBytes {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if elem, ok := m.items[key]; ok {
+		m.removeElement(elem)
+	}
+	entry := &memoryEntry{key: key, value: value, expiresAt: time.Now().Add(m.ttl)}
+	m.items[key] = m.ll.PushFront(entry)
+	m.bytes += int64(len(value))
+
+	for m.overLimit() {
+		oldest := m.ll.Back()
+		if oldest == nil {
+			break
+		}
+		m.removeElement(oldest)
+		m.evictions++
+	}
+}
+
+// Stats 返回缓存统计
+func (m *MemoryCache) Stats() CacheStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return CacheStats{
+		Backend:   CACHE_BACKEND_MEMORY,
+		Entries:   m.ll.Len(),
+		Bytes:     m.bytes,
+		Hits:      m.hits,
+		Misses:    m.misses,
+		Evictions: m.evictions,
+	}
+}
+
+func (m *MemoryCache) overLimit() bool {
+	return (m.maxEntries > 0 && m.ll.Len() > m.maxEntries) || (m.maxBytes > 0 && m.bytes > m.maxBytes)
+}
+
+func (m *MemoryCache) removeElement(elem *list.Element) {
+	entry := elem.Value.(*memoryEntry)
+	m.ll.Remove(elem)
+	delete(m.items, entry.key)
+	m.bytes -= int64(len(entry.value))
+}
+
+// diskEntry 磁盘缓存文件格式
+type diskEntry struct {
+	ExpiresAt int64           `json:"expires_at"`
+	Value     json.RawMessage `json:"value"`
+}
+
+// diskIndexEntry 磁盘缓存在内存中的索引，用于LRU淘汰
+type diskIndexEntry struct {
+	size       int64
+	lastAccess time.Time
+}
+
+// DiskCache 基于目录的磁盘缓存，每个条目一个文件，重启后仍然有效
+type DiskCache struct {
+	mu         sync.Mutex
+	dir        string
+	ttl        time.Duration
+	maxEntries int
+	maxBytes   int64
+	bytes      int64
+	index      map[string]*diskIndexEntry
+	hits       int64
+	misses     int64
+	evictions  int64
+}
+
+// NewDiskCache 创建磁盘缓存并加载已有条目的索引
+func NewDiskCache(dir string, ttl time.Duration, maxEntries int, maxBytes int64) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	d := &DiskCache{
+		dir:        dir,
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		index:      make(map[string]*diskIndexEntry),
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+		key := strings.TrimSuffix(name, ".json")
+		d.index[key] = &diskIndexEntry{size: info.Size(), lastAccess: info.ModTime()}
+		d.bytes += info.Size()
+	}
+	d.evictLocked()
+	return d, nil
+}
+
+// Get 读取磁盘缓存
+func (d *DiskCache) Get(key string) ([]byte, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	idx, ok := d.index[key]
+	if !ok {
+		d.misses++
+		return nil, false
+	}
+
+	data, err := os.ReadFile(d.path(key))
+	var entry diskEntry
+	if err == nil {
+		err = json.Unmarshal(data, &entry)
+	}
+	if err != nil || time.Now().Unix() > entry.ExpiresAt {
+		d.removeLocked(key)
+		d.misses++
+		return nil, false
+	}
+
+	now := time.Now()
+	idx.lastAccess = now
+	os.Chtimes(d.path(key), now, now)
+	d.hits++
+	return entry.Value, true
+}
+
+// Set 写入磁盘缓存，先写临时文件再重命名，避免读到半个文件
+func (d *DiskCache) Set(key string, value []byte) {
+	data, err := json.Marshal(diskEntry{
+		ExpiresAt: time.Now().Add(d.ttl).Unix(),
+		Value:     value,
+	})
+	if err != nil {
+		return
+	}
+	if d.maxBytes > 0 && int64(len(data)) > d.maxBytes {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tmp, err := os.CreateTemp(d.dir, "tmp-*")
+	if err != nil {
+		log.Printf("写入磁盘缓存失败: %v", err)
+		return
+	}
+	_, err = tmp.Write(data)
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), d.path(key))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		log.Printf("写入磁盘缓存失败: %v", err)
+		return
+	}
+
+	if old, ok := d.index[key]; ok {
+		d.bytes -= old.size
+	}
+	d.index[key] = &diskIndexEntry{size: int64(len(data)), lastAccess: time.Now()}
+	d.bytes += int64(len(data))
+	d.evictLocked()
+}
+
+// Stats 返回缓存统计
+func (d *DiskCache) Stats() CacheStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return CacheStats{
+		Backend:   CACHE_BACKEND_DISK,
+		Entries:   len(d.index),
+		Bytes:     d.bytes,
+		Hits:      d.hits,
+		Misses:    d.misses,
+		Evictions: d.evictions,
+	}
+}
+
+// evictLocked 按最近访问时间淘汰条目，直到满足容量限制
+func (d *DiskCache) evictLocked() {
+	overLimit := func() bool {
+		return (d.maxEntries > 0 && len(d.index) > d.maxEntries) || (d.maxBytes > 0 && d.bytes > d.maxBytes)
+	}
+	if !overLimit() {
+		return
+	}
+
+	keys := make([]string, 0, len(d.index))
+	for key := range d.index {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return d.index[keys[i]].lastAccess.Before(d.index[keys[j]].lastAccess)
+	})
+	for _, key := range keys {
+		if !overLimit() {
+			break
+		}
+		d.removeLocked(key)
+		d.evictions++
+	}
+}
+
+func (d *DiskCache) removeLocked(key string) {
+	if idx, ok := d.index[key]; ok {
+		d.bytes -= idx.size
+		delete(d.index, key)
+	}
+	os.Remove(d.path(key))
+}
+
+func (d *DiskCache) path(key string) string {
+	return filepath.Join(d.dir, fmt.Sprintf("%s.json", key))
+}
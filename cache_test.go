@@ -0,0 +1,180 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryCacheLRUEviction(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxEntries int
+		maxBytes   int64
+		sets       []string // 依次写入的键，值为键本身
+		gets       []string // 写入过程中在最后一次写入前读取的键
+		wantKeys   []string
+		wantGone   []string
+	}{
+		{
+			name:       "按条目数淘汰最旧的",
+			maxEntries: 2,
+			sets:       []string{"a", "b", "c"},
+			wantKeys:   []string{"b", "c"},
+			wantGone:   []string{"a"},
+		},
+		{
+			name:       "读取会刷新最近使用",
+			maxEntries: 2,
+			sets:       []string{"a", "b", "c"},
+			gets:       []string{"a"},
+			wantKeys:   []string{"a", "c"},
+			wantGone:   []string{"b"},
+		},
+		{
+			name:     "按字节数淘汰",
+			maxBytes: 5,
+			sets:     []string{"aa", "bb", "cc"},
+			wantKeys: []string{"bb", "cc"},
+			wantGone: []string{"aa"},
+		},
+		{
+			name:     "单个值超过上限时不写入",
+			maxBytes: 2,
+			sets:     []string{"a", "ccc"},
+			wantKeys: []string{"a"},
+			wantGone: []string{"ccc"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := NewMemoryCache(time.Minute, tt.maxEntries, tt.maxBytes)
+			for i, key := range tt.sets {
+				if i == len(tt.sets)-1 {
+					for _, get := range tt.gets {
+						cache.Get(get)
+					}
+				}
+				cache.Set(key, []byte(key))
+			}
+			for _, key := range tt.wantKeys {
+				if value, ok := cache.Get(key); !ok || string(value) != key {
+					t.Errorf("Get(%q) = %q, %v，期望命中", key, value, ok)
+				}
+			}
+			for _, key := range tt.wantGone {
+				if _, ok := cache.Get(key); ok {
+					t.Errorf("Get(%q) 命中，期望已淘汰", key)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryCacheStatsAndExpiry(t *testing.T) {
+	cache := NewMemoryCache(-time.Second, 0, 0)
+	cache.Set("a", []byte("value"))
+	if _, ok := cache.Get("a"); ok {
+		t.Fatal("过期条目仍然命中")
+	}
+	stats := cache.Stats()
+	if stats.Entries != 0 || stats.Bytes != 0 || stats.Misses != 1 {
+		t.Fatalf("统计 = %+v，期望过期条目已删除并计一次未命中", stats)
+	}
+
+	cache = NewMemoryCache(time.Minute, 1, 0)
+	cache.Set("a", []byte("1"))
+	cache.Set("a", []byte("22"))
+	cache.Set("b", []byte("3"))
+	stats = cache.Stats()
+	if stats.Entries != 1 || stats.Bytes != 1 || stats.Evictions != 1 {
+		t.Fatalf("统计 = %+v，覆盖写入不应重复计算字节数", stats)
+	}
+}
+
+func TestDiskCache(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := NewDiskCache(dir, time.Minute, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Set("a", []byte(`{"v":"a"}`))
+	time.Sleep(5 * time.Millisecond)
+	cache.Set("b", []byte(`{"v":"b"}`))
+	time.Sleep(5 * time.Millisecond)
+	if value, ok := cache.Get("a"); !ok || string(value) != `{"v":"a"}` {
+		t.Fatalf("Get(a) = %s, %v", value, ok)
+	}
+	time.Sleep(5 * time.Millisecond)
+	cache.Set("c", []byte(`{"v":"c"}`))
+
+	if _, ok := cache.Get("b"); ok {
+		t.Error("最久未访问的 b 应被淘汰")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "b.json")); !os.IsNotExist(err) {
+		t.Error("被淘汰条目的文件应被删除")
+	}
+
+	// 重启后从目录恢复索引
+	reopened, err := NewDiskCache(dir, time.Minute, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := reopened.Get(key); !ok {
+			t.Errorf("重新打开后 Get(%q) 未命中", key)
+		}
+	}
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), "tmp-") {
+			t.Errorf("残留临时文件: %s", entry.Name())
+		}
+	}
+}
+
+func TestDiskCacheExpiredAndCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := NewDiskCache(dir, -2*time.Second, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Set("expired", []byte(`1`))
+	if _, ok := cache.Get("expired"); ok {
+		t.Error("过期条目仍然命中")
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "corrupt.json"), []byte("not json"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cache, err = NewDiskCache(dir, time.Minute, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.Get("corrupt"); ok {
+		t.Error("损坏的条目不应命中")
+	}
+	if stats := cache.Stats(); stats.Entries != 0 {
+		t.Errorf("统计 = %+v，损坏的条目应被删除", stats)
+	}
+}
+
+func TestCacheDirectives(t *testing.T) {
+	tests := []struct {
+		header      string
+		read, write bool
+	}{
+		{"", true, true},
+		{"no-cache", false, true},
+		{"no-store", false, false},
+		{"max-age=0, No-Cache", false, true},
+	}
+	for _, tt := range tests {
+		read, write := cacheDirectives(tt.header)
+		if read != tt.read || write != tt.write {
+			t.Errorf("cacheDirectives(%q) = %v, %v，期望 %v, %v", tt.header, read, write, tt.read, tt.write)
+		}
+	}
+}
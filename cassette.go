@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 录制/回放相关环境变量
+const (
+	ENV_UPSTREAM_MODE  = "E2B_UPSTREAM_MODE"  // 上游模式: live, record, replay
+	ENV_CASSETTE_FILE  = "E2B_CASSETTE_FILE"  // 录制文件路径(JSONL)
+	ENV_CASSETTE_MATCH = "E2B_CASSETTE_MATCH" // 回放匹配规则，逗号分隔: model, messages, config, template
+)
+
+// 上游模式
+const (
+	UPSTREAM_MODE_LIVE   = "live"
+	UPSTREAM_MODE_RECORD = "record"
+	UPSTREAM_MODE_REPLAY = "replay"
+)
+
+const redactedValue = "[REDACTED]"
+
+// upstreamClient 发送上游请求使用的客户端，录制/回放通过替换Transport实现
+var upstreamClient = &http.Client{}
+
+// CassetteInteraction 录制文件中的一条请求/响应记录
+type CassetteInteraction struct {
+	RecordedAt time.Time        `json:"recorded_at"`
+	Request    CassetteRequest  `json:"request"`
+	Response   CassetteResponse `json:"response"`
+}
+
+// CassetteRequest 录制的上游请求
+type CassetteRequest struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	Body    json.RawMessage   `json:"body"`
+}
+
+// CassetteResponse 录制的上游响应
+type CassetteResponse struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+}
+
+// initUpstreamClient 根据上游模式配置客户端的Transport
+func initUpstreamClient() {
+	switch CONFIG.UPSTREAM.MODE {
+	case "", UPSTREAM_MODE_LIVE:
+		return
+	case UPSTREAM_MODE_RECORD:
+		transport, err := newRecordingTransport(http.DefaultTransport, CONFIG.UPSTREAM.CASSETTE_FILE)
+		if err != nil {
+			log.Fatalf("打开录制文件失败: %v", err)
+		}
+		upstreamClient.Transport = transport
+		log.Printf("上游录制模式: 请求将写入 %s", CONFIG.UPSTREAM.CASSETTE_FILE)
+	case UPSTREAM_MODE_REPLAY:
+		transport, err := newReplayTransport(CONFIG.UPSTREAM.CASSETTE_FILE, CONFIG.UPSTREAM.CASSETTE_MATCH)
+		if err != nil {
+			log.Fatalf("加载录制文件失败: %v", err)
+		}
+		upstreamClient.Transport = transport
+		log.Printf("上游回放模式: 从 %s 加载了 %d 条记录, 匹配规则: %s",
+			CONFIG.UPSTREAM.CASSETTE_FILE, len(transport.interactions), strings.Join(CONFIG.UPSTREAM.CASSETTE_MATCH, ","))
+	default:
+		log.Fatalf("未知的上游模式: %s", CONFIG.UPSTREAM.MODE)
+	}
+}
+
+// recordingTransport 透传请求并把请求/响应对追加写入录制文件
+type recordingTransport struct {
+	base http.RoundTripper
+	mu   sync.Mutex
+	file *os.File
+}
+
+func newRecordingTransport(base http.RoundTripper, path string) (*recordingTransport, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &recordingTransport{base: base, file: file}, nil
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		data, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		reqBody = data
+		req.Body = io.NopCloser(bytes.NewReader(data))
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	interaction := CassetteInteraction{
+		RecordedAt: time.Now().UTC(),
+		Request: CassetteRequest{
+			Method:  req.Method,
+			URL:     req.URL.String(),
+			Headers: redactHeaders(req.Header),
+			Body:    redactJSONBody(reqBody),
+		},
+		Response: CassetteResponse{
+			Status:  resp.StatusCode,
+			Headers: redactHeaders(resp.Header),
+			Body:    string(respBody),
+		},
+	}
+	if line, err := json.Marshal(interaction); err == nil {
+		t.mu.Lock()
+		_, err = t.file.Write(append(line, '\n'))
+		t.mu.Unlock()
+		if err != nil {
+			log.Printf("写入录制文件失败: %v", err)
+		}
+	}
+
+	return resp, nil
+}
+
+// replayTransport 根据匹配规则从录制文件中返回响应，不会访问网络
+type replayTransport struct {
+	match        []string
+	interactions []CassetteInteraction
+	mu           sync.Mutex
+	queues       map[string][]int
+	cursors      map[string]int
+}
+
+func newReplayTransport(path string, match []string) (*replayTransport, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	t := &replayTransport{
+		match:   match,
+		queues:  make(map[string][]int),
+		cursors: make(map[string]int),
+	}
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64<<20)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var interaction CassetteInteraction
+		if err := json.Unmarshal(line, &interaction); err != nil {
+			return nil, fmt.Errorf("第%d行解析失败: %w", lineNo, err)
+		}
+		key, err := t.matchKey(interaction.Request.Body)
+		if err != nil {
+			return nil, fmt.Errorf("第%d行请求体无效: %w", lineNo, err)
+		}
+		t.queues[key] = append(t.queues[key], len(t.interactions))
+		t.interactions = append(t.interactions, interaction)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		data, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = data
+	}
+
+	key, err := t.matchKey(body)
+	if err != nil {
+		return nil, fmt.Errorf("cassette: 无法解析请求体: %w", err)
+	}
+
+	// 同一请求录制了多次时按顺序回放，用完后重复最后一条
+	t.mu.Lock()
+	queue := t.queues[key]
+	if len(queue) == 0 {
+		t.mu.Unlock()
+		return nil, fmt.Errorf("cassette: 未找到匹配的录制记录 (匹配规则: %s)", strings.Join(t.match, ","))
+	}
+	cursor := t.cursors[key]
+	if cursor < len(queue)-1 {
+		t.cursors[key] = cursor + 1
+	}
+	interaction := t.interactions[queue[cursor]]
+	t.mu.Unlock()
+
+	header := make(http.Header)
+	for name, value := range interaction.Response.Headers {
+		header.Set(name, value)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", interaction.Response.Status, http.StatusText(interaction.Response.Status)),
+		StatusCode:    interaction.Response.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(interaction.Response.Body)),
+		ContentLength: int64(len(interaction.Response.Body)),
+		Request:       req,
+	}, nil
+}
+
+// matchKey 按匹配规则从E2B请求体中提取字段并计算匹配键
+func (t *replayTransport) matchKey(body []byte) (string, error) {
+	var request E2BRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		return "", err
+	}
+
+	parts := make(map[string]interface{}, len(t.match))
+	for _, field := range t.match {
+		switch field {
+		case "model":
+			parts[field] = request.Model.ID
+		case "messages":
+			parts[field] = request.Messages
+		case "config":
+			parts[field] = request.Config
+		case "template":
+			parts[field] = request.Template
+		}
+	}
+	data, err := json.Marshal(parts)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// parseCassetteMatch 解析匹配规则配置，忽略未知字段
+func parseCassetteMatch(value string) []string {
+	var fields []string
+	for _, field := range strings.Split(value, ",") {
+		field = strings.ToLower(strings.TrimSpace(field))
+		switch field {
+		case "model", "messages", "config", "template":
+			fields = append(fields, field)
+		case "":
+		default:
+			log.Printf("警告: 未知的回放匹配字段 %q 已忽略", field)
+		}
+	}
+	return fields
+}
+
+// redactHeaders 复制请求头并遮盖敏感字段
+func redactHeaders(header http.Header) map[string]string {
+	redacted := make(map[string]string, len(header))
+	for name, values := range header {
+		value := strings.Join(values, ", ")
+		if isSensitiveName(name) {
+			value = redactedValue
+		}
+		redacted[strings.ToLower(name)] = value
+	}
+	return redacted
+}
+
+// redactJSONBody 遮盖JSON请求体中名称敏感的字段，非JSON内容原样保存为字符串
+func redactJSONBody(body []byte) json.RawMessage {
+	if len(body) == 0 {
+		return json.RawMessage("null")
+	}
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		quoted, _ := json.Marshal(string(body))
+		return quoted
+	}
+	data, err := json.Marshal(redactJSONValue(value))
+	if err != nil {
+		return json.RawMessage("null")
+	}
+	return data
+}
+
+func redactJSONValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			if isSensitiveName(key) {
+				v[key] = redactedValue
+				continue
+			}
+			v[key] = redactJSONValue(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactJSONValue(item)
+		}
+	}
+	return value
+}
+
+func isSensitiveName(name string) bool {
+	normalized := strings.NewReplacer("-", "", "_", "").Replace(strings.ToLower(name))
+	for _, suffix := range []string{"authorization", "cookie", "apikey", "token", "secret", "password"} {
+		if strings.HasSuffix(normalized, suffix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReplayMatchKey(t *testing.T) {
+	base := `{"userID":"u1","model":{"id":"m1"},"messages":[{"role":"user","content":"hi"}],"template":{"t":1},"config":{"temperature":0.5}}`
+	tests := []struct {
+		name  string
+		match []string
+		other string
+		equal bool
+	}{
+		{"userID 不参与匹配", []string{"model", "messages", "config", "template"},
+			`{"userID":"u2","model":{"id":"m1"},"messages":[{"role":"user","content":"hi"}],"template":{"t":1},"config":{"temperature":0.5}}`, true},
+		{"消息不同", []string{"model", "messages"},
+			`{"model":{"id":"m1"},"messages":[{"role":"user","content":"bye"}]}`, false},
+		{"只按模型匹配时忽略消息", []string{"model"},
+			`{"model":{"id":"m1"},"messages":[{"role":"user","content":"bye"}]}`, true},
+		{"模型不同", []string{"model"},
+			`{"model":{"id":"m2"}}`, false},
+		{"config 不在规则中时忽略", []string{"model", "messages"},
+			`{"model":{"id":"m1"},"messages":[{"role":"user","content":"hi"}],"config":{"temperature":1}}`, true},
+		{"config 在规则中", []string{"config"},
+			`{"config":{"temperature":1}}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := &replayTransport{match: tt.match}
+			a, err := transport.matchKey([]byte(base))
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := transport.matchKey([]byte(tt.other))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (a == b) != tt.equal {
+				t.Errorf("匹配键相等 = %v，期望 %v", a == b, tt.equal)
+			}
+		})
+	}
+
+	if _, err := (&replayTransport{}).matchKey([]byte("not json")); err == nil {
+		t.Error("非JSON请求体应返回错误")
+	}
+}
+
+func TestReplayTransportSequence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassette.jsonl")
+	var lines []string
+	for _, body := range []string{"first", "second"} {
+		line, _ := json.Marshal(CassetteInteraction{
+			Request:  CassetteRequest{Body: json.RawMessage(`{"model":{"id":"m1"}}`)},
+			Response: CassetteResponse{Status: http.StatusOK, Headers: map[string]string{"content-type": "text/plain"}, Body: body},
+		})
+		lines = append(lines, string(line))
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	transport, err := newReplayTransport(path, []string{"model"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 按录制顺序回放，用完后重复最后一条
+	for _, want := range []string{"first", "second", "second"} {
+		req, _ := http.NewRequest(http.MethodPost, "http://upstream/api/chat", strings.NewReader(`{"model":{"id":"m1"}}`))
+		resp, err := transport.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if string(body) != want || resp.Header.Get("Content-Type") != "text/plain" {
+			t.Fatalf("回放响应 = %q (%s)，期望 %q", body, resp.Header.Get("Content-Type"), want)
+		}
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, "http://upstream/api/chat", strings.NewReader(`{"model":{"id":"m2"}}`))
+	if _, err := transport.RoundTrip(req); err == nil {
+		t.Error("没有匹配记录时应返回错误")
+	}
+}
+
+func TestRedactHeaders(t *testing.T) {
+	header := http.Header{
+		"Authorization": {"Bearer sk-secret"},
+		"Cookie":        {"a=b"},
+		"X-Api-Key":     {"k"},
+		"X-Auth-Token":  {"t"},
+		"Content-Type":  {"application/json"},
+		"Accept":        {"text/html", "application/json"},
+	}
+	want := map[string]string{
+		"authorization": redactedValue,
+		"cookie":        redactedValue,
+		"x-api-key":     redactedValue,
+		"x-auth-token":  redactedValue,
+		"content-type":  "application/json",
+		"accept":        "text/html, application/json",
+	}
+	if got := redactHeaders(header); !reflect.DeepEqual(got, want) {
+		t.Errorf("redactHeaders = %v，期望 %v", got, want)
+	}
+}
+
+func TestRedactJSONBody(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"空请求体", "", `null`},
+		{"非JSON保存为字符串", "plain", `"plain"`},
+		{"嵌套字段", `{"userID":"u","config":{"api_key":"k","nested":[{"password":"p","keep":1}]}}`,
+			`{"config":{"api_key":"[REDACTED]","nested":[{"keep":1,"password":"[REDACTED]"}]},"userID":"u"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(redactJSONBody([]byte(tt.body))); got != tt.want {
+				t.Errorf("redactJSONBody = %s，期望 %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCassetteMatch(t *testing.T) {
+	got := parseCassetteMatch(" Model, messages,,unknown,template")
+	want := []string{"model", "messages", "template"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseCassetteMatch = %v，期望 %v", got, want)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yourusername/e2b-api-gateway/apierror"
+)
+
+// upstreamResult 一次成功的上游调用结果
+type upstreamResult struct {
+	Model       string
+	ModelConfig ModelConfig
+	Request     E2BRequest
+	Context     ContextResult
+	Adjustments []ParamAdjustment
+	Response    *E2BResponse
+	CacheStatus string
+	Attempts    int
+}
+
+// preparedRequest 为某个模型构造的E2B请求，以及构造时对上下文和参数所做的处理
+type preparedRequest struct {
+	Request     E2BRequest
+	Context     ContextResult
+	Adjustments []ParamAdjustment
+}
+
+// buildE2BRequest 为指定模型构造E2B请求，ctx 用于构造过程中的上游调用（如对话摘要）
+type buildE2BRequest func(ctx context.Context, modelName string, modelConfig ModelConfig) (preparedRequest, error)
+
+// completeWithFallback 依次尝试模型链中的模型，直到某个模型返回有效内容
+func completeWithFallback(ctx context.Context, requestID string, chain []string, build buildE2BRequest, cacheRead, cacheWrite bool) (*upstreamResult, error) {
+	var lastErr error
+	attempts := 0
+	for i, modelName := range chain {
+		modelConfig, ok := lookupModel(modelName)
+		if !ok {
+			lastErr = apierror.ModelNotFound(modelName)
+			continue
+		}
+		if i > 0 {
+			logInfo(requestID, fmt.Sprintf("回退到备用模型: %s (第%d次尝试)", modelName, i+1))
+		}
+
+		prepared, err := build(ctx, modelName, modelConfig)
+		if err != nil {
+			// 请求构造失败与上游无关，换模型也无济于事
+			return nil, err
+		}
+
+		response, cacheStatus, calls, err := fetchWithRetry(ctx, requestID, modelName, prepared.Request, cacheRead, cacheWrite)
+		attempts += calls
+		if err != nil {
+			if ctx.Err() != nil {
+				// 调用方已断开，不再换用备用模型
+				return nil, err
+			}
+			logError(requestID, fmt.Sprintf("模型 %s 调用失败", modelName), err)
+			lastErr = err
+			var failure *upstreamError
+			if errors.As(err, &failure) && !failure.Fallback() {
+				break
+			}
+			continue
+		}
+
+		return &upstreamResult{
+			Model:       modelName,
+			ModelConfig: modelConfig,
+			Request:     prepared.Request,
+			Context:     prepared.Context,
+			Adjustments: prepared.Adjustments,
+			Response:    response,
+			CacheStatus: cacheStatus,
+			Attempts:    attempts,
+		}, nil
+	}
+
+	if lastErr == nil {
+		lastErr = apierror.ModelNotFound(strings.Join(chain, ", "))
+	}
+	return nil, lastErr
+}
+
+// fetchWithRetry 调用上游，失败时按错误分类决定是否重试，最多调用 E2B_RETRY_MAX_ATTEMPTS 次。
+// 返回实际调用上游的次数，每次失败都会按分类计入指标。ctx 结束后不再重试
+func fetchWithRetry(ctx context.Context, requestID, modelName string, e2bRequest E2BRequest, cacheRead, cacheWrite bool) (*E2BResponse, string, int, error) {
+	for attempt := 1; ; attempt++ {
+		response, cacheStatus, err := fetchE2BResponse(ctx, requestID, e2bRequest, cacheRead, cacheWrite)
+		var failure *upstreamError
+		if err == nil || !errors.As(err, &failure) {
+			return response, cacheStatus, attempt, err
+		}
+
+		retry := failure.Retryable() && attempt < CONFIG.RETRY.MAX_ATTEMPTS
+		var delay time.Duration
+		if retry {
+			delay, retry = retryDelay(attempt, failure)
+		}
+		metrics.RecordUpstreamError(modelName, failure.Class, retry)
+		if !retry {
+			return nil, cacheStatus, attempt, err
+		}
+		logInfo(requestID, fmt.Sprintf("模型 %s 第%d次调用失败 (%s)，%dms 后重试", modelName, attempt, failure.Class, delay.Milliseconds()))
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, cacheStatus, attempt, err
+		}
+	}
+}
+
+// fetchE2BResponse 优先从缓存读取响应，未命中时请求上游并写入缓存
+func fetchE2BResponse(ctx context.Context, requestID string, e2bRequest E2BRequest, cacheRead, cacheWrite bool) (*E2BResponse, string, error) {
+	cacheStatus := CACHE_STATUS_BYPASS
+	cacheKey := ""
+	if responseCache != nil {
+		key, err := CacheKey(e2bRequest)
+		if err != nil {
+			logError(requestID, "计算缓存键失败", err)
+		} else {
+			cacheKey = key
+		}
+	}
+
+	if cacheKey != "" && cacheRead {
+		cacheStatus = CACHE_STATUS_MISS
+		if cached, ok := responseCache.Get(cacheKey); ok {
+			var cachedResponse E2BResponse
+			if err := json.Unmarshal(cached, &cachedResponse); err == nil {
+				logInfo(requestID, "命中响应缓存")
+				return &cachedResponse, CACHE_STATUS_HIT, nil
+			}
+		}
+	}
+
+	response, err := callE2B(ctx, requestID, e2bRequest)
+	if err != nil {
+		return nil, cacheStatus, err
+	}
+
+	// 只缓存有效的上游响应
+	if cacheKey != "" && cacheWrite {
+		if data, err := json.Marshal(response); err == nil {
+			responseCache.Set(cacheKey, data)
+		}
+	}
+	return response, cacheStatus, nil
+}
+
+// isEmptyE2BResponse 判断上游响应是否没有任何可用内容
+func isEmptyE2BResponse(response *E2BResponse) bool {
+	return strings.TrimSpace(response.Code) == "" && strings.TrimSpace(response.Text) == ""
+}
+
+// acquireSlot 申请一个上游调用名额，返回释放函数
+type acquireSlot func() (func(), error)
+
+// completeChoices 为 n 个候选并发调用上游，同时进行的调用数不超过 E2B_FANOUT_CONCURRENCY，
+// 每次调用还要占用密钥的并发名额。只有第一个候选读写缓存，其余候选总是请求上游以得到不同的结果。
+// 任一候选在所有备用模型上都失败时返回按候选顺序的第一个错误。调用方断开后尚未开始的候选不再调用上游
+func completeChoices(ctx context.Context, requestID string, n int, chain []string, build buildE2BRequest, cacheRead, cacheWrite bool, acquire acquireSlot) ([]*upstreamResult, error) {
+	results := make([]*upstreamResult, n)
+	errs := make([]error, n)
+	sem := make(chan struct{}, CONFIG.LIMITS.FANOUT_CONCURRENCY)
+
+	var wg sync.WaitGroup
+	for index := 0; index < n; index++ {
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+			}
+			// 名额和取消同时就绪时 select 任选其一，这里再检查一次
+			if err := ctx.Err(); err != nil {
+				errs[index] = err
+				return
+			}
+
+			release, err := acquire()
+			if err != nil {
+				errs[index] = err
+				return
+			}
+			defer release()
+
+			read, write := cacheRead, cacheWrite
+			if index > 0 {
+				read, write = false, false
+			}
+			if n > 1 {
+				logInfo(requestID, fmt.Sprintf("开始生成候选 %d/%d", index+1, n))
+			}
+			results[index], errs[index] = completeWithFallback(ctx, requestID, chain, build, read, write)
+		}(index)
+	}
+	wg.Wait()
+
+	for index, err := range errs {
+		if err != nil {
+			if n > 1 {
+				return nil, fmt.Errorf("候选 %d 生成失败: %w", index, err)
+			}
+			return nil, err
+		}
+	}
+	return results, nil
+}
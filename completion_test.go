@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// withSlowUpstream 把上游替换为每次等待 delay 才返回的测试服务器
+func withSlowUpstream(t *testing.T, delay time.Duration) *int32 {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+		w.Write([]byte(`{"text":"ok"}`))
+	}))
+	savedURL, savedRetry, savedBreaker, savedCache := CONFIG.API.BASE_URL, CONFIG.RETRY, upstreamBreaker, responseCache
+	CONFIG.API.BASE_URL = server.URL
+	upstreamBreaker = NewBreaker(1, time.Minute)
+	responseCache = nil
+	t.Cleanup(func() {
+		server.Close()
+		CONFIG.API.BASE_URL, CONFIG.RETRY, upstreamBreaker, responseCache = savedURL, savedRetry, savedBreaker, savedCache
+	})
+	return &calls
+}
+
+func TestFetchWithRetryTimeout(t *testing.T) {
+	calls := withSlowUpstream(t, time.Second)
+	CONFIG.RETRY.TIMEOUT = 20 * time.Millisecond
+	CONFIG.RETRY.MAX_ATTEMPTS = 2
+	CONFIG.RETRY.DELAY_BASE = 1
+	upstreamBreaker = NewBreaker(0, time.Minute)
+
+	_, _, attempts, err := fetchWithRetry(context.Background(), "test", "m", E2BRequest{}, false, false)
+	var failure *upstreamError
+	if !errors.As(err, &failure) || failure.Class != UPSTREAM_ERROR_TIMEOUT {
+		t.Fatalf("单次调用超时应归为 timeout，实际: %v", err)
+	}
+	if attempts != 2 || atomic.LoadInt32(calls) != 2 {
+		t.Fatalf("超时应重试，attempts = %d, calls = %d", attempts, atomic.LoadInt32(calls))
+	}
+}
+
+func TestFetchWithRetryCanceled(t *testing.T) {
+	calls := withSlowUpstream(t, time.Second)
+	CONFIG.RETRY.TIMEOUT = time.Minute
+	CONFIG.RETRY.MAX_ATTEMPTS = 3
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	_, _, attempts, err := fetchWithRetry(ctx, "test", "m", E2BRequest{}, false, false)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("调用方取消时应返回 context.Canceled，实际: %v", err)
+	}
+	if attempts != 1 || atomic.LoadInt32(calls) != 1 || time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("取消后不应重试或继续等待，attempts = %d, calls = %d", attempts, atomic.LoadInt32(calls))
+	}
+	// 调用方断开不计入熔断
+	if state := upstreamBreaker.Stats().State; state != BREAKER_CLOSED {
+		t.Fatalf("熔断状态 = %s", state)
+	}
+}
+
+func TestCompleteChoicesCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var acquired int32
+	acquire := func() (func(), error) {
+		atomic.AddInt32(&acquired, 1)
+		return func() {}, nil
+	}
+	build := func(context.Context, string, ModelConfig) (preparedRequest, error) { return preparedRequest{}, nil }
+
+	saved := CONFIG.LIMITS.FANOUT_CONCURRENCY
+	defer func() { CONFIG.LIMITS.FANOUT_CONCURRENCY = saved }()
+	CONFIG.LIMITS.FANOUT_CONCURRENCY = 1
+
+	// 已取消的请求不再申请密钥名额
+	_, err := completeChoices(ctx, "test", 4, []string{"claude-3-haiku-20240307"}, build, false, false, acquire)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("应返回 context.Canceled，实际: %v", err)
+	}
+	if n := atomic.LoadInt32(&acquired); n > 0 {
+		t.Fatalf("取消后仍有 %d 个候选申请了密钥名额", n)
+	}
+}
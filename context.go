@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/yourusername/e2b-api-gateway/apierror"
+)
+
+// 上下文窗口相关环境变量
+const (
+	ENV_CONTEXT_STRATEGY        = "E2B_CONTEXT_STRATEGY"        // 超出上下文窗口时的默认策略
+	ENV_MODEL_CONTEXT_STRATEGY  = "E2B_MODEL_CONTEXT_STRATEGY"  // 按模型设置策略，格式: 模型=策略,模型=策略
+	ENV_MODEL_CONTEXT_WINDOWS   = "E2B_MODEL_CONTEXT_WINDOWS"   // 按模型覆盖上下文窗口，格式: 模型=token数,模型=token数
+	ENV_CONTEXT_OUTPUT_RESERVE  = "E2B_CONTEXT_OUTPUT_RESERVE"  // 请求未指定 max_tokens 时为输出预留的token数
+	ENV_CONTEXT_SUMMARY_MAX_LEN = "E2B_CONTEXT_SUMMARY_MAX_LEN" // summarize 策略生成摘要的最大字符数
+)
+
+// 超出上下文窗口时的处理策略
+const (
+	CONTEXT_STRATEGY_TRUNCATE  = "truncate"  // 保留系统消息，从最早的对话开始丢弃
+	CONTEXT_STRATEGY_SUMMARIZE = "summarize" // 用一次额外的上游调用把较早的对话总结为一条系统消息
+	CONTEXT_STRATEGY_REJECT    = "reject"    // 直接返回 context_length_exceeded 错误
+)
+
+var contextStrategies = []string{CONTEXT_STRATEGY_TRUNCATE, CONTEXT_STRATEGY_SUMMARIZE, CONTEXT_STRATEGY_REJECT}
+
+// summaryInstruction 摘要调用使用的提示词
+const summaryInstruction = "Summarize the following earlier part of a conversation. Keep names, facts, decisions, open questions and any instructions the user gave. Reply with the summary only."
+
+// ContextResult 上下文窗口处理结果，用于日志和响应头
+type ContextResult struct {
+	Strategy       string
+	OriginalTokens int
+	FinalTokens    int
+	Budget         int
+	Dropped        int
+	Summarized     bool
+}
+
+// Applied 是否对消息做了修改
+func (r ContextResult) Applied() bool {
+	return r.Dropped > 0 || r.Summarized
+}
+
+// Header 响应头 x-gateway-context 的值
+func (r ContextResult) Header() string {
+	return fmt.Sprintf("%s; dropped=%d; summarized=%t; tokens=%d->%d", r.Strategy, r.Dropped, r.Summarized, r.OriginalTokens, r.FinalTokens)
+}
+
+// validContextStrategy 判断上下文策略是否受支持
+func validContextStrategy(strategy string) bool {
+	return containsString(contextStrategies, strategy)
+}
+
+// applyModelContextConfig 把环境变量中的上下文窗口和策略写入模型配置
+func applyModelContextConfig(windows, strategies string) {
+	for model, value := range parseKeyValueList(windows, "上下文窗口") {
+		modelConfig, ok := CONFIG.MODEL_CONFIG[model]
+		if !ok {
+			log.Printf("警告: 上下文窗口配置中的模型不存在: %s", model)
+			continue
+		}
+		window, err := strconv.Atoi(value)
+		if err != nil || window < 0 {
+			log.Fatalf("模型 %s 的上下文窗口无效: %s", model, value)
+		}
+		modelConfig.ContextWindow = window
+		CONFIG.MODEL_CONFIG[model] = modelConfig
+	}
+	for model, strategy := range parseKeyValueList(strategies, "上下文策略") {
+		modelConfig, ok := CONFIG.MODEL_CONFIG[model]
+		if !ok {
+			log.Printf("警告: 上下文策略配置中的模型不存在: %s", model)
+			continue
+		}
+		if !validContextStrategy(strategy) {
+			log.Fatalf("模型 %s 的上下文策略无效: %s，可选: %s", model, strategy, strings.Join(contextStrategies, ", "))
+		}
+		modelConfig.ContextStrategy = strategy
+		CONFIG.MODEL_CONFIG[model] = modelConfig
+	}
+}
+
+// resolveContextStrategy 返回模型生效的上下文策略
+func resolveContextStrategy(modelConfig ModelConfig) string {
+	if modelConfig.ContextStrategy != "" {
+		return modelConfig.ContextStrategy
+	}
+	return CONFIG.CONTEXT.STRATEGY
+}
+
+// fitContextWindow 估算提示词token数，超出模型上下文窗口时按策略处理。
+// 预算为上下文窗口减去输出预留（请求的 max_tokens，未指定时使用配置的默认值）
+func fitContextWindow(ctx context.Context, requestID, modelName string, modelConfig ModelConfig, messages []ChatMessage, maxTokens int, userID string) ([]ChatMessage, ContextResult, error) {
+	result := ContextResult{
+		Strategy:       resolveContextStrategy(modelConfig),
+		OriginalTokens: EstimateMessagesTokens(messages),
+	}
+	result.FinalTokens = result.OriginalTokens
+	if modelConfig.ContextWindow <= 0 {
+		return messages, result, nil
+	}
+
+	reserve := maxTokens
+	if reserve <= 0 {
+		reserve = CONFIG.CONTEXT.OUTPUT_RESERVE
+	}
+	result.Budget = modelConfig.ContextWindow - reserve
+	if result.OriginalTokens <= result.Budget {
+		return messages, result, nil
+	}
+
+	overflow := apierror.ContextLengthExceeded(modelName, result.OriginalTokens+reserve, modelConfig.ContextWindow)
+	if result.Strategy == CONTEXT_STRATEGY_REJECT || result.Budget <= 0 {
+		return nil, result, overflow
+	}
+
+	fitted, dropped := truncateMessages(messages, result.Budget)
+	if fitted == nil {
+		return nil, result, overflow
+	}
+	result.Dropped = len(dropped)
+
+	if result.Strategy == CONTEXT_STRATEGY_SUMMARIZE && len(dropped) > 0 {
+		summary, err := summarizeMessages(ctx, requestID, modelName, modelConfig, dropped, userID)
+		if err != nil {
+			logError(requestID, "生成对话摘要失败，退回为直接截断", err)
+		} else {
+			withSummary := insertSummary(fitted, summary)
+			if EstimateMessagesTokens(withSummary) <= result.Budget {
+				fitted = withSummary
+				result.Summarized = true
+			} else {
+				logInfo(requestID, "对话摘要超出上下文预算，退回为直接截断")
+			}
+		}
+	}
+
+	result.FinalTokens = EstimateMessagesTokens(fitted)
+	return fitted, result, nil
+}
+
+// truncateMessages 保留所有系统消息及其原有位置，从最早的非系统消息开始丢弃，直到不超过预算。
+// 最后一条非系统消息始终保留，保留部分的对话不会以 assistant 消息开头。无法满足预算时返回 nil。
+// 每条消息只估算一次，丢弃时从总数中扣除
+func truncateMessages(messages []ChatMessage, budget int) ([]ChatMessage, []ChatMessage) {
+	costs := make([]int, len(messages))
+	total := tokensPerRequest
+	var conversation []int
+	for i, msg := range messages {
+		costs[i] = estimateMessageTokens(msg)
+		total += costs[i]
+		if msg.Role != "system" {
+			conversation = append(conversation, i)
+		}
+	}
+
+	start := 0
+	for start < len(conversation)-1 {
+		if total <= budget && messages[conversation[start]].Role != "assistant" {
+			break
+		}
+		total -= costs[conversation[start]]
+		start++
+	}
+	if total > budget {
+		return nil, nil
+	}
+
+	// 第一条保留的非系统消息之前的非系统消息都被丢弃
+	cutoff := len(messages)
+	if start < len(conversation) {
+		cutoff = conversation[start]
+	}
+	kept := make([]ChatMessage, 0, len(messages)-start)
+	var dropped []ChatMessage
+	for i, msg := range messages {
+		if i < cutoff && msg.Role != "system" {
+			dropped = append(dropped, msg)
+		} else {
+			kept = append(kept, msg)
+		}
+	}
+	return kept, dropped
+}
+
+// summarizeMessages 通过一次额外的上游调用把被丢弃的对话总结为一段文字。
+// 调用与正式请求一样按错误分类重试，并随调用方断开而取消
+func summarizeMessages(ctx context.Context, requestID, modelName string, modelConfig ModelConfig, dropped []ChatMessage, userID string) (string, error) {
+	var transcript strings.Builder
+	for _, msg := range dropped {
+		fmt.Fprintf(&transcript, "%s: %s\n\n", msg.Role, ProcessMessageContent(msg.Content))
+	}
+
+	summaryRequest := ChatRequest{
+		Messages: []ChatMessage{
+			{Role: "user", Content: summaryInstruction + "\n\n" + transcript.String()},
+		},
+	}
+	e2bRequest, err := PrepareChatRequest(modelConfig, requestID, summaryRequest, nil, PrepareOptions{
+		UserID:     userID,
+		TemplateID: TEMPLATE_TEXT,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	logInfo(requestID, fmt.Sprintf("上下文超出预算，正在总结 %d 条较早的消息", len(dropped)))
+	response, _, _, err := fetchWithRetry(ctx, requestID, modelName, e2bRequest, false, false)
+	if err != nil {
+		return "", err
+	}
+	summary := ExtractContent(response, ExtractionPolicy{Mode: EXTRACTION_AUTO})
+	if summary == "" {
+		return "", fmt.Errorf("摘要为空")
+	}
+	if runes := []rune(summary); CONFIG.CONTEXT.SUMMARY_MAX_LEN > 0 && len(runes) > CONFIG.CONTEXT.SUMMARY_MAX_LEN {
+		summary = string(runes[:CONFIG.CONTEXT.SUMMARY_MAX_LEN]) + "..."
+	}
+	return summary, nil
+}
+
+// insertSummary 把摘要作为系统消息插入到原有系统消息之后
+func insertSummary(messages []ChatMessage, summary string) []ChatMessage {
+	summaryMessage := ChatMessage{Role: "system", Content: "Summary of the earlier conversation:\n" + summary}
+	result := make([]ChatMessage, 0, len(messages)+1)
+	inserted := false
+	for _, msg := range messages {
+		if !inserted && msg.Role != "system" {
+			result = append(result, summaryMessage)
+			inserted = true
+		}
+		result = append(result, msg)
+	}
+	if !inserted {
+		result = append(result, summaryMessage)
+	}
+	return result
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/yourusername/e2b-api-gateway/apierror"
+)
+
+func TestTruncateMessages(t *testing.T) {
+	// 每条消息 4 + 4 = 8 个token，请求开销 3
+	msg := func(role string) ChatMessage {
+		return ChatMessage{Role: role, Content: role[:1] + strings.Repeat("x", 15)}
+	}
+	sys, user, assistant := msg("system"), msg("user"), msg("assistant")
+	roles := func(messages []ChatMessage) string {
+		var out []string
+		for _, m := range messages {
+			out = append(out, m.Role[:1])
+		}
+		return strings.Join(out, "")
+	}
+
+	tests := []struct {
+		name        string
+		messages    []ChatMessage
+		budget      int
+		wantKept    string
+		wantDropped string
+		wantNil     bool
+	}{
+		{"不超预算时原样保留", []ChatMessage{sys, user, assistant, user}, 35, "suau", "", false},
+		{"丢弃最早的对话", []ChatMessage{sys, user, assistant, user}, 19, "su", "ua", false},
+		{"丢弃到第一条满足预算的 user 消息", []ChatMessage{user, assistant, user, assistant, user}, 27, "uau", "ua", false},
+		{"保留部分不以 assistant 开头", []ChatMessage{user, assistant, user, assistant, user}, 19, "u", "uaua", false},
+		{"对话中间的系统消息保持原位", []ChatMessage{sys, user, assistant, sys, user, assistant, user}, 43, "ssuau", "ua", false},
+		{"被丢弃部分之间的系统消息保留", []ChatMessage{user, sys, assistant, user}, 19, "su", "ua", false},
+		{"最后一条消息始终保留", []ChatMessage{user, user}, 11, "u", "u", false},
+		{"系统消息本身超出预算", []ChatMessage{sys, sys, user}, 18, "", "", true},
+		{"只有系统消息", []ChatMessage{sys}, 11, "s", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kept, dropped := truncateMessages(tt.messages, tt.budget)
+			if tt.wantNil {
+				if kept != nil {
+					t.Fatalf("无法满足预算时应返回 nil，实际保留 %s", roles(kept))
+				}
+				return
+			}
+			if got := roles(kept); got != tt.wantKept {
+				t.Errorf("保留 = %s，期望 %s", got, tt.wantKept)
+			}
+			if got := roles(dropped); got != tt.wantDropped {
+				t.Errorf("丢弃 = %s，期望 %s", got, tt.wantDropped)
+			}
+			if EstimateMessagesTokens(kept) > tt.budget {
+				t.Errorf("保留部分 %d 个token，超出预算 %d", EstimateMessagesTokens(kept), tt.budget)
+			}
+		})
+	}
+}
+
+func TestTruncateMessagesKeepsOrder(t *testing.T) {
+	messages := []ChatMessage{
+		{Role: "system", Content: "a"},
+		{Role: "user", Content: "old question " + strings.Repeat("x", 400)},
+		{Role: "assistant", Content: "old answer"},
+		{Role: "system", Content: "b"},
+		{Role: "user", Content: "q"},
+		{Role: "assistant", Content: "r"},
+		{Role: "system", Content: "c"},
+		{Role: "user", Content: "last"},
+	}
+	kept, _ := truncateMessages(messages, 50)
+	var got []string
+	for _, msg := range kept {
+		got = append(got, ProcessMessageContent(msg.Content))
+	}
+	want := []string{"a", "b", "q", "r", "c", "last"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("保留 = %q，期望 %q", got, want)
+	}
+}
+
+func TestInsertSummary(t *testing.T) {
+	messages := []ChatMessage{{Role: "system", Content: "a"}, {Role: "user", Content: "q"}, {Role: "system", Content: "b"}}
+	got := insertSummary(messages, "s")
+	if len(got) != 4 || got[1].Role != "system" || !strings.HasSuffix(got[1].Content.(string), "\ns") || got[3].Content != "b" {
+		t.Fatalf("insertSummary = %+v", got)
+	}
+}
+
+func TestCompleteWithFallbackContext(t *testing.T) {
+	// 第一个模型总是失败，回退后的结果应带有实际返回结果的模型的上下文处理结果
+	failing, serving := "claude-3-5-sonnet-20240620", "claude-3-haiku-20240307"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request E2BRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		if request.Model.Name == failing {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`{"text":"ok"}`))
+	}))
+	defer server.Close()
+	savedURL, savedRetry, savedBreaker, savedCache := CONFIG.API.BASE_URL, CONFIG.RETRY, upstreamBreaker, responseCache
+	defer func() {
+		CONFIG.API.BASE_URL, CONFIG.RETRY, upstreamBreaker, responseCache = savedURL, savedRetry, savedBreaker, savedCache
+	}()
+	CONFIG.API.BASE_URL = server.URL
+	CONFIG.RETRY.MAX_ATTEMPTS = 1
+	upstreamBreaker = NewBreaker(0, 0)
+	responseCache = nil
+
+	build := func(ctx context.Context, modelName string, modelConfig ModelConfig) (preparedRequest, error) {
+		prepared := preparedRequest{Context: ContextResult{Strategy: modelName}}
+		prepared.Request.Model.Name = modelName
+		if modelName == failing {
+			prepared.Context.Dropped = 5
+		}
+		return prepared, nil
+	}
+	result, err := completeWithFallback(context.Background(), "test", []string{failing, serving}, build, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Model != serving || result.Context.Strategy != serving || result.Context.Applied() {
+		t.Fatalf("结果模型 %s 的上下文结果 = %+v", result.Model, result.Context)
+	}
+}
+
+func TestFitContextWindow(t *testing.T) {
+	saved := CONFIG.CONTEXT
+	CONFIG.CONTEXT.OUTPUT_RESERVE = 10
+	t.Cleanup(func() { CONFIG.CONTEXT = saved })
+
+	// 每条消息 8 个token，请求开销 3，共 35 个token
+	messages := []ChatMessage{
+		{Role: "system", Content: "s" + strings.Repeat("x", 15)},
+		{Role: "user", Content: "u" + strings.Repeat("x", 15)},
+		{Role: "assistant", Content: "a" + strings.Repeat("x", 15)},
+		{Role: "user", Content: "u" + strings.Repeat("x", 15)},
+	}
+	tests := []struct {
+		name        string
+		model       ModelConfig
+		maxTokens   int
+		wantCount   int
+		wantDropped int
+		wantErr     bool
+	}{
+		{"未配置上下文窗口", ModelConfig{}, 0, 4, 0, false},
+		{"不超出预算", ModelConfig{ContextWindow: 45, ContextStrategy: CONTEXT_STRATEGY_REJECT}, 0, 4, 0, false},
+		{"max_tokens 作为输出预留", ModelConfig{ContextWindow: 45, ContextStrategy: CONTEXT_STRATEGY_REJECT}, 20, 0, 0, true},
+		{"reject", ModelConfig{ContextWindow: 40, ContextStrategy: CONTEXT_STRATEGY_REJECT}, 0, 0, 0, true},
+		{"truncate", ModelConfig{ContextWindow: 29, ContextStrategy: CONTEXT_STRATEGY_TRUNCATE}, 0, 2, 2, false},
+		{"预算不足", ModelConfig{ContextWindow: 10, ContextStrategy: CONTEXT_STRATEGY_TRUNCATE}, 0, 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fitted, result, err := fitContextWindow(context.Background(), "test", "m", tt.model, messages, tt.maxTokens, "")
+			if tt.wantErr {
+				var apiErr *apierror.Error
+				if !errors.As(err, &apiErr) || apiErr.Code != apierror.CodeContextLengthExceeded {
+					t.Fatalf("应返回 context_length_exceeded，实际 %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(fitted) != tt.wantCount || result.Dropped != tt.wantDropped || result.Applied() != (tt.wantDropped > 0) {
+				t.Errorf("保留 %d 条，丢弃 %d 条，期望 %d 和 %d", len(fitted), result.Dropped, tt.wantCount, tt.wantDropped)
+			}
+			if result.FinalTokens != EstimateMessagesTokens(fitted) {
+				t.Errorf("FinalTokens = %d，实际 %d", result.FinalTokens, EstimateMessagesTokens(fitted))
+			}
+		})
+	}
+}
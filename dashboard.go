@@ -0,0 +1,35 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// webAssets 管理面板的静态文件，随二进制一起发布
+//
+//go:embed web
+var webAssets embed.FS
+
+// registerDashboardRoutes 注册 /admin/ui 管理面板，页面本身无需认证，数据接口仍需管理凭证
+func registerDashboardRoutes(r *gin.Engine) {
+	if CONFIG.ADMIN.KEY == "" {
+		return
+	}
+
+	sub, err := fs.Sub(webAssets, "web")
+	if err != nil {
+		panic(err)
+	}
+	r.StaticFS("/admin/ui", http.FS(sub))
+	r.GET("/admin", func(c *gin.Context) {
+		c.Redirect(http.StatusFound, "/admin/ui/")
+	})
+}
+
+// 管理面板使用的指标数据
+func handleAdminMetrics(c *gin.Context) {
+	c.JSON(http.StatusOK, metrics.Snapshot())
+}
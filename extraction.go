@@ -0,0 +1,151 @@
+package main
+
+import (
+	"log"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/yourusername/e2b-api-gateway/apierror"
+)
+
+// 响应内容提取相关环境变量
+const (
+	ENV_EXTRACTION           = "E2B_EXTRACTION"           // 默认提取策略
+	ENV_EXTRACTION_SEPARATOR = "E2B_EXTRACTION_SEPARATOR" // both 策略的分隔符
+	ENV_MODEL_EXTRACTION     = "E2B_MODEL_EXTRACTION"     // 按模型设置提取策略，格式: 模型=策略,模型=策略
+)
+
+// 提取策略：决定如何把上游响应的 code 和 text 字段转换为聊天内容
+const (
+	EXTRACTION_AUTO         = "auto"         // 优先 code，为空时使用 text
+	EXTRACTION_TEXT         = "text"         // 只使用 text（为空时使用 commentary）
+	EXTRACTION_CODE         = "code"         // 只使用 code
+	EXTRACTION_BOTH         = "both"         // text 和 code 用分隔符连接
+	EXTRACTION_MARKDOWN     = "markdown"     // text 之后附加带语言标记的代码块
+	EXTRACTION_STRIP_FENCES = "strip_fences" // 与 auto 相同，但去掉代码块围栏
+)
+
+var extractionPolicies = []string{
+	EXTRACTION_AUTO, EXTRACTION_TEXT, EXTRACTION_CODE, EXTRACTION_BOTH, EXTRACTION_MARKDOWN, EXTRACTION_STRIP_FENCES,
+}
+
+// codeFencePattern 匹配整行的代码块围栏，例如 ```python
+var codeFencePattern = regexp.MustCompile("(?m)^[ \t]*```[\\w+#.-]*[ \t]*\r?\n?")
+
+// fenceLanguages 根据文件扩展名推断代码块语言
+var fenceLanguages = map[string]string{
+	".py":   "python",
+	".tsx":  "tsx",
+	".ts":   "typescript",
+	".jsx":  "jsx",
+	".js":   "javascript",
+	".vue":  "vue",
+	".html": "html",
+}
+
+// ExtractionPolicy 一次请求生效的提取策略
+type ExtractionPolicy struct {
+	Mode      string
+	Separator string
+}
+
+// validExtraction 判断提取策略是否受支持
+func validExtraction(mode string) bool {
+	return containsString(extractionPolicies, mode)
+}
+
+// applyModelExtraction 把 E2B_MODEL_EXTRACTION 中的策略写入模型配置
+func applyModelExtraction(value string) {
+	for model, mode := range parseKeyValueList(value, "提取策略") {
+		modelConfig, ok := CONFIG.MODEL_CONFIG[model]
+		if !ok {
+			log.Printf("警告: 提取策略配置中的模型不存在: %s", model)
+			continue
+		}
+		if !validExtraction(mode) {
+			log.Fatalf("模型 %s 的提取策略无效: %s", model, mode)
+		}
+		modelConfig.Extraction = mode
+		CONFIG.MODEL_CONFIG[model] = modelConfig
+	}
+}
+
+// validateExtraction 检查请求中的提取策略
+func validateExtraction(extension *E2BExtension) error {
+	if extension == nil || extension.Extraction == "" || validExtraction(extension.Extraction) {
+		return nil
+	}
+	return apierror.InvalidValue("e2b.extraction", extension.Extraction, strings.Join(extractionPolicies, ", "))
+}
+
+// resolveExtraction 确定生效的提取策略，优先级为 请求 > 模型 > 全局默认
+func resolveExtraction(extension *E2BExtension, modelConfig ModelConfig) ExtractionPolicy {
+	policy := ExtractionPolicy{Mode: CONFIG.EXTRACTION.DEFAULT, Separator: CONFIG.EXTRACTION.SEPARATOR}
+	if modelConfig.Extraction != "" {
+		policy.Mode = modelConfig.Extraction
+	}
+	if extension != nil {
+		if extension.Extraction != "" {
+			policy.Mode = extension.Extraction
+		}
+		if extension.Separator != nil {
+			policy.Separator = *extension.Separator
+		}
+	}
+	return policy
+}
+
+// ExtractContent 按策略从上游响应中提取聊天内容，所选字段为空时退回 auto 策略
+func ExtractContent(response *E2BResponse, policy ExtractionPolicy) string {
+	code := strings.TrimSpace(response.Code)
+	text := strings.TrimSpace(response.Text)
+	if text == "" {
+		text = strings.TrimSpace(response.Commentary)
+	}
+
+	var content string
+	switch policy.Mode {
+	case EXTRACTION_TEXT:
+		content = text
+	case EXTRACTION_CODE:
+		content = code
+	case EXTRACTION_BOTH:
+		content = joinNonEmpty(policy.Separator, text, code)
+	case EXTRACTION_MARKDOWN:
+		if code != "" && !strings.HasPrefix(code, "```") {
+			code = "```" + fenceLanguage(response) + "\n" + code + "\n```"
+		}
+		content = joinNonEmpty("\n\n", text, code)
+	case EXTRACTION_STRIP_FENCES:
+		content = strings.TrimSpace(codeFencePattern.ReplaceAllString(firstNonEmpty(code, text), ""))
+	}
+	if content == "" {
+		content = firstNonEmpty(code, text)
+	}
+	return content
+}
+
+// fenceLanguage 根据 fragment 的文件路径推断代码块语言
+func fenceLanguage(response *E2BResponse) string {
+	return fenceLanguages[strings.ToLower(filepath.Ext(response.FilePath))]
+}
+
+func joinNonEmpty(separator string, values ...string) string {
+	parts := make([]string, 0, len(values))
+	for _, value := range values {
+		if value != "" {
+			parts = append(parts, value)
+		}
+	}
+	return strings.Join(parts, separator)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,68 @@
+package main
+
+import "testing"
+
+func TestExtractContent(t *testing.T) {
+	const fenced = "```python\nprint(1)\n```"
+	tests := []struct {
+		name     string
+		response E2BResponse
+		policy   ExtractionPolicy
+		want     string
+	}{
+		{"auto 优先 code", E2BResponse{Code: "print(1)", Text: "hi"}, ExtractionPolicy{Mode: EXTRACTION_AUTO}, "print(1)"},
+		{"auto 退回 text", E2BResponse{Text: " hi "}, ExtractionPolicy{Mode: EXTRACTION_AUTO}, "hi"},
+		{"text", E2BResponse{Code: "print(1)", Text: "hi"}, ExtractionPolicy{Mode: EXTRACTION_TEXT}, "hi"},
+		{"text 退回 commentary", E2BResponse{Code: "print(1)", Commentary: "note"}, ExtractionPolicy{Mode: EXTRACTION_TEXT}, "note"},
+		{"text 为空退回 auto", E2BResponse{Code: "print(1)"}, ExtractionPolicy{Mode: EXTRACTION_TEXT}, "print(1)"},
+		{"code", E2BResponse{Code: "print(1)", Text: "hi"}, ExtractionPolicy{Mode: EXTRACTION_CODE}, "print(1)"},
+		{"both", E2BResponse{Code: "print(1)", Text: "hi"}, ExtractionPolicy{Mode: EXTRACTION_BOTH, Separator: "\n---\n"}, "hi\n---\nprint(1)"},
+		{"both 跳过空字段", E2BResponse{Code: "print(1)"}, ExtractionPolicy{Mode: EXTRACTION_BOTH, Separator: "|"}, "print(1)"},
+		{"markdown", E2BResponse{Code: "print(1)", Text: "hi", FilePath: "script.py"}, ExtractionPolicy{Mode: EXTRACTION_MARKDOWN}, "hi\n\n" + fenced},
+		{"markdown 未知语言", E2BResponse{Code: "x"}, ExtractionPolicy{Mode: EXTRACTION_MARKDOWN}, "```\nx\n```"},
+		{"markdown 已有围栏", E2BResponse{Code: fenced}, ExtractionPolicy{Mode: EXTRACTION_MARKDOWN}, fenced},
+		{"strip_fences", E2BResponse{Code: fenced}, ExtractionPolicy{Mode: EXTRACTION_STRIP_FENCES}, "print(1)"},
+		{"strip_fences 使用 text", E2BResponse{Text: "```\nhi\n```"}, ExtractionPolicy{Mode: EXTRACTION_STRIP_FENCES}, "hi"},
+		{"全部为空", E2BResponse{}, ExtractionPolicy{Mode: EXTRACTION_BOTH}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := tt.response
+			if got := ExtractContent(&response, tt.policy); got != tt.want {
+				t.Errorf("ExtractContent = %q，期望 %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveExtraction(t *testing.T) {
+	saved := CONFIG.EXTRACTION
+	CONFIG.EXTRACTION.DEFAULT, CONFIG.EXTRACTION.SEPARATOR = EXTRACTION_AUTO, "\n\n"
+	t.Cleanup(func() { CONFIG.EXTRACTION = saved })
+
+	separator := "|"
+	tests := []struct {
+		name      string
+		extension *E2BExtension
+		model     ModelConfig
+		want      ExtractionPolicy
+	}{
+		{"全局默认", nil, ModelConfig{}, ExtractionPolicy{Mode: EXTRACTION_AUTO, Separator: "\n\n"}},
+		{"模型配置", nil, ModelConfig{Extraction: EXTRACTION_CODE}, ExtractionPolicy{Mode: EXTRACTION_CODE, Separator: "\n\n"}},
+		{"请求优先", &E2BExtension{Extraction: EXTRACTION_TEXT, Separator: &separator}, ModelConfig{Extraction: EXTRACTION_CODE}, ExtractionPolicy{Mode: EXTRACTION_TEXT, Separator: "|"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := resolveExtraction(tt.extension, tt.model); got != tt.want {
+				t.Errorf("resolveExtraction = %+v，期望 %+v", got, tt.want)
+			}
+		})
+	}
+
+	if err := validateExtraction(&E2BExtension{Extraction: "html"}); err == nil {
+		t.Error("不支持的提取策略应返回错误")
+	}
+	if err := validateExtraction(&E2BExtension{Extraction: EXTRACTION_BOTH}); err != nil {
+		t.Errorf("both 应为合法策略: %v", err)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fragmentStreamDelay 流式返回时两次部分对象之间的间隔
+const fragmentStreamDelay = 50 * time.Millisecond
+
+// Fragment fragments 的完整结构化输出，字段与 fragments 的 fragmentSchema 一致
+type Fragment struct {
+	Commentary                 string   `json:"commentary"`
+	Template                   string   `json:"template"`
+	Title                      string   `json:"title"`
+	Description                string   `json:"description"`
+	AdditionalDependencies     []string `json:"additional_dependencies"`
+	HasAdditionalDependencies  bool     `json:"has_additional_dependencies"`
+	InstallDependenciesCommand string   `json:"install_dependencies_command"`
+	Port                       *int     `json:"port"`
+	FilePath                   string   `json:"file_path"`
+	Code                       string   `json:"code"`
+	Text                       string   `json:"text,omitempty"`
+}
+
+// FragmentResponse /v1/fragments 的响应
+type FragmentResponse struct {
+	ID       string   `json:"id"`
+	Object   string   `json:"object"`
+	Created  int64    `json:"created"`
+	Model    string   `json:"model"`
+	Fragment Fragment `json:"fragment"`
+}
+
+// FragmentChunk 流式响应中的部分对象，每个事件的 Fragment 都是截至当前的完整进度，
+// 未生成的字段不出现
+type FragmentChunk struct {
+	ID       string      `json:"id"`
+	Object   string      `json:"object"`
+	Created  int64       `json:"created"`
+	Model    string      `json:"model"`
+	Fragment E2BResponse `json:"fragment"`
+	Done     bool        `json:"done"`
+}
+
+// 使用 Gin 处理 fragments 请求，请求体与聊天请求相同，未指定模板时使用 auto
+func handleFragmentsRequestGin(c *gin.Context) {
+	requestID := GenerateUUID()
+	c.Set(CTX_REQUEST_ID, requestID)
+	logInfo(requestID, "处理fragments请求")
+
+	// fragments 只返回一个结果，不支持 n > 1
+	chatRequest, results, ok := executeCompletion(c, requestID, TEMPLATE_AUTO, false)
+	if !ok {
+		return
+	}
+	result := results[0]
+
+	fragment := buildFragment(result)
+	if chatRequest.Stream {
+		handleFragmentStreamGin(c, fragment, result.Model, requestID)
+		return
+	}
+
+	c.JSON(http.StatusOK, FragmentResponse{
+		ID:       GenerateFragmentID(),
+		Object:   "fragment",
+		Created:  time.Now().Unix(),
+		Model:    result.Model,
+		Fragment: fragment,
+	})
+	logInfo(requestID, fmt.Sprintf("返回fragment成功, 模板: %s, 文件: %s", fragment.Template, fragment.FilePath))
+}
+
+// buildFragment 把上游响应转换为完整的 Fragment，上游未返回模板时使用请求中唯一的模板
+func buildFragment(result *upstreamResult) Fragment {
+	response := result.Response
+	fragment := Fragment{
+		Commentary:                 response.Commentary,
+		Template:                   response.Template,
+		Title:                      response.Title,
+		Description:                response.Description,
+		AdditionalDependencies:     response.AdditionalDependencies,
+		HasAdditionalDependencies:  response.HasAdditionalDependencies,
+		InstallDependenciesCommand: response.InstallDependenciesCommand,
+		Port:                       response.Port,
+		FilePath:                   response.FilePath,
+		Code:                       response.Code,
+		Text:                       response.Text,
+	}
+	if fragment.AdditionalDependencies == nil {
+		fragment.AdditionalDependencies = []string{}
+	}
+	if fragment.Template == "" && len(result.Request.Template) == 1 {
+		for id := range result.Request.Template {
+			fragment.Template = id
+		}
+	}
+	return fragment
+}
+
+// handleFragmentStreamGin 按 fragments 生成字段的顺序逐步发送增量：先是 commentary 的分块，
+// 然后是一次元数据，最后是 code 的分块。客户端按字段拼接增量即可，结束事件带有完整对象
+func handleFragmentStreamGin(c *gin.Context, fragment Fragment, model string, requestID string) {
+	defer serverLifecycle.StreamStarted()()
+
+	c.Writer.Header().Set("Content-Type", "text/event-stream")
+	c.Writer.Header().Set("Cache-Control", "no-cache")
+	c.Writer.Header().Set("Connection", "keep-alive")
+	c.Writer.WriteHeader(http.StatusOK)
+
+	chunk := FragmentChunk{
+		ID:      GenerateFragmentID(),
+		Object:  "fragment.chunk",
+		Created: time.Now().Unix(),
+		Model:   model,
+	}
+	partial := &chunk.Fragment
+	send := func() bool {
+		eventJSON, err := json.Marshal(chunk)
+		if err != nil {
+			logError(requestID, "序列化部分对象失败", err)
+			return false
+		}
+		fmt.Fprintf(c.Writer, "data: %s\n\n", eventJSON)
+		c.Writer.Flush()
+		if chunk.Done {
+			return true
+		}
+		select {
+		case <-time.After(fragmentStreamDelay):
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	}
+	// stream 逐块追加到部分对象的字段并发送
+	stream := func(field *string, text string) bool {
+		for _, piece := range splitStreamChunks(text) {
+			*field += piece
+			if !send() {
+				return false
+			}
+		}
+		return true
+	}
+
+	if !stream(&partial.Commentary, fragment.Commentary) {
+		return
+	}
+
+	partial.Template = fragment.Template
+	partial.Title = fragment.Title
+	partial.Description = fragment.Description
+	partial.AdditionalDependencies = fragment.AdditionalDependencies
+	partial.HasAdditionalDependencies = fragment.HasAdditionalDependencies
+	partial.InstallDependenciesCommand = fragment.InstallDependenciesCommand
+	partial.Port = fragment.Port
+	partial.FilePath = fragment.FilePath
+	if !send() {
+		return
+	}
+
+	if !stream(&partial.Code, fragment.Code) || !stream(&partial.Text, fragment.Text) {
+		return
+	}
+
+	chunk.Done = true
+	send()
+	fmt.Fprint(c.Writer, "data: [DONE]\n\n")
+	c.Writer.Flush()
+	logInfo(requestID, "fragment流式响应完成")
+}
+
+// splitStreamChunks 把文本切成15到29字节的分块，不会切断多字节字符
+func splitStreamChunks(text string) []string {
+	var chunks []string
+	for len(text) > 0 {
+		size := rand.Intn(15) + 15
+		if size >= len(text) {
+			chunks = append(chunks, text)
+			break
+		}
+		for size > 0 && !utf8.RuneStart(text[size]) {
+			size--
+		}
+		if size == 0 {
+			_, size = utf8.DecodeRuneInString(text)
+		}
+		chunks = append(chunks, text[:size])
+		text = text[size:]
+	}
+	return chunks
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestBuildFragment(t *testing.T) {
+	port := 3000
+	tests := []struct {
+		name      string
+		templates map[string]interface{}
+		response  E2BResponse
+		want      Fragment
+	}{
+		{
+			name:      "上游返回模板",
+			templates: map[string]interface{}{"nextjs-developer": nil, "vue-developer": nil},
+			response:  E2BResponse{Template: "vue-developer", Code: "<template/>", AdditionalDependencies: []string{"pinia"}, Port: &port},
+			want:      Fragment{Template: "vue-developer", Code: "<template/>", AdditionalDependencies: []string{"pinia"}, Port: &port},
+		},
+		{
+			name:      "唯一模板补全",
+			templates: map[string]interface{}{"nextjs-developer": nil},
+			response:  E2BResponse{Code: "export default 1"},
+			want:      Fragment{Template: "nextjs-developer", Code: "export default 1", AdditionalDependencies: []string{}},
+		},
+		{
+			name:      "多个模板不猜测",
+			templates: map[string]interface{}{"nextjs-developer": nil, "vue-developer": nil},
+			response:  E2BResponse{Text: "hello"},
+			want:      Fragment{Text: "hello", AdditionalDependencies: []string{}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := tt.response
+			got := buildFragment(&upstreamResult{Request: E2BRequest{Template: tt.templates}, Response: &response})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("buildFragment = %+v，期望 %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitStreamChunks(t *testing.T) {
+	for _, text := range []string{"", "short", strings.Repeat("abc", 40), strings.Repeat("中文字符", 30)} {
+		chunks := splitStreamChunks(text)
+		if joined := strings.Join(chunks, ""); joined != text {
+			t.Fatalf("分块拼接后不一致: %q", joined)
+		}
+		for _, chunk := range chunks {
+			if chunk == "" || !utf8.ValidString(chunk) {
+				t.Fatalf("分块 %q 为空或切断了多字节字符", chunk)
+			}
+		}
+	}
+}
+
+func TestHandleFragmentStream(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	port := 3000
+	fragment := Fragment{
+		Commentary:             strings.Repeat("commentary ", 5),
+		Template:               "nextjs-developer",
+		Title:                  "Demo",
+		AdditionalDependencies: []string{},
+		Port:                   &port,
+		FilePath:               "pages/index.tsx",
+		Code:                   strings.Repeat("code ", 10),
+		Text:                   strings.Repeat("text ", 10),
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/fragments", nil)
+	handleFragmentStreamGin(c, fragment, "claude-3-5-sonnet-20240620", "test")
+
+	events := strings.Split(strings.TrimSpace(w.Body.String()), "\n\n")
+	if last := events[len(events)-1]; last != "data: [DONE]" {
+		t.Fatalf("最后一个事件应为 [DONE]，实际 %q", last)
+	}
+	var chunks []FragmentChunk
+	for _, event := range events[:len(events)-1] {
+		var chunk FragmentChunk
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(event, "data: ")), &chunk); err != nil {
+			t.Fatalf("解析事件失败: %v", err)
+		}
+		chunks = append(chunks, chunk)
+	}
+	if len(chunks) < 3 {
+		t.Fatalf("事件数量 = %d，至少应有 3 个", len(chunks))
+	}
+
+	// 每个事件都是截至当前的部分对象：commentary、code、text 只增不减，前一个事件是后一个的前缀
+	var previous E2BResponse
+	for i, chunk := range chunks {
+		current := chunk.Fragment
+		if !strings.HasPrefix(current.Commentary, previous.Commentary) ||
+			!strings.HasPrefix(current.Code, previous.Code) ||
+			!strings.HasPrefix(current.Text, previous.Text) {
+			t.Fatalf("第 %d 个事件不是前一个事件的延续: %+v", i, current)
+		}
+		if chunk.Done != (i == len(chunks)-1) {
+			t.Fatalf("只有最后一个事件的 done 为 true，第 %d 个为 %v", i, chunk.Done)
+		}
+		previous = current
+	}
+	if chunks[0].Fragment.Code != "" || chunks[0].Fragment.Template != "" {
+		t.Errorf("第一个事件不应包含尚未生成的字段: %+v", chunks[0].Fragment)
+	}
+	if chunks[len(chunks)-2].Fragment.Text == "" {
+		t.Error("中间事件应包含 text")
+	}
+
+	final := chunks[len(chunks)-1].Fragment
+	want := E2BResponse{
+		Commentary: fragment.Commentary,
+		Template:   fragment.Template,
+		Title:      fragment.Title,
+		Port:       fragment.Port,
+		FilePath:   fragment.FilePath,
+		Code:       fragment.Code,
+		Text:       fragment.Text,
+	}
+	if !reflect.DeepEqual(final, want) {
+		t.Errorf("最后一个事件应为完整的 fragment，实际 %+v", final)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 健康检查相关环境变量
+const (
+	ENV_PROBE_INTERVAL = "E2B_PROBE_INTERVAL" // 合成上游探测的间隔，0表示不探测
+	ENV_PROBE_TIMEOUT  = "E2B_PROBE_TIMEOUT"  // 单次探测的超时时间
+	ENV_PROBE_MODEL    = "E2B_PROBE_MODEL"    // 探测使用的模型，留空时使用第一个已启用的模型
+)
+
+// 构建信息，通过 -ldflags "-X main.version=... -X main.commit=... -X main.buildTime=..." 注入
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildTime = "unknown"
+)
+
+// processStartedAt 进程启动时间，用于计算运行时长
+var processStartedAt = time.Now()
+
+// 检查结果
+const (
+	CHECK_OK       = "ok"
+	CHECK_FAILING  = "failing"
+	CHECK_PENDING  = "pending"
+	CHECK_DISABLED = "disabled"
+)
+
+// ProbeStatus 最近一次合成上游探测的结果
+type ProbeStatus struct {
+	Status    string     `json:"status"`
+	Model     string     `json:"model,omitempty"`
+	CheckedAt *time.Time `json:"checked_at,omitempty"`
+	LatencyMs int64      `json:"latency_ms,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// upstreamProber 定期向上游发送一个最小请求，判断上游是否真正可用
+type upstreamProber struct {
+	mu     sync.Mutex
+	status ProbeStatus
+}
+
+var prober = &upstreamProber{status: ProbeStatus{Status: CHECK_DISABLED}}
+
+// Status 返回最近一次探测结果
+func (p *upstreamProber) Status() ProbeStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.status
+}
+
+// start 立即探测一次，之后按间隔探测。间隔不大于0时不探测
+func (p *upstreamProber) start(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	p.mu.Lock()
+	p.status = ProbeStatus{Status: CHECK_PENDING}
+	p.mu.Unlock()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			p.probe()
+			<-ticker.C
+		}
+	}()
+}
+
+func (p *upstreamProber) probe() {
+	requestID := GenerateUUID()
+	model := CONFIG.PROBE.MODEL
+	if model == "" {
+		if names := enabledModelNames(); len(names) > 0 {
+			model = names[0]
+		}
+	}
+	checkedAt := time.Now()
+	status := ProbeStatus{Status: CHECK_FAILING, Model: model, CheckedAt: &checkedAt}
+
+	err := p.call(requestID, model)
+	var failure *upstreamError
+	if errors.As(err, &failure) && failure.Class == UPSTREAM_ERROR_CIRCUIT_OPEN {
+		// 熔断中就绪检查已经失败，探测结果保持不变，等熔断器放行试探请求
+		return
+	}
+	status.LatencyMs = time.Since(checkedAt).Milliseconds()
+	if err == nil {
+		status.Status = CHECK_OK
+	} else {
+		status.Error = err.Error()
+		logError(requestID, "上游探测失败", err)
+	}
+
+	p.mu.Lock()
+	previous := p.status.Status
+	p.status = status
+	p.mu.Unlock()
+	if previous != status.Status {
+		log.Printf("上游探测状态: %s -> %s (模型: %s, 耗时: %dms)", previous, status.Status, model, status.LatencyMs)
+	}
+}
+
+func (p *upstreamProber) call(requestID, model string) error {
+	modelConfig, ok := lookupModel(model)
+	if !ok {
+		return fmt.Errorf("探测模型不可用: %s", model)
+	}
+	e2bRequest, err := PrepareChatRequest(modelConfig, requestID, ChatRequest{
+		Model:    model,
+		Messages: []ChatMessage{{Role: "user", Content: "ping"}},
+	}, nil, PrepareOptions{
+		UserID:     ResolveUserID(""),
+		TemplateID: TEMPLATE_TEXT,
+	})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), CONFIG.PROBE.TIMEOUT)
+	defer cancel()
+	_, err = callE2B(ctx, requestID, e2bRequest)
+	return err
+}
+
+// configProblems 检查运行时配置是否仍然可用。模型可以通过管理接口禁用，因此需要在每次检查时重新判断
+func configProblems() []string {
+	var problems []string
+	if u, err := url.Parse(CONFIG.API.BASE_URL); err != nil || u.Scheme == "" || u.Host == "" {
+		problems = append(problems, fmt.Sprintf("上游地址无效: %s", CONFIG.API.BASE_URL))
+	}
+	if len(enabledModelNames()) == 0 {
+		problems = append(problems, "没有已启用的模型")
+	}
+	for _, err := range validateVirtualModels(CONFIG.ROUTING.VIRTUAL_MODELS) {
+		problems = append(problems, err.Error())
+	}
+	return problems
+}
+
+// buildInfo 版本和构建信息
+func buildInfo() gin.H {
+	return gin.H{
+		"version":    version,
+		"commit":     commit,
+		"build_time": buildTime,
+	}
+}
+
+// handleLivez 存活检查，只要进程能处理请求就返回200，不依赖上游
+func handleLivez(c *gin.Context) {
+	body := buildInfo()
+	body["status"] = CHECK_OK
+	body["uptime_seconds"] = int64(time.Since(processStartedAt).Seconds())
+	c.JSON(http.StatusOK, body)
+}
+
+// handleReadyz 就绪检查。正在退出、配置无效、上游熔断中或合成探测失败时返回503，
+// 使负载均衡不再转发新请求。/health 与此相同
+func handleReadyz(c *gin.Context) {
+	checks := gin.H{}
+	ready := true
+
+	if serverLifecycle.ShuttingDown() {
+		requests, streams := serverLifecycle.InFlight()
+		checks["shutdown"] = gin.H{"status": CHECK_FAILING, "in_flight": requests, "streams": streams}
+		ready = false
+	} else {
+		checks["shutdown"] = gin.H{"status": CHECK_OK}
+	}
+
+	if problems := configProblems(); len(problems) > 0 {
+		checks["config"] = gin.H{"status": CHECK_FAILING, "problems": problems}
+		ready = false
+	} else {
+		checks["config"] = gin.H{"status": CHECK_OK}
+	}
+
+	breaker := upstreamBreaker.Stats()
+	breakerStatus := CHECK_OK
+	if breaker.Threshold <= 0 {
+		breakerStatus = CHECK_DISABLED
+	} else if breaker.State == BREAKER_OPEN && time.Now().Before(*breaker.RetryAt) {
+		breakerStatus = CHECK_FAILING
+		ready = false
+	}
+	checks["breaker"] = gin.H{"status": breakerStatus, "detail": breaker}
+
+	probe := prober.Status()
+	if probe.Status == CHECK_FAILING || probe.Status == CHECK_PENDING {
+		ready = false
+	}
+	checks["probe"] = probe
+
+	body := buildInfo()
+	body["checks"] = checks
+	status := http.StatusOK
+	body["status"] = CHECK_OK
+	if serverLifecycle.ShuttingDown() {
+		body["status"] = "shutting_down"
+		status = http.StatusServiceUnavailable
+	} else if !ready {
+		body["status"] = "unavailable"
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, body)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// withHealthState 临时替换健康检查依赖的全局状态
+func withHealthState(t *testing.T, baseURL string) {
+	savedLifecycle, savedBreaker, savedProber := serverLifecycle, upstreamBreaker, prober
+	savedAPI, savedProbe := CONFIG.API, CONFIG.PROBE
+	t.Cleanup(func() {
+		serverLifecycle, upstreamBreaker, prober = savedLifecycle, savedBreaker, savedProber
+		CONFIG.API, CONFIG.PROBE = savedAPI, savedProbe
+	})
+
+	serverLifecycle = &lifecycle{}
+	upstreamBreaker = NewBreaker(0, 0)
+	prober = &upstreamProber{status: ProbeStatus{Status: CHECK_DISABLED}}
+	CONFIG.API.BASE_URL = baseURL
+	CONFIG.PROBE.MODEL, CONFIG.PROBE.TIMEOUT = "", time.Second
+}
+
+func readyz(t *testing.T) (int, map[string]interface{}) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	handleReadyz(c)
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	return w.Code, body
+}
+
+func TestHandleReadyz(t *testing.T) {
+	tests := []struct {
+		name       string
+		setup      func()
+		wantStatus int
+		wantBody   string
+		failing    string
+	}{
+		{"正常", func() {}, http.StatusOK, CHECK_OK, ""},
+		{"正在退出", func() { serverLifecycle.shuttingDown.Store(true) }, http.StatusServiceUnavailable, "shutting_down", "shutdown"},
+		{"上游地址无效", func() { CONFIG.API.BASE_URL = "not a url" }, http.StatusServiceUnavailable, "unavailable", "config"},
+		{"熔断中", func() {
+			upstreamBreaker = NewBreaker(1, time.Minute)
+			upstreamBreaker.Failure()
+		}, http.StatusServiceUnavailable, "unavailable", "breaker"},
+		{"熔断冷却结束", func() {
+			upstreamBreaker = NewBreaker(1, time.Millisecond)
+			upstreamBreaker.Failure()
+			time.Sleep(2 * time.Millisecond)
+		}, http.StatusOK, CHECK_OK, ""},
+		{"探测失败", func() { prober.status = ProbeStatus{Status: CHECK_FAILING} }, http.StatusServiceUnavailable, "unavailable", "probe"},
+		{"等待首次探测", func() { prober.status = ProbeStatus{Status: CHECK_PENDING} }, http.StatusServiceUnavailable, "unavailable", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withHealthState(t, "http://upstream.test")
+			tt.setup()
+
+			status, body := readyz(t)
+			if status != tt.wantStatus || body["status"] != tt.wantBody {
+				t.Fatalf("readyz = %d %v，期望 %d %s", status, body["status"], tt.wantStatus, tt.wantBody)
+			}
+			if body["version"] == nil || body["commit"] == nil {
+				t.Errorf("应包含构建信息: %v", body)
+			}
+			if tt.failing != "" {
+				check, _ := body["checks"].(map[string]interface{})[tt.failing].(map[string]interface{})
+				if check["status"] != CHECK_FAILING {
+					t.Errorf("检查项 %s = %v，期望 failing", tt.failing, check)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleLivez(t *testing.T) {
+	withHealthState(t, "not a url")
+	serverLifecycle.shuttingDown.Store(true)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	handleLivez(c)
+	if w.Code != http.StatusOK {
+		t.Fatalf("存活检查不应依赖上游和退出状态，状态码 = %d", w.Code)
+	}
+}
+
+func TestUpstreamProbe(t *testing.T) {
+	status := http.StatusOK
+	body := `{"text":"pong"}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+	withHealthState(t, server.URL)
+
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   string
+	}{
+		{"上游正常", http.StatusOK, `{"text":"pong"}`, CHECK_OK},
+		{"上游错误", http.StatusInternalServerError, `oops`, CHECK_FAILING},
+		{"空响应", http.StatusOK, `{}`, CHECK_FAILING},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body = tt.status, tt.body
+			prober.probe()
+			got := prober.Status()
+			if got.Status != tt.want || got.CheckedAt == nil || got.Model == "" {
+				t.Fatalf("探测结果 = %+v，期望 %s", got, tt.want)
+			}
+			if (got.Error != "") != (tt.want == CHECK_FAILING) {
+				t.Errorf("失败时才应记录错误: %q", got.Error)
+			}
+		})
+	}
+
+	// 熔断中不探测，保留上一次的结果
+	prober.status = ProbeStatus{Status: CHECK_OK}
+	upstreamBreaker = NewBreaker(1, time.Minute)
+	upstreamBreaker.Failure()
+	prober.probe()
+	if got := prober.Status(); got.Status != CHECK_OK {
+		t.Errorf("熔断中探测结果应保持不变，实际 %+v", got)
+	}
+}
+
+func TestCallE2BBreaker(t *testing.T) {
+	body := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+	withHealthState(t, server.URL)
+
+	tests := []struct {
+		name         string
+		body         string
+		wantFailures int
+	}{
+		{"无法解析", `{"text":`, 1},
+		{"没有内容", `{"text":"  "}`, 1},
+		{"正常响应", `{"text":"pong"}`, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstreamBreaker = NewBreaker(3, time.Minute)
+			body = tt.body
+			_, err := callE2B(context.Background(), "test", E2BRequest{})
+			if (err != nil) != (tt.wantFailures > 0) {
+				t.Fatalf("callE2B err = %v", err)
+			}
+			if got := upstreamBreaker.Stats().ConsecutiveFailures; got != tt.wantFailures {
+				t.Errorf("连续失败次数 = %d，期望 %d", got, tt.wantFailures)
+			}
+		})
+	}
+}
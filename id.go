@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ID相关环境变量
+const (
+	ENV_UUID_VERSION      = "E2B_UUID_VERSION"     // 请求ID使用的UUID版本: v4 或 v7
+	ENV_USER_ID_STRATEGY  = "E2B_USER_ID_STRATEGY" // 发送给E2B的userID策略: random, per-key, fixed
+	ENV_USER_ID           = "E2B_USER_ID"          // fixed 策略下使用的userID
+	ENV_USER_ID_SALT      = "E2B_USER_ID_SALT"     // per-key 策略下派生userID使用的盐
+	completionIDAlphabet  = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+	completionIDRandomLen = 29
+)
+
+// userID策略
+const (
+	USER_ID_RANDOM  = "random"
+	USER_ID_PER_KEY = "per-key"
+	USER_ID_FIXED   = "fixed"
+)
+
+// GenerateUUID 生成请求ID，版本由 CONFIG.ID.UUID_VERSION 决定
+func GenerateUUID() string {
+	if CONFIG.ID.UUID_VERSION == "v7" {
+		return NewUUIDv7()
+	}
+	return NewUUIDv4()
+}
+
+// NewUUIDv4 使用加密安全的随机数生成UUIDv4
+func NewUUIDv4() string {
+	var b [16]byte
+	mustReadRandom(b[:])
+
+	b[6] = (b[6] & 0x0f) | 0x40 // Version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // Variant 1
+
+	return formatUUID(b)
+}
+
+// NewUUIDv7 生成带毫秒时间戳前缀的UUIDv7，按时间有序，便于日志检索
+func NewUUIDv7() string {
+	var b [16]byte
+	mustReadRandom(b[6:])
+
+	ms := uint64(time.Now().UnixMilli())
+	b[0] = byte(ms >> 40)
+	b[1] = byte(ms >> 32)
+	b[2] = byte(ms >> 24)
+	b[3] = byte(ms >> 16)
+	b[4] = byte(ms >> 8)
+	b[5] = byte(ms)
+
+	b[6] = (b[6] & 0x0f) | 0x70 // Version 7
+	b[8] = (b[8] & 0x3f) | 0x80 // Variant 1
+
+	return formatUUID(b)
+}
+
+// GenerateCompletionID 生成OpenAI风格的补全ID，例如 chatcmpl-AbC...
+func GenerateCompletionID() string {
+	return "chatcmpl-" + randomString(completionIDRandomLen)
+}
+
+// GenerateFragmentID 生成 /v1/fragments 响应的ID
+func GenerateFragmentID() string {
+	return "frag-" + randomString(completionIDRandomLen)
+}
+
+// ResolveUserID 根据配置的策略确定发送给E2B的userID，per-key 策略按密钥ID派生，轮换密钥后保持不变
+func ResolveUserID(keyID string) string {
+	switch CONFIG.ID.USER_ID_STRATEGY {
+	case USER_ID_FIXED:
+		if CONFIG.ID.USER_ID != "" {
+			return CONFIG.ID.USER_ID
+		}
+	case USER_ID_PER_KEY:
+		if keyID != "" {
+			return deriveUserID(keyID)
+		}
+	}
+	return NewUUIDv4()
+}
+
+// deriveUserID 由密钥ID派生稳定的userID
+func deriveUserID(keyID string) string {
+	mac := hmac.New(sha256.New, []byte(CONFIG.ID.USER_ID_SALT))
+	mac.Write([]byte(keyID))
+	sum := mac.Sum(nil)
+
+	var b [16]byte
+	copy(b[:], sum)
+	b[6] = (b[6] & 0x0f) | 0x50 // Version 5 风格的名称派生UUID
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return formatUUID(b)
+}
+
+// validUserIDStrategy 校验userID策略配置
+func validUserIDStrategy(strategy string) bool {
+	switch strategy {
+	case USER_ID_RANDOM, USER_ID_PER_KEY, USER_ID_FIXED:
+		return true
+	}
+	return false
+}
+
+// randomString 生成指定长度的字母数字随机串（拒绝采样，避免取模偏差）
+func randomString(n int) string {
+	var sb strings.Builder
+	sb.Grow(n)
+	buf := make([]byte, n*2)
+	for sb.Len() < n {
+		mustReadRandom(buf)
+		for _, c := range buf {
+			if c >= 248 { // 248 = 62*4
+				continue
+			}
+			sb.WriteByte(completionIDAlphabet[int(c)%len(completionIDAlphabet)])
+			if sb.Len() == n {
+				break
+			}
+		}
+	}
+	return sb.String()
+}
+
+// mustReadRandom 读取加密随机数，失败时直接panic，避免退化为可预测ID
+func mustReadRandom(b []byte) {
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("crypto/rand 读取失败: %v", err))
+	}
+}
+
+func formatUUID(b [16]byte) string {
+	return fmt.Sprintf("%08x-%04x-%04x-%04x-%012x",
+		binary.BigEndian.Uint32(b[0:4]),
+		binary.BigEndian.Uint16(b[4:6]),
+		binary.BigEndian.Uint16(b[6:8]),
+		binary.BigEndian.Uint16(b[8:10]),
+		b[10:])
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/hex"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+func parseUUIDBytes(t *testing.T, id string) []byte {
+	t.Helper()
+	if !uuidPattern.MatchString(id) {
+		t.Fatalf("UUID格式错误: %q", id)
+	}
+	b, err := hex.DecodeString(strings.ReplaceAll(id, "-", ""))
+	if err != nil {
+		t.Fatalf("解析UUID失败: %v", err)
+	}
+	return b
+}
+
+func TestUUIDVersionAndVariant(t *testing.T) {
+	tests := []struct {
+		name    string
+		gen     func() string
+		version byte
+	}{
+		{"v4", NewUUIDv4, 4},
+		{"v7", NewUUIDv7, 7},
+		{"per-key", func() string { return deriveUserID("key-1") }, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				b := parseUUIDBytes(t, tt.gen())
+				if got := b[6] >> 4; got != tt.version {
+					t.Fatalf("版本位 = %d, 期望 %d", got, tt.version)
+				}
+				if got := b[8] >> 6; got != 0b10 {
+					t.Fatalf("变体位 = %b, 期望 10", got)
+				}
+			}
+		})
+	}
+}
+
+func TestUUIDv7Ordered(t *testing.T) {
+	prev := NewUUIDv7()
+	for i := 0; i < 50; i++ {
+		next := NewUUIDv7()
+		// 前48位是毫秒时间戳，不会倒退
+		if next[:13] < prev[:13] {
+			t.Fatalf("UUIDv7 时间戳倒退: %s 之后生成了 %s", prev, next)
+		}
+		prev = next
+	}
+}
+
+func TestDeriveUserIDStable(t *testing.T) {
+	if deriveUserID("key-1") != deriveUserID("key-1") {
+		t.Fatal("同一密钥ID派生的userID不一致")
+	}
+	if deriveUserID("key-1") == deriveUserID("key-2") {
+		t.Fatal("不同密钥ID派生出相同的userID")
+	}
+}
+
+func TestGenerateCompletionID(t *testing.T) {
+	pattern := regexp.MustCompile(`^chatcmpl-[A-Za-z0-9]{29}$`)
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		id := GenerateCompletionID()
+		if !pattern.MatchString(id) {
+			t.Fatalf("补全ID格式错误: %q", id)
+		}
+		if seen[id] {
+			t.Fatalf("补全ID重复: %q", id)
+		}
+		seen[id] = true
+	}
+}
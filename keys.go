@@ -0,0 +1,379 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 密钥管理相关环境变量
+const (
+	ENV_KEYS_FILE           = "E2B_KEYS_FILE"          // API密钥持久化文件，留空则只保存在内存中
+	ENV_KEY_ROTATION_GRACE  = "E2B_KEY_ROTATION_GRACE" // 轮换后旧密钥的宽限期，例如 10m
+	DEFAULT_KEY_ID          = "default"
+	generatedKeyRandomBytes = 40
+)
+
+var (
+	errKeyNotFound = errors.New("API密钥不存在")
+	errKeyRevoked  = errors.New("API密钥已被吊销")
+)
+
+// APIKey 调用方API密钥
+type APIKey struct {
+	ID                string     `json:"id"`
+	Name              string     `json:"name"`
+	Tier              string     `json:"tier,omitempty"`
+	PromptPreset      string     `json:"prompt_preset,omitempty"`
+	MaxConcurrency    int        `json:"max_concurrency,omitempty"`
+	Key               string     `json:"key"`
+	CreatedAt         time.Time  `json:"created_at"`
+	RotatedAt         *time.Time `json:"rotated_at,omitempty"`
+	RevokedAt         *time.Time `json:"revoked_at,omitempty"`
+	PreviousKey       string     `json:"previous_key,omitempty"`
+	PreviousExpiresAt *time.Time `json:"previous_expires_at,omitempty"`
+}
+
+// APIKeyView 对外展示的密钥信息，密钥经过掩码处理
+type APIKeyView struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	Tier         string `json:"tier"`
+	PromptPreset string `json:"prompt_preset,omitempty"`
+	// MaxConcurrency 密钥生效的并发上限，0表示不限制
+	MaxConcurrency int        `json:"max_concurrency"`
+	Key            string     `json:"key"`
+	Active         bool       `json:"active"`
+	CreatedAt      time.Time  `json:"created_at"`
+	RotatedAt      *time.Time `json:"rotated_at,omitempty"`
+	RevokedAt      *time.Time `json:"revoked_at,omitempty"`
+}
+
+// View 返回掩码后的密钥信息
+func (k *APIKey) View() APIKeyView {
+	return APIKeyView{
+		ID:             k.ID,
+		Name:           k.Name,
+		Tier:           k.EffectiveTier(),
+		PromptPreset:   k.PromptPreset,
+		MaxConcurrency: keyConcurrencyLimit(k),
+		Key:            maskString(k.Key, 8),
+		Active:         k.RevokedAt == nil,
+		CreatedAt:      k.CreatedAt,
+		RotatedAt:      k.RotatedAt,
+		RevokedAt:      k.RevokedAt,
+	}
+}
+
+// EffectiveTier 返回密钥的等级，未设置时为 default，用于路由规则匹配
+func (k *APIKey) EffectiveTier() string {
+	if k.Tier == "" {
+		return DEFAULT_KEY_TIER
+	}
+	return k.Tier
+}
+
+// KeySettings 密钥的可修改属性，nil 表示不修改
+type KeySettings struct {
+	Tier         *string `json:"tier"`
+	PromptPreset *string `json:"prompt_preset"`
+	// MaxConcurrency 0 表示使用全局默认 E2B_KEY_CONCURRENCY
+	MaxConcurrency *int `json:"max_concurrency"`
+}
+
+func (settings KeySettings) apply(key *APIKey) {
+	if settings.Tier != nil {
+		key.Tier = strings.TrimSpace(*settings.Tier)
+	}
+	if settings.PromptPreset != nil {
+		key.PromptPreset = strings.TrimSpace(*settings.PromptPreset)
+	}
+	if settings.MaxConcurrency != nil {
+		key.MaxConcurrency = *settings.MaxConcurrency
+	}
+}
+
+// KeyStore 管理调用方API密钥，支持运行时创建、吊销和轮换
+type KeyStore struct {
+	mu    sync.RWMutex
+	path  string
+	grace time.Duration
+	keys  map[string]*APIKey
+}
+
+// keyStore 全局密钥存储
+var keyStore *KeyStore
+
+// initKeyStore 加载持久化的密钥，并确保环境变量中的密钥作为 default 密钥存在
+func initKeyStore() {
+	store, err := NewKeyStore(CONFIG.KEYS.FILE, CONFIG.KEYS.ROTATION_GRACE)
+	if err != nil {
+		log.Fatalf("加载API密钥文件失败: %v", err)
+	}
+	if err := store.ensureDefault(CONFIG.API.API_KEY); err != nil {
+		log.Fatalf("更新 default 密钥失败: %v", err)
+	}
+	keyStore = store
+	log.Printf("已加载 %d 个API密钥", len(store.keys))
+}
+
+// NewKeyStore 创建密钥存储，path为空时不持久化
+func NewKeyStore(path string, grace time.Duration) (*KeyStore, error) {
+	store := &KeyStore{path: path, grace: grace, keys: make(map[string]*APIKey)}
+	if path == "" {
+		return store, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var keys []*APIKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		store.keys[key.ID] = key
+	}
+	return store, nil
+}
+
+// ensureDefault 确保环境变量中的密钥作为 default 密钥存在。持久化文件中的 default 密钥与环境变量不一致时
+// （如通过管理接口轮换后修改了环境变量），以环境变量为准并写回文件，已吊销的 default 密钥保持吊销
+func (s *KeyStore) ensureDefault(secret string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if secret == "" {
+		return nil
+	}
+	key, ok := s.keys[DEFAULT_KEY_ID]
+	if !ok {
+		s.keys[DEFAULT_KEY_ID] = &APIKey{
+			ID:        DEFAULT_KEY_ID,
+			Name:      "环境变量 " + ENV_API_KEY,
+			Key:       secret,
+			CreatedAt: time.Now().UTC(),
+		}
+		return nil
+	}
+	if key.Key == secret {
+		return nil
+	}
+	if key.RevokedAt != nil {
+		log.Printf("警告: default 密钥已被吊销，%s 中的密钥不会生效", ENV_API_KEY)
+		return nil
+	}
+
+	previous := *key
+	now := time.Now().UTC()
+	key.Key = secret
+	key.PreviousKey = ""
+	key.PreviousExpiresAt = nil
+	key.RotatedAt = &now
+	if err := s.saveLocked(); err != nil {
+		*key = previous
+		return err
+	}
+	log.Printf("%s 与持久化的 default 密钥不一致，已改用环境变量中的密钥", ENV_API_KEY)
+	return nil
+}
+
+// Authenticate 校验调用方提供的密钥，轮换后的旧密钥在宽限期内仍然有效
+func (s *KeyStore) Authenticate(secret string) (*APIKey, bool) {
+	if secret == "" {
+		return nil, false
+	}
+	now := time.Now()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, key := range s.keys {
+		if key.RevokedAt != nil {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(key.Key), []byte(secret)) == 1 {
+			copied := *key
+			return &copied, true
+		}
+		if key.PreviousKey != "" && key.PreviousExpiresAt != nil && now.Before(*key.PreviousExpiresAt) &&
+			subtle.ConstantTimeCompare([]byte(key.PreviousKey), []byte(secret)) == 1 {
+			copied := *key
+			return &copied, true
+		}
+	}
+	return nil, false
+}
+
+// List 按创建时间返回所有密钥
+func (s *KeyStore) List() []APIKeyView {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	views := make([]APIKeyView, 0, len(s.keys))
+	for _, key := range s.keys {
+		views = append(views, key.View())
+	}
+	sort.Slice(views, func(i, j int) bool {
+		return views[i].CreatedAt.Before(views[j].CreatedAt)
+	})
+	return views
+}
+
+// Get 按ID获取密钥
+func (s *KeyStore) Get(id string) (*APIKey, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key, ok := s.keys[id]
+	if !ok {
+		return nil, false
+	}
+	copied := *key
+	return &copied, true
+}
+
+// Create 生成新密钥，返回值中包含完整密钥，仅此一次可见
+func (s *KeyStore) Create(name string, settings KeySettings) (*APIKey, error) {
+	key := &APIKey{
+		ID:        "key_" + randomString(16),
+		Name:      name,
+		Key:       "sk-" + randomString(generatedKeyRandomBytes),
+		CreatedAt: time.Now().UTC(),
+	}
+	settings.apply(key)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[key.ID] = key
+	if err := s.saveLocked(); err != nil {
+		delete(s.keys, key.ID)
+		return nil, err
+	}
+	copied := *key
+	return &copied, nil
+}
+
+// Update 修改密钥的等级、默认提示词预设等属性
+func (s *KeyStore) Update(id string, settings KeySettings) (*APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[id]
+	if !ok {
+		return nil, errKeyNotFound
+	}
+	previous := *key
+	settings.apply(key)
+	if err := s.saveLocked(); err != nil {
+		*key = previous
+		return nil, err
+	}
+	copied := *key
+	return &copied, nil
+}
+
+// Revoke 吊销密钥，吊销后立即失效
+func (s *KeyStore) Revoke(id string) (*APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[id]
+	if !ok {
+		return nil, errKeyNotFound
+	}
+	if key.RevokedAt != nil {
+		return nil, errKeyRevoked
+	}
+	previous := *key
+	now := time.Now().UTC()
+	key.RevokedAt = &now
+	key.PreviousKey = ""
+	key.PreviousExpiresAt = nil
+	if err := s.saveLocked(); err != nil {
+		*key = previous
+		return nil, err
+	}
+	copied := *key
+	return &copied, nil
+}
+
+// Rotate 为密钥生成新值，旧值在宽限期内仍可使用
+func (s *KeyStore) Rotate(id string) (*APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[id]
+	if !ok {
+		return nil, errKeyNotFound
+	}
+	if key.RevokedAt != nil {
+		return nil, errKeyRevoked
+	}
+	previous := *key
+	now := time.Now().UTC()
+	if s.grace > 0 {
+		expiresAt := now.Add(s.grace)
+		key.PreviousKey = key.Key
+		key.PreviousExpiresAt = &expiresAt
+	} else {
+		key.PreviousKey = ""
+		key.PreviousExpiresAt = nil
+	}
+	key.Key = "sk-" + randomString(generatedKeyRandomBytes)
+	key.RotatedAt = &now
+	if err := s.saveLocked(); err != nil {
+		*key = previous
+		return nil, err
+	}
+	copied := *key
+	return &copied, nil
+}
+
+// saveLocked 原子地写入持久化文件，调用方需持有写锁
+func (s *KeyStore) saveLocked() error {
+	if s.path == "" {
+		return nil
+	}
+	keys := make([]*APIKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".keys-*")
+	if err != nil {
+		return fmt.Errorf("写入API密钥文件失败: %w", err)
+	}
+	_, err = tmp.Write(data)
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0o600)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("写入API密钥文件失败: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeyStoreLifecycle(t *testing.T) {
+	store, err := NewKeyStore("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.ensureDefault("sk-env")
+	if key, ok := store.Authenticate("sk-env"); !ok || key.ID != DEFAULT_KEY_ID {
+		t.Fatalf("环境变量中的密钥应作为 default 密钥: %+v", key)
+	}
+
+	created, err := store.Create("ci", KeySettings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(created.Key, "sk-") || !strings.HasPrefix(created.ID, "key_") {
+		t.Fatalf("Create = %+v", created)
+	}
+	if key, ok := store.Authenticate(created.Key); !ok || key.ID != created.ID {
+		t.Fatal("新密钥应立即可用")
+	}
+	if view := created.View(); view.Key == created.Key || !view.Active {
+		t.Fatalf("View 应掩码密钥: %+v", view)
+	}
+	if created.EffectiveTier() != "default" {
+		t.Errorf("未指定等级时应为 default，实际 %s", created.EffectiveTier())
+	}
+	tier := "pro"
+	if pro, _ := store.Create("pro", KeySettings{Tier: &tier}); pro.View().Tier != "pro" {
+		t.Errorf("等级 = %s，期望 pro", pro.View().Tier)
+	}
+	if views := store.List(); len(views) != 3 || views[0].ID != DEFAULT_KEY_ID {
+		t.Fatalf("List = %+v", views)
+	}
+
+	revoked, err := store.Revoke(created.ID)
+	if err != nil || revoked.RevokedAt == nil {
+		t.Fatalf("Revoke = %+v, %v", revoked, err)
+	}
+	if _, ok := store.Authenticate(created.Key); ok {
+		t.Fatal("吊销后的密钥不应通过认证")
+	}
+	if _, err := store.Revoke(created.ID); !errors.Is(err, errKeyRevoked) {
+		t.Fatalf("重复吊销应返回 errKeyRevoked，实际: %v", err)
+	}
+	if _, err := store.Rotate(created.ID); !errors.Is(err, errKeyRevoked) {
+		t.Fatalf("轮换已吊销的密钥应返回 errKeyRevoked，实际: %v", err)
+	}
+	if _, err := store.Revoke("missing"); !errors.Is(err, errKeyNotFound) {
+		t.Fatalf("不存在的密钥应返回 errKeyNotFound，实际: %v", err)
+	}
+	if _, ok := store.Authenticate(""); ok {
+		t.Fatal("空密钥不应通过认证")
+	}
+}
+
+func TestKeyStoreRotateGrace(t *testing.T) {
+	tests := []struct {
+		name        string
+		grace       time.Duration
+		wait        time.Duration
+		oldAccepted bool
+	}{
+		{"没有宽限期时旧密钥立即失效", 0, 0, false},
+		{"宽限期内旧密钥仍可用", time.Minute, 0, true},
+		{"宽限期过后旧密钥失效", 10 * time.Millisecond, 20 * time.Millisecond, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, _ := NewKeyStore("", tt.grace)
+			created, _ := store.Create("ci", KeySettings{})
+			rotated, err := store.Rotate(created.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rotated.Key == created.Key || rotated.RotatedAt == nil {
+				t.Fatalf("Rotate = %+v", rotated)
+			}
+			time.Sleep(tt.wait)
+			if _, ok := store.Authenticate(rotated.Key); !ok {
+				t.Fatal("新密钥应可用")
+			}
+			if _, ok := store.Authenticate(created.Key); ok != tt.oldAccepted {
+				t.Fatalf("旧密钥认证结果 = %v，期望 %v", ok, tt.oldAccepted)
+			}
+		})
+	}
+}
+
+func TestKeyStorePersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	store, err := NewKeyStore(path, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	created, _ := store.Create("ci", KeySettings{})
+	rotated, _ := store.Rotate(created.ID)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("密钥文件权限 = %o，期望 600", info.Mode().Perm())
+	}
+
+	reloaded, err := NewKeyStore(path, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := reloaded.Authenticate(rotated.Key); !ok {
+		t.Fatal("重新加载后新密钥应可用")
+	}
+	if _, ok := reloaded.Authenticate(created.Key); !ok {
+		t.Fatal("重新加载后宽限期内的旧密钥应可用")
+	}
+
+	os.WriteFile(path, []byte("not json"), 0o600)
+	if _, err := NewKeyStore(path, 0); err == nil {
+		t.Fatal("损坏的密钥文件应返回错误")
+	}
+}
+
+func TestKeyStoreCreateRollback(t *testing.T) {
+	// 持久化失败时不应留下只存在于内存中的密钥
+	store, _ := NewKeyStore(filepath.Join(t.TempDir(), "missing", "keys.json"), 0)
+	if _, err := store.Create("ci", KeySettings{}); err == nil {
+		t.Fatal("持久化失败时应返回错误")
+	}
+	if views := store.List(); len(views) != 0 {
+		t.Fatalf("失败的创建不应保留: %+v", views)
+	}
+}
+
+func TestKeyStoreUpdate(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "keys")
+	os.Mkdir(dir, 0o700)
+	store, _ := NewKeyStore(filepath.Join(dir, "keys.json"), 0)
+	created, _ := store.Create("ci", KeySettings{})
+
+	tier, preset := " pro ", "concise"
+	updated, err := store.Update(created.ID, KeySettings{Tier: &tier, PromptPreset: &preset})
+	if err != nil {
+		t.Fatalf("更新密钥失败: %v", err)
+	}
+	if updated.Tier != "pro" || updated.PromptPreset != "concise" {
+		t.Errorf("更新结果不符: %+v", updated.View())
+	}
+
+	empty := ""
+	if updated, _ := store.Update(created.ID, KeySettings{PromptPreset: &empty}); updated.Tier != "pro" || updated.PromptPreset != "" {
+		t.Errorf("未提供的字段不应被修改: %+v", updated.View())
+	}
+
+	if _, err := store.Update("key_missing", KeySettings{}); !errors.Is(err, errKeyNotFound) {
+		t.Errorf("不存在的密钥应返回 errKeyNotFound，实际 %v", err)
+	}
+
+	// 持久化失败时恢复原值
+	os.RemoveAll(dir)
+	other := "free"
+	if _, err := store.Update(created.ID, KeySettings{Tier: &other}); err == nil {
+		t.Fatal("持久化失败时应返回错误")
+	}
+	if key, _ := store.Get(created.ID); key.Tier != "pro" {
+		t.Errorf("持久化失败后等级应恢复为 pro，实际 %s", key.Tier)
+	}
+}
+
+func TestKeyStoreRevokeRotateRollback(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "keys")
+	os.Mkdir(dir, 0o700)
+	store, _ := NewKeyStore(filepath.Join(dir, "keys.json"), time.Minute)
+	created, _ := store.Create("ci", KeySettings{})
+
+	// 持久化失败时密钥应保持原样，原密钥仍可使用
+	os.RemoveAll(dir)
+	if _, err := store.Rotate(created.ID); err == nil {
+		t.Fatal("持久化失败时轮换应返回错误")
+	}
+	if _, err := store.Revoke(created.ID); err == nil {
+		t.Fatal("持久化失败时吊销应返回错误")
+	}
+	key, _ := store.Get(created.ID)
+	if key.Key != created.Key || key.RotatedAt != nil || key.RevokedAt != nil || key.PreviousKey != "" {
+		t.Fatalf("持久化失败后密钥应恢复原值: %+v", key)
+	}
+	if _, ok := store.Authenticate(created.Key); !ok {
+		t.Fatal("持久化失败后原密钥应仍可使用")
+	}
+}
+
+func TestKeyStoreEnsureDefault(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	store, _ := NewKeyStore(path, time.Minute)
+	if err := store.ensureDefault("sk-env"); err != nil {
+		t.Fatal(err)
+	}
+	rotated, _ := store.Rotate(DEFAULT_KEY_ID)
+
+	tests := []struct {
+		name     string
+		secret   string
+		revoke   bool
+		accepted []string
+		rejected []string
+	}{
+		{"轮换后未同步环境变量时恢复为环境变量", "sk-env", false, []string{"sk-env"}, []string{rotated.Key}},
+		{"环境变量修改后以环境变量为准", "sk-new", false, []string{"sk-new"}, []string{"sk-env"}},
+		{"与环境变量一致时不修改", "sk-new", false, []string{"sk-new"}, nil},
+		{"已吊销的密钥保持吊销", "sk-other", true, nil, []string{"sk-new", "sk-other"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.revoke {
+				store.Revoke(DEFAULT_KEY_ID)
+			}
+			// 模拟重启：重新加载持久化文件
+			reloaded, err := NewKeyStore(path, time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := reloaded.ensureDefault(tt.secret); err != nil {
+				t.Fatal(err)
+			}
+			for _, secret := range tt.accepted {
+				if _, ok := reloaded.Authenticate(secret); !ok {
+					t.Errorf("%s 应通过认证", secret)
+				}
+			}
+			for _, secret := range tt.rejected {
+				if _, ok := reloaded.Authenticate(secret); ok {
+					t.Errorf("%s 不应通过认证", secret)
+				}
+			}
+			store = reloaded
+		})
+	}
+}
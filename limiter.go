@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/yourusername/e2b-api-gateway/apierror"
+)
+
+// 并发限制相关环境变量
+const (
+	ENV_KEY_CONCURRENCY    = "E2B_KEY_CONCURRENCY"    // 每个API密钥同时进行的上游调用数上限，0表示不限制
+	ENV_KEY_QUEUE_TIMEOUT  = "E2B_KEY_QUEUE_TIMEOUT"  // 等待并发名额的最长时间
+	ENV_MAX_N              = "E2B_MAX_N"              // 单个请求的 n 上限
+	ENV_FANOUT_CONCURRENCY = "E2B_FANOUT_CONCURRENCY" // 单个请求内 n 个选项同时进行的上游调用数上限
+)
+
+// KeyLimiter 按API密钥限制同时进行的上游调用数
+type KeyLimiter struct {
+	mu    sync.Mutex
+	slots map[string]*keySlots
+}
+
+type keySlots struct {
+	sem      chan struct{}
+	inFlight int
+	waiting  int
+	rejected int64
+}
+
+// KeyLimiterStats 单个密钥的并发状态
+type KeyLimiterStats struct {
+	KeyID    string `json:"key_id"`
+	Limit    int    `json:"limit"`
+	InFlight int    `json:"in_flight"`
+	Waiting  int    `json:"waiting"`
+	Rejected int64  `json:"rejected"`
+}
+
+// keyLimiter 全局并发限制器
+var keyLimiter = NewKeyLimiter()
+
+// NewKeyLimiter 创建并发限制器
+func NewKeyLimiter() *KeyLimiter {
+	return &KeyLimiter{slots: make(map[string]*keySlots)}
+}
+
+// Acquire 为密钥申请一个并发名额，limit 不大于0时不限制。
+// 名额不足时最多等待 timeout，超时返回 concurrency_limit_exceeded 错误；成功时返回释放函数
+func (l *KeyLimiter) Acquire(ctx context.Context, keyID string, limit int, timeout time.Duration) (func(), error) {
+	if limit <= 0 {
+		return func() {}, nil
+	}
+
+	l.mu.Lock()
+	slots, ok := l.slots[keyID]
+	// 限额被修改后使用新的信号量，已占用旧信号量的调用释放到旧信号量
+	if !ok || cap(slots.sem) != limit {
+		previous := slots
+		slots = &keySlots{sem: make(chan struct{}, limit)}
+		if previous != nil {
+			slots.rejected = previous.rejected
+		}
+		l.slots[keyID] = slots
+	}
+	slots.waiting++
+	l.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case slots.sem <- struct{}{}:
+	case <-timer.C:
+		err = apierror.ConcurrencyLimitExceeded()
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	l.mu.Lock()
+	slots.waiting--
+	if err != nil {
+		slots.rejected++
+	} else {
+		slots.inFlight++
+	}
+	l.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			slots.inFlight--
+			l.mu.Unlock()
+			<-slots.sem
+		})
+	}, nil
+}
+
+// Stats 返回各密钥的并发状态
+func (l *KeyLimiter) Stats() []KeyLimiterStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	stats := make([]KeyLimiterStats, 0, len(l.slots))
+	for keyID, slots := range l.slots {
+		stats = append(stats, KeyLimiterStats{
+			KeyID:    keyID,
+			Limit:    cap(slots.sem),
+			InFlight: slots.inFlight,
+			Waiting:  slots.waiting,
+			Rejected: slots.rejected,
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].KeyID < stats[j].KeyID
+	})
+	return stats
+}
+
+// keyConcurrencyLimit 返回密钥生效的并发上限，密钥自身的设置优先于全局默认
+func keyConcurrencyLimit(apiKey *APIKey) int {
+	if apiKey.MaxConcurrency > 0 {
+		return apiKey.MaxConcurrency
+	}
+	return CONFIG.LIMITS.KEY_CONCURRENCY
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/yourusername/e2b-api-gateway/apierror"
+)
+
+func TestKeyLimiterUnlimited(t *testing.T) {
+	l := NewKeyLimiter()
+	for i := 0; i < 5; i++ {
+		if _, err := l.Acquire(context.Background(), "k", 0, time.Millisecond); err != nil {
+			t.Fatalf("不限制时不应出错: %v", err)
+		}
+	}
+	if stats := l.Stats(); len(stats) != 0 {
+		t.Fatalf("不限制时不应记录状态: %+v", stats)
+	}
+}
+
+func TestKeyLimiterQueueAndRelease(t *testing.T) {
+	l := NewKeyLimiter()
+	release, err := l.Acquire(context.Background(), "k", 1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 名额占满时超时返回 429
+	_, err = l.Acquire(context.Background(), "k", 1, 10*time.Millisecond)
+	var apiErr *apierror.Error
+	if !errors.As(err, &apiErr) || apiErr.Status != 429 {
+		t.Fatalf("名额不足时应返回429，实际: %v", err)
+	}
+
+	// 其他密钥互不影响
+	other, err := l.Acquire(context.Background(), "other", 1, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("其他密钥不应受影响: %v", err)
+	}
+	other()
+
+	// 等待中的调用在释放后拿到名额
+	done := make(chan error, 1)
+	go func() {
+		next, err := l.Acquire(context.Background(), "k", 1, time.Second)
+		if err == nil {
+			next()
+		}
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if stats := l.Stats(); stats[0].KeyID != "k" || stats[0].Waiting != 1 || stats[0].InFlight != 1 {
+		t.Fatalf("Stats = %+v", stats)
+	}
+	release()
+	release() // 重复释放无效
+	if err := <-done; err != nil {
+		t.Fatalf("释放后应拿到名额: %v", err)
+	}
+
+	stats := l.Stats()
+	if len(stats) != 2 || stats[0].InFlight != 0 || stats[0].Waiting != 0 || stats[0].Rejected != 1 {
+		t.Fatalf("Stats = %+v", stats)
+	}
+}
+
+func TestKeyLimiterContextCanceled(t *testing.T) {
+	l := NewKeyLimiter()
+	release, _ := l.Acquire(context.Background(), "k", 1, time.Second)
+	defer release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := l.Acquire(ctx, "k", 1, time.Second); !errors.Is(err, context.Canceled) {
+		t.Fatalf("调用方取消时应返回 context.Canceled，实际: %v", err)
+	}
+}
+
+func TestKeyLimiterLimitChange(t *testing.T) {
+	l := NewKeyLimiter()
+	release, _ := l.Acquire(context.Background(), "k", 1, time.Second)
+	l.Acquire(context.Background(), "k", 1, time.Millisecond)
+
+	// 限额修改后使用新的信号量，拒绝计数保留
+	raised, err := l.Acquire(context.Background(), "k", 2, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("提高限额后应拿到名额: %v", err)
+	}
+	release()
+	raised()
+	stats := l.Stats()
+	if len(stats) != 1 || stats[0].Limit != 2 || stats[0].Rejected != 1 || stats[0].InFlight != 0 {
+		t.Fatalf("Stats = %+v", stats)
+	}
+}
+
+func TestKeyConcurrencyLimit(t *testing.T) {
+	saved := CONFIG.LIMITS.KEY_CONCURRENCY
+	CONFIG.LIMITS.KEY_CONCURRENCY = 4
+	t.Cleanup(func() { CONFIG.LIMITS.KEY_CONCURRENCY = saved })
+
+	if got := keyConcurrencyLimit(&APIKey{}); got != 4 {
+		t.Errorf("未设置时应使用全局默认，实际 %d", got)
+	}
+	if got := keyConcurrencyLimit(&APIKey{MaxConcurrency: 1}); got != 1 {
+		t.Errorf("密钥自身的设置应优先，实际 %d", got)
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// 监听相关环境变量
+const (
+	ENV_LISTENERS        = "E2B_LISTENERS"        // 监听地址列表，逗号分隔，如 public=tls://:8443,admin=tcp://127.0.0.1:9090，留空时使用 E2B_PORT
+	ENV_H2C              = "E2B_H2C"              // 是否在明文(tcp/unix)监听上支持 h2c
+	ENV_UNIX_SOCKET_MODE = "E2B_UNIX_SOCKET_MODE" // Unix socket 文件的权限(八进制)
+)
+
+// 监听协议
+const (
+	LISTENER_TCP  = "tcp"  // 明文HTTP
+	LISTENER_TLS  = "tls"  // HTTPS，同时支持 HTTP/2
+	LISTENER_UNIX = "unix" // Unix domain socket 上的明文HTTP
+)
+
+// 监听角色，决定该监听地址上开放哪些路径
+const (
+	LISTENER_ROLE_ALL    = "all"    // 开放全部路径
+	LISTENER_ROLE_PUBLIC = "public" // 不开放 /admin 管理接口和面板
+	LISTENER_ROLE_ADMIN  = "admin"  // 只开放 /admin 管理接口、面板、面板 Playground 用到的接口和健康检查
+)
+
+// ListenerSpec 一个监听地址
+type ListenerSpec struct {
+	Role    string `json:"role"`
+	Scheme  string `json:"scheme"`
+	Address string `json:"address"`
+}
+
+func (s ListenerSpec) String() string {
+	return fmt.Sprintf("%s=%s://%s", s.Role, s.Scheme, s.Address)
+}
+
+// gatewayListener 一个正在监听的地址及其HTTP服务
+type gatewayListener struct {
+	spec     ListenerSpec
+	listener net.Listener
+	server   *http.Server
+}
+
+// serve 阻塞直到服务关闭
+func (l *gatewayListener) serve() error {
+	if l.spec.Scheme == LISTENER_TLS {
+		// 证书由 TLSConfig.GetCertificate 提供，ServeTLS 会自动启用 HTTP/2
+		return l.server.ServeTLS(l.listener, "", "")
+	}
+	return l.server.Serve(l.listener)
+}
+
+// parseListenerSpecs 解析 E2B_LISTENERS。每一项为 [角色=]协议://地址，角色默认为 all，
+// 协议为 tcp、tls 或 unix，unix 的地址为 socket 文件路径
+func parseListenerSpecs(value string) ([]ListenerSpec, error) {
+	var specs []ListenerSpec
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		spec := ListenerSpec{Role: LISTENER_ROLE_ALL}
+		if role, rest, ok := strings.Cut(item, "="); ok {
+			spec.Role = strings.TrimSpace(role)
+			item = strings.TrimSpace(rest)
+		}
+		scheme, address, ok := strings.Cut(item, "://")
+		if !ok || address == "" {
+			return nil, fmt.Errorf("无效的监听地址 %q，格式为 [角色=]协议://地址", item)
+		}
+		spec.Scheme, spec.Address = scheme, address
+		switch spec.Scheme {
+		case LISTENER_TCP, LISTENER_TLS:
+			if _, _, err := net.SplitHostPort(spec.Address); err != nil {
+				return nil, fmt.Errorf("无效的监听地址 %q: %v", item, err)
+			}
+		case LISTENER_UNIX:
+		default:
+			return nil, fmt.Errorf("无效的监听协议 %q，可选: %s, %s, %s", spec.Scheme, LISTENER_TCP, LISTENER_TLS, LISTENER_UNIX)
+		}
+		switch spec.Role {
+		case LISTENER_ROLE_ALL, LISTENER_ROLE_PUBLIC, LISTENER_ROLE_ADMIN:
+		default:
+			return nil, fmt.Errorf("无效的监听角色 %q，可选: %s, %s, %s", spec.Role, LISTENER_ROLE_ALL, LISTENER_ROLE_PUBLIC, LISTENER_ROLE_ADMIN)
+		}
+		specs = append(specs, spec)
+	}
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("没有配置监听地址")
+	}
+	return specs, nil
+}
+
+// parseFileMode 解析八进制的文件权限，如 0660
+func parseFileMode(value string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("无效的文件权限: %s", value)
+	}
+	return os.FileMode(mode), nil
+}
+
+// openListeners 按配置打开所有监听地址，任何一个失败时关闭已打开的并返回错误
+func openListeners(specs []ListenerSpec, handler http.Handler) ([]*gatewayListener, error) {
+	var opened []*gatewayListener
+	for _, spec := range specs {
+		l, err := openListener(spec, handler)
+		if err != nil {
+			for _, o := range opened {
+				o.listener.Close()
+			}
+			return nil, fmt.Errorf("监听 %s 失败: %w", spec, err)
+		}
+		opened = append(opened, l)
+	}
+	return opened, nil
+}
+
+func openListener(spec ListenerSpec, handler http.Handler) (*gatewayListener, error) {
+	handler = roleHandler(spec.Role, handler)
+	server := &http.Server{Handler: handler}
+
+	var listener net.Listener
+	var err error
+	switch spec.Scheme {
+	case LISTENER_TLS:
+		if tlsCertificates == nil {
+			return nil, fmt.Errorf("未配置证书，请设置 %s 和 %s", ENV_TLS_CERT_FILE, ENV_TLS_KEY_FILE)
+		}
+		server.TLSConfig = tlsCertificates.TLSConfig()
+		listener, err = net.Listen("tcp", spec.Address)
+	case LISTENER_UNIX:
+		listener, err = listenUnix(spec.Address)
+	default:
+		listener, err = net.Listen("tcp", spec.Address)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if spec.Scheme != LISTENER_TLS && CONFIG.SERVER.H2C {
+		server.Handler = h2c.NewHandler(handler, &http2.Server{})
+	}
+	return &gatewayListener{spec: spec, listener: listener, server: server}, nil
+}
+
+// listenUnix 监听 Unix socket。上次异常退出留下的 socket 文件会先被删除，
+// 其他类型的文件不会被覆盖
+func listenUnix(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s 已存在且不是 socket 文件", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, CONFIG.SERVER.UNIX_SOCKET_MODE); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// isAdminPath 是否为管理接口或面板的路径
+func isAdminPath(path string) bool {
+	return path == "/admin" || strings.HasPrefix(path, "/admin/")
+}
+
+// isHealthPath 是否为健康检查路径
+func isHealthPath(path string) bool {
+	return path == "/livez" || path == "/readyz" || path == "/health"
+}
+
+// isPlaygroundPath 是否为面板 Playground 调用的网关接口，admin 角色的监听上也开放，仍需API密钥
+func isPlaygroundPath(path string) bool {
+	return path == "/v1/models" || path == "/v1/chat/completions"
+}
+
+// roleHandler 按监听角色过滤请求路径，未开放的路径返回404，与未注册的路径一致
+func roleHandler(role string, next http.Handler) http.Handler {
+	if role == LISTENER_ROLE_ALL {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		allowed := !isAdminPath(path)
+		if role == LISTENER_ROLE_ADMIN {
+			allowed = isAdminPath(path) || isHealthPath(path) || isPlaygroundPath(path)
+		}
+		if !allowed {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("服务运行成功，请使用正确请求路径"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// listenerURL 监听地址对应的访问地址，用于启动日志
+func listenerURL(spec ListenerSpec) string {
+	switch spec.Scheme {
+	case LISTENER_TLS:
+		return "https://" + spec.Address
+	case LISTENER_UNIX:
+		return "unix:" + spec.Address
+	}
+	return "http://" + spec.Address
+}
+
+// logListeners 输出所有监听地址
+func logListeners(listeners []*gatewayListener) {
+	for _, l := range listeners {
+		protocols := "HTTP/1.1"
+		if l.spec.Scheme == LISTENER_TLS || CONFIG.SERVER.H2C {
+			protocols += ", HTTP/2"
+		}
+		log.Printf("服务启动在 %s (角色: %s, 协议: %s)", listenerURL(l.spec), l.spec.Role, protocols)
+	}
+}
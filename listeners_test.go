@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParseListenerSpecs(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []ListenerSpec
+		wantErr bool
+	}{
+		{"tcp://:8080", []ListenerSpec{{LISTENER_ROLE_ALL, LISTENER_TCP, ":8080"}}, false},
+		{
+			"public=tls://:8443, admin=tcp://127.0.0.1:9090 ,public=unix:///run/e2b/gateway.sock",
+			[]ListenerSpec{
+				{LISTENER_ROLE_PUBLIC, LISTENER_TLS, ":8443"},
+				{LISTENER_ROLE_ADMIN, LISTENER_TCP, "127.0.0.1:9090"},
+				{LISTENER_ROLE_PUBLIC, LISTENER_UNIX, "/run/e2b/gateway.sock"},
+			},
+			false,
+		},
+		{"tcp://[::1]:8080,", []ListenerSpec{{LISTENER_ROLE_ALL, LISTENER_TCP, "[::1]:8080"}}, false},
+		{"", nil, true},
+		{" , ", nil, true},
+		{":8080", nil, true},
+		{"tcp://", nil, true},
+		{"tcp://8080", nil, true},
+		{"udp://:53", nil, true},
+		{"internal=tcp://:8080", nil, true},
+		{"tcp://:8080,http://:9090", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			specs, err := parseListenerSpecs(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("错误 = %v，期望出错 %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(specs, tt.want) {
+				t.Errorf("parseListenerSpecs = %v，期望 %v", specs, tt.want)
+			}
+		})
+	}
+}
+
+func TestRoleHandler(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	paths := []string{"/admin", "/admin/state", "/administrator", "/v1/models", "/v1/chat/completions", "/v1/fragments", "/v1/templates", "/livez", "/health", "/"}
+	tests := []struct {
+		role    string
+		allowed []string
+	}{
+		{LISTENER_ROLE_ALL, paths},
+		{LISTENER_ROLE_PUBLIC, []string{"/administrator", "/v1/models", "/v1/chat/completions", "/v1/fragments", "/v1/templates", "/livez", "/health", "/"}},
+		{LISTENER_ROLE_ADMIN, []string{"/admin", "/admin/state", "/v1/models", "/v1/chat/completions", "/livez", "/health"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.role, func(t *testing.T) {
+			handler := roleHandler(tt.role, next)
+			allowed := make(map[string]bool)
+			for _, path := range tt.allowed {
+				allowed[path] = true
+			}
+			for _, path := range paths {
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+				want := http.StatusNotFound
+				if allowed[path] {
+					want = http.StatusTeapot
+				}
+				if w.Code != want {
+					t.Errorf("%s 状态码 = %d，期望 %d", path, w.Code, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseFileMode(t *testing.T) {
+	if mode, err := parseFileMode("0660"); err != nil || mode != 0660 {
+		t.Errorf("parseFileMode(0660) = %o, %v", mode, err)
+	}
+	for _, value := range []string{"", "999", "01000", "rw"} {
+		if _, err := parseFileMode(value); err == nil {
+			t.Errorf("parseFileMode(%q) 应出错", value)
+		}
+	}
+}
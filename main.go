@@ -2,24 +2,33 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"math"
 	"math/rand"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
+	"github.com/yourusername/e2b-api-gateway/apierror"
 )
 
 // 环境变量名称常量
 const (
-	ENV_PORT    = "E2B_PORT"
-	ENV_API_KEY = "E2B_API_KEY"
+	ENV_PORT     = "E2B_PORT"
+	ENV_API_KEY  = "E2B_API_KEY"
+	ENV_BASE_URL = "E2B_BASE_URL"
+	ENV_ERROR_LANGUAGE = "E2B_ERROR_LANGUAGE" // 错误信息的默认语言，请求未通过 Accept-Language 指定时使用
 )
 
 // CONFIG 配置常量声明
@@ -28,11 +37,107 @@ var CONFIG struct {
 		BASE_URL string
 		API_KEY  string
 	}
+	ADMIN struct {
+		KEY string
+	}
+	KEYS struct {
+		FILE           string
+		ROTATION_GRACE time.Duration
+	}
 	RETRY struct {
 		MAX_ATTEMPTS int
 		DELAY_BASE   int
+		MAX_DELAY    time.Duration
+		TIMEOUT      time.Duration
+	}
+	ID struct {
+		UUID_VERSION     string
+		USER_ID_STRATEGY string
+		USER_ID          string
+		USER_ID_SALT     string
+	}
+	UPSTREAM struct {
+		MODE           string
+		CASSETTE_FILE  string
+		CASSETTE_MATCH []string
+	}
+	CACHE struct {
+		BACKEND     string
+		TTL         time.Duration
+		MAX_ENTRIES int
+		MAX_BYTES   int64
+		DIR         string
+	}
+	LIMITS struct {
+		KEY_CONCURRENCY    int
+		KEY_QUEUE_TIMEOUT  time.Duration
+		MAX_N              int
+		FANOUT_CONCURRENCY int
+		MAX_BODY_BYTES     int64
+		MAX_MESSAGES       int
+		MAX_MESSAGE_CHARS  int
+		MAX_IMAGES         int
+		MAX_IMAGE_BYTES    int64
+	}
+	ROUTING struct {
+		VIRTUAL_MODELS map[string]VirtualModel
+		TIMEZONE       string
+		LOCATION       *time.Location
+	}
+	PARAMS struct {
+		MODE string
+	}
+	SCHEMA struct {
+		MODE string
+	}
+	ERRORS struct {
+		LANGUAGE string
+	}
+	SERVER struct {
+		LISTENERS        []ListenerSpec
+		H2C              bool
+		UNIX_SOCKET_MODE os.FileMode
+		TLS              struct {
+			CERT_FILE       string
+			KEY_FILE        string
+			RELOAD_INTERVAL time.Duration
+		}
+	}
+	SHUTDOWN struct {
+		GRACE           time.Duration
+		READINESS_DELAY time.Duration
+	}
+	BREAKER struct {
+		THRESHOLD int
+		COOLDOWN  time.Duration
+	}
+	PROBE struct {
+		INTERVAL time.Duration
+		TIMEOUT  time.Duration
+		MODEL    string
+	}
+	CONTEXT struct {
+		STRATEGY        string
+		OUTPUT_RESERVE  int
+		SUMMARY_MAX_LEN int
+	}
+	MESSAGES struct {
+		SYSTEM_STRATEGY string
+		SEPARATOR       string
 	}
+	PROMPTS struct {
+		PRESETS map[string]*PromptPreset
+		DEFAULT string
+	}
+	EXTRACTION struct {
+		DEFAULT   string
+		SEPARATOR string
+	}
+	TEMPLATES        map[string]FragmentTemplate
+	DEFAULT_TEMPLATE string
 	MODEL_CONFIG    map[string]ModelConfig
+	MODEL_ALIASES   map[string]string
+	MODEL_FALLBACKS map[string][]string
 	DEFAULT_HEADERS map[string]string
 	MODEL_PROMPT    string
 }
@@ -56,6 +161,62 @@ func getEnv(key, defaultValue string) string {
 	return value
 }
 
+// getEnvInt 获取整数类型的环境变量，解析失败时返回默认值
+func getEnvInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("警告: 环境变量 %s=%q 不是有效的整数，将使用默认值 %d", key, value, defaultValue)
+		return defaultValue
+	}
+	return n
+}
+
+// getEnvDuration 获取时长类型的环境变量（如 30s、10m），解析失败时返回默认值
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("警告: 环境变量 %s=%q 不是有效的时长，将使用默认值 %s", key, value, defaultValue)
+		return defaultValue
+	}
+	return d
+}
+
+// getEnvFloat 获取浮点类型的环境变量，解析失败时返回默认值
+func getEnvFloat(key string, defaultValue float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Printf("警告: 环境变量 %s=%q 不是有效的数字，将使用默认值 %g", key, value, defaultValue)
+		return defaultValue
+	}
+	return f
+}
+
+// getEnvBool 获取布尔类型的环境变量，解析失败时返回默认值
+func getEnvBool(key string, defaultValue bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("警告: 环境变量 %s=%q 不是有效的布尔值，将使用默认值 %t", key, value, defaultValue)
+		return defaultValue
+	}
+	return b
+}
+
 // 初始化函数，打印当前配置信息
 func init() {
 	// 先设置日志格式
@@ -68,11 +229,73 @@ func init() {
 	rand.Seed(time.Now().UnixNano())
 	
 	// 然后初始化配置
-	CONFIG.API.BASE_URL = "https://fragments.e2b.dev" // 固定值，不从环境变量或.env文件获取
+	// 上游地址默认固定为官方地址，可通过环境变量或.env文件中的 E2B_BASE_URL 覆盖，用于对接 mock-upstream 等本地上游
+	CONFIG.API.BASE_URL = strings.TrimRight(getEnv(ENV_BASE_URL, "https://fragments.e2b.dev"), "/")
 	CONFIG.API.API_KEY = getEnv(ENV_API_KEY, "sk-123456") // 可通过环境变量覆盖
 	
-	CONFIG.RETRY.MAX_ATTEMPTS = 1
-	CONFIG.RETRY.DELAY_BASE = 1000
+	CONFIG.ADMIN.KEY = getEnv(ENV_ADMIN_KEY, "")
+	CONFIG.KEYS.FILE = getEnv(ENV_KEYS_FILE, "")
+	CONFIG.KEYS.ROTATION_GRACE = getEnvDuration(ENV_KEY_ROTATION_GRACE, 0)
+	initKeyStore()
+	
+	// 上游失败按错误分类重试，限流、不可用、超时和无效响应才会重试
+	CONFIG.RETRY.MAX_ATTEMPTS = getEnvInt(ENV_RETRY_MAX_ATTEMPTS, 2)
+	CONFIG.RETRY.DELAY_BASE = getEnvInt(ENV_RETRY_DELAY_BASE, 1000)
+	CONFIG.RETRY.MAX_DELAY = getEnvDuration(ENV_RETRY_MAX_DELAY, 10*time.Second)
+	CONFIG.RETRY.TIMEOUT = getEnvDuration(ENV_UPSTREAM_TIMEOUT, 5*time.Minute)
+	if CONFIG.RETRY.MAX_ATTEMPTS < 1 {
+		CONFIG.RETRY.MAX_ATTEMPTS = 1
+	}
+	
+	// 上游连续不可用时熔断
+	CONFIG.BREAKER.THRESHOLD = getEnvInt(ENV_BREAKER_THRESHOLD, 5)
+	CONFIG.BREAKER.COOLDOWN = getEnvDuration(ENV_BREAKER_COOLDOWN, 30*time.Second)
+	upstreamBreaker = NewBreaker(CONFIG.BREAKER.THRESHOLD, CONFIG.BREAKER.COOLDOWN)
+	
+	// 就绪检查的合成上游探测
+	CONFIG.PROBE.INTERVAL = getEnvDuration(ENV_PROBE_INTERVAL, 0)
+	CONFIG.PROBE.TIMEOUT = getEnvDuration(ENV_PROBE_TIMEOUT, 10*time.Second)
+	CONFIG.PROBE.MODEL = getEnv(ENV_PROBE_MODEL, "")
+	
+	CONFIG.LIMITS.KEY_CONCURRENCY = getEnvInt(ENV_KEY_CONCURRENCY, 0)
+	CONFIG.LIMITS.KEY_QUEUE_TIMEOUT = getEnvDuration(ENV_KEY_QUEUE_TIMEOUT, 30*time.Second)
+	CONFIG.LIMITS.MAX_N = getEnvInt(ENV_MAX_N, 8)
+	CONFIG.LIMITS.FANOUT_CONCURRENCY = getEnvInt(ENV_FANOUT_CONCURRENCY, 4)
+	CONFIG.LIMITS.MAX_BODY_BYTES = int64(getEnvInt(ENV_MAX_BODY_BYTES, 20<<20))
+	CONFIG.LIMITS.MAX_MESSAGES = getEnvInt(ENV_MAX_MESSAGES, 1000)
+	CONFIG.LIMITS.MAX_MESSAGE_CHARS = getEnvInt(ENV_MAX_MESSAGE_CHARS, 500000)
+	CONFIG.LIMITS.MAX_IMAGES = getEnvInt(ENV_MAX_IMAGES, 20)
+	CONFIG.LIMITS.MAX_IMAGE_BYTES = int64(getEnvInt(ENV_MAX_IMAGE_BYTES, 5<<20))
+	if CONFIG.LIMITS.MAX_BODY_BYTES <= 0 {
+		log.Fatalf("%s 必须大于0", ENV_MAX_BODY_BYTES)
+	}
+	if CONFIG.LIMITS.MAX_N < 1 {
+		CONFIG.LIMITS.MAX_N = 1
+	}
+	if CONFIG.LIMITS.FANOUT_CONCURRENCY < 1 {
+		CONFIG.LIMITS.FANOUT_CONCURRENCY = 1
+	}
+	
+	CONFIG.ID.UUID_VERSION = getEnv(ENV_UUID_VERSION, "v4")
+	CONFIG.ID.USER_ID_STRATEGY = getEnv(ENV_USER_ID_STRATEGY, USER_ID_RANDOM)
+	CONFIG.ID.USER_ID = getEnv(ENV_USER_ID, "")
+	CONFIG.ID.USER_ID_SALT = getEnv(ENV_USER_ID_SALT, "e2b-gateway")
+	if !validUserIDStrategy(CONFIG.ID.USER_ID_STRATEGY) {
+		log.Printf("警告: 未知的userID策略 %q，将使用 %s", CONFIG.ID.USER_ID_STRATEGY, USER_ID_RANDOM)
+		CONFIG.ID.USER_ID_STRATEGY = USER_ID_RANDOM
+	}
+	
+	CONFIG.CACHE.BACKEND = getEnv(ENV_CACHE_BACKEND, "")
+	CONFIG.CACHE.TTL = getEnvDuration(ENV_CACHE_TTL, 10*time.Minute)
+	CONFIG.CACHE.MAX_ENTRIES = getEnvInt(ENV_CACHE_MAX_ENTRIES, 1000)
+	CONFIG.CACHE.MAX_BYTES = int64(getEnvInt(ENV_CACHE_MAX_BYTES, 64<<20))
+	CONFIG.CACHE.DIR = getEnv(ENV_CACHE_DIR, filepath.Join(os.TempDir(), "e2b-gateway-cache"))
+	initResponseCache()
+	
+	CONFIG.UPSTREAM.MODE = getEnv(ENV_UPSTREAM_MODE, UPSTREAM_MODE_LIVE)
+	CONFIG.UPSTREAM.CASSETTE_FILE = getEnv(ENV_CASSETTE_FILE, "cassette.jsonl")
+	CONFIG.UPSTREAM.CASSETTE_MATCH = parseCassetteMatch(getEnv(ENV_CASSETTE_MATCH, "model,messages"))
+	initUpstreamClient()
 	
 	CONFIG.DEFAULT_HEADERS = map[string]string{
 		"accept":           "*/*",
@@ -95,12 +318,15 @@ func init() {
 	log.Printf("服务配置信息:")
 	log.Printf("API_KEY: %s", maskString(CONFIG.API.API_KEY, 8))
 	log.Printf("BASE_URL: %s", CONFIG.API.BASE_URL)
-	log.Printf("端口: %s", getEnv(ENV_PORT, "8080"))
+	log.Printf("监听: %s", getEnv(ENV_LISTENERS, "tcp://:"+getEnv(ENV_PORT, "8080")))
+	log.Printf("管理接口: %s", map[bool]string{true: "已启用", false: "未启用"}[CONFIG.ADMIN.KEY != ""])
+	log.Printf("userID策略: %s, UUID版本: %s", CONFIG.ID.USER_ID_STRATEGY, CONFIG.ID.UUID_VERSION)
 	
 	// 初始化模型配置
 	CONFIG.MODEL_CONFIG = map[string]ModelConfig{
 		"o1-preview": {
 			ID:          "o1",
+			ContextWindow: 128000,
 			Provider:    "OpenAI",
 			ProviderID:  "openai",
 			Name:        "o1",
@@ -117,6 +343,7 @@ func init() {
 		},
 		"claude-3-opus-20240229": {
 			ID:          "claude-3-opus-20240229",
+			ContextWindow: 200000,
 			Provider:    "Anthropic",
 			ProviderID:  "anthropic",
 			Name:        "claude-3-opus-20240229",
@@ -133,6 +360,7 @@ func init() {
 		},
 		"claude-3-5-sonnet-20240620": {
 			ID:          "claude-3-5-sonnet-20240620",
+			ContextWindow: 200000,
 			Provider:    "Anthropic",
 			ProviderID:  "anthropic",
 			Name:        "claude-3-5-sonnet-20240620",
@@ -149,6 +377,7 @@ func init() {
 		},
 		"claude-3-haiku-20240307": {
 			ID:          "claude-3-haiku-20240307",
+			ContextWindow: 200000,
 			Provider:    "Anthropic",
 			ProviderID:  "anthropic",
 			Name:        "claude-3-haiku-20240307",
@@ -165,6 +394,7 @@ func init() {
 		},
 		"claude-3-sonnet-20240229": {
 			ID:          "claude-3-sonnet-20240229",
+			ContextWindow: 200000,
 			Provider:    "Anthropic",
 			ProviderID:  "anthropic",
 			Name:        "claude-3-sonnet-20240229",
@@ -180,6 +410,121 @@ func init() {
 			},
 		},
 	}
+	
+	// 模型别名和备用模型链，可通过环境变量覆盖或追加
+	CONFIG.MODEL_ALIASES = map[string]string{
+		"gpt-4o":        "claude-3-5-sonnet-20240620",
+		"latest-sonnet": "claude-3-5-sonnet-20240620",
+		"latest-opus":   "claude-3-opus-20240229",
+		"latest-haiku":  "claude-3-haiku-20240307",
+	}
+	CONFIG.MODEL_FALLBACKS = map[string][]string{
+		"claude-3-5-sonnet-20240620": {"claude-3-sonnet-20240229", "claude-3-haiku-20240307"},
+		"claude-3-opus-20240229":     {"claude-3-5-sonnet-20240620"},
+	}
+	for alias, target := range parseModelAliases(getEnv(ENV_MODEL_ALIASES, "")) {
+		CONFIG.MODEL_ALIASES[alias] = target
+	}
+	for model, fallbacks := range parseModelFallbacks(getEnv(ENV_MODEL_FALLBACKS, "")) {
+		CONFIG.MODEL_FALLBACKS[model] = fallbacks
+	}
+	
+	// 响应内容提取策略
+	CONFIG.EXTRACTION.DEFAULT = getEnv(ENV_EXTRACTION, EXTRACTION_AUTO)
+	if !validExtraction(CONFIG.EXTRACTION.DEFAULT) {
+		log.Fatalf("无效的提取策略: %s", CONFIG.EXTRACTION.DEFAULT)
+	}
+	CONFIG.EXTRACTION.SEPARATOR = getEnv(ENV_EXTRACTION_SEPARATOR, "\n\n")
+	applyModelExtraction(getEnv(ENV_MODEL_EXTRACTION, ""))
+	
+	// 上下文窗口管理
+	CONFIG.CONTEXT.STRATEGY = getEnv(ENV_CONTEXT_STRATEGY, CONTEXT_STRATEGY_TRUNCATE)
+	if !validContextStrategy(CONFIG.CONTEXT.STRATEGY) {
+		log.Fatalf("无效的上下文策略: %s", CONFIG.CONTEXT.STRATEGY)
+	}
+	CONFIG.CONTEXT.OUTPUT_RESERVE = getEnvInt(ENV_CONTEXT_OUTPUT_RESERVE, 4096)
+	CONFIG.CONTEXT.SUMMARY_MAX_LEN = getEnvInt(ENV_CONTEXT_SUMMARY_MAX_LEN, 4000)
+	applyModelContextConfig(getEnv(ENV_MODEL_CONTEXT_WINDOWS, ""), getEnv(ENV_MODEL_CONTEXT_STRATEGY, ""))
+	
+	// 监听地址和TLS
+	listeners, err := parseListenerSpecs(getEnv(ENV_LISTENERS, "tcp://:"+getEnv(ENV_PORT, "8080")))
+	if err != nil {
+		log.Fatalf("无效的 %s: %v", ENV_LISTENERS, err)
+	}
+	CONFIG.SERVER.LISTENERS = listeners
+	CONFIG.SERVER.H2C = getEnvBool(ENV_H2C, false)
+	if CONFIG.SERVER.UNIX_SOCKET_MODE, err = parseFileMode(getEnv(ENV_UNIX_SOCKET_MODE, "0660")); err != nil {
+		log.Fatalf("无效的 %s: %v", ENV_UNIX_SOCKET_MODE, err)
+	}
+	CONFIG.SERVER.TLS.CERT_FILE = getEnv(ENV_TLS_CERT_FILE, "")
+	CONFIG.SERVER.TLS.KEY_FILE = getEnv(ENV_TLS_KEY_FILE, "")
+	CONFIG.SERVER.TLS.RELOAD_INTERVAL = getEnvDuration(ENV_TLS_RELOAD_INTERVAL, 30*time.Second)
+	if err := setupTLS(CONFIG.SERVER.TLS.CERT_FILE, CONFIG.SERVER.TLS.KEY_FILE, CONFIG.SERVER.TLS.RELOAD_INTERVAL); err != nil {
+		log.Fatalf("%v", err)
+	}
+	
+	// 优雅退出
+	CONFIG.SHUTDOWN.GRACE = getEnvDuration(ENV_SHUTDOWN_GRACE, 30*time.Second)
+	CONFIG.SHUTDOWN.READINESS_DELAY = getEnvDuration(ENV_SHUTDOWN_READINESS_DELAY, 0)
+	
+	// 错误信息的默认语言
+	CONFIG.ERRORS.LANGUAGE = getEnv(ENV_ERROR_LANGUAGE, apierror.LangZH)
+	if !apierror.SetDefaultLanguage(CONFIG.ERRORS.LANGUAGE) {
+		log.Fatalf("无效的错误信息语言: %s，可选: %s, %s", CONFIG.ERRORS.LANGUAGE, apierror.LangZH, apierror.LangEN)
+	}
+	
+	// 不支持的 OpenAI 请求字段的处理方式
+	CONFIG.SCHEMA.MODE = getEnv(ENV_SCHEMA_MODE, SCHEMA_MODE_LENIENT)
+	if !validSchemaMode(CONFIG.SCHEMA.MODE) {
+		log.Fatalf("无效的 schema 模式: %s", CONFIG.SCHEMA.MODE)
+	}
+	
+	// 采样参数范围和超出范围时的处理方式
+	CONFIG.PARAMS.MODE = getEnv(ENV_PARAM_MODE, PARAM_MODE_CLAMP)
+	if !validParamMode(CONFIG.PARAMS.MODE) {
+		log.Fatalf("无效的参数处理方式: %s", CONFIG.PARAMS.MODE)
+	}
+	applyModelParamConfig(getEnv(ENV_MODEL_PARAM_LIMITS, ""), getEnv(ENV_MODEL_PARAM_MODE, ""))
+	
+	// 系统消息处理策略和消息分隔符
+	CONFIG.MESSAGES.SYSTEM_STRATEGY = getEnv(ENV_SYSTEM_STRATEGY, SYSTEM_STRATEGY_INSTRUCTIONS)
+	if !validSystemStrategy(CONFIG.MESSAGES.SYSTEM_STRATEGY) {
+		log.Fatalf("无效的系统消息策略: %s", CONFIG.MESSAGES.SYSTEM_STRATEGY)
+	}
+	CONFIG.MESSAGES.SEPARATOR = getEnv(ENV_MESSAGE_SEPARATOR, "\n\n---\n\n")
+	applyModelSystemStrategy(getEnv(ENV_MODEL_SYSTEM_STRATEGY, ""))
+	
+	// 提示词预设
+	CONFIG.PROMPTS.PRESETS = loadPromptPresets()
+	CONFIG.PROMPTS.DEFAULT = getEnv(ENV_DEFAULT_PROMPT_PRESET, "")
+	if CONFIG.PROMPTS.DEFAULT != "" && !validPromptPreset(CONFIG.PROMPTS.DEFAULT) {
+		log.Fatalf("默认提示词预设不存在: %s", CONFIG.PROMPTS.DEFAULT)
+	}
+	applyModelPromptPresets(getEnv(ENV_MODEL_PROMPT_PRESETS, ""))
+	
+	// fragments 模板
+	CONFIG.TEMPLATES = loadTemplates()
+	CONFIG.DEFAULT_TEMPLATE = getEnv(ENV_DEFAULT_TEMPLATE, TEMPLATE_TEXT)
+	if !validTemplate(CONFIG.DEFAULT_TEMPLATE) {
+		log.Fatalf("默认模板不存在: %s", CONFIG.DEFAULT_TEMPLATE)
+	}
+	log.Printf("模板: %s, 默认模板: %s", strings.Join(templateIDs(), ", "), CONFIG.DEFAULT_TEMPLATE)
+	
+	// 虚拟模型路由规则，依赖上面的模型和别名配置
+	CONFIG.ROUTING.TIMEZONE = getEnv(ENV_ROUTING_TIMEZONE, "Local")
+	location, err := time.LoadLocation(CONFIG.ROUTING.TIMEZONE)
+	if err != nil {
+		log.Fatalf("无效的路由时区 %s: %v", CONFIG.ROUTING.TIMEZONE, err)
+	}
+	CONFIG.ROUTING.LOCATION = location
+	CONFIG.ROUTING.VIRTUAL_MODELS = loadVirtualModels()
+	if errs := validateVirtualModels(CONFIG.ROUTING.VIRTUAL_MODELS); len(errs) > 0 {
+		for _, err := range errs {
+			log.Printf("路由规则无效: %v", err)
+		}
+		log.Fatalf("路由规则中有 %d 处错误，请修正后重新启动", len(errs))
+	}
+	log.Printf("虚拟模型: %s", strings.Join(virtualModelNames(), ", "))
 }
 
 // OptMax 模型最大参数配置
@@ -201,6 +546,14 @@ type ModelConfig struct {
 	MultiModal  bool    `json:"multiModal"`
 	SystemPrompt string  `json:"Systemprompt"`
 	OptMax      OptMax  `json:"opt_max"`
+	Extraction  string  `json:"extraction,omitempty"`    // 响应内容提取策略，为空时使用全局默认
+	PromptPreset string `json:"prompt_preset,omitempty"` // 默认提示词预设
+	SystemStrategy string `json:"system_strategy,omitempty"` // 系统消息处理策略，为空时使用全局默认
+	ContextWindow int `json:"context_window,omitempty"` // 上下文窗口token数，0表示不检查
+	ContextStrategy string `json:"context_strategy,omitempty"` // 超出上下文窗口时的策略，为空时使用全局默认
+	SupportsStop bool `json:"supports_stop,omitempty"` // 上游是否支持 stop 参数，不支持时只在网关侧截断
+	ParamLimits map[string]ParamRange `json:"param_limits,omitempty"` // 覆盖由 OptMax 推导的参数范围
+	ParamMode string `json:"param_mode,omitempty"` // 参数超出范围时的处理方式，为空时使用全局默认
 }
 
 // ChatMessage 聊天消息
@@ -219,14 +572,43 @@ type TextContent struct {
 type ChatRequest struct {
 	Model       string        `json:"model"`
 	Messages    []ChatMessage `json:"messages"`
-	Temperature float64       `json:"temperature,omitempty"`
-	MaxTokens   int           `json:"max_tokens,omitempty"`
+	Temperature *float64      `json:"temperature,omitempty"`
+	MaxTokens   *int          `json:"max_tokens,omitempty"`
 	Stream      bool          `json:"stream,omitempty"`
-	// 其他可选参数
-	PresencePenalty  float64 `json:"presence_penalty,omitempty"`
-	FrequencyPenalty float64 `json:"frequency_penalty,omitempty"`
-	TopP             float64 `json:"top_p,omitempty"`
-	TopK             int     `json:"top_k,omitempty"`
+	Tools       []interface{} `json:"tools,omitempty"`
+	User        string        `json:"user,omitempty"`
+	Stop        interface{}   `json:"stop,omitempty"` // 字符串或字符串数组
+	N           int           `json:"n,omitempty"`    // 生成的候选数量，每个候选单独调用上游
+	MaxCompletionTokens *int  `json:"max_completion_tokens,omitempty"` // 等同于 max_tokens
+	StreamOptions *StreamOptions `json:"stream_options,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"` // 只记录到日志
+	PromptCacheKey   string `json:"prompt_cache_key,omitempty"`
+	SafetyIdentifier string `json:"safety_identifier,omitempty"`
+	// 其他可选参数，使用指针区分显式的0和未设置
+	PresencePenalty  *float64 `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float64 `json:"frequency_penalty,omitempty"`
+	TopP             *float64 `json:"top_p,omitempty"`
+	TopK             *int     `json:"top_k,omitempty"`
+	// 网关扩展字段
+	E2B *E2BExtension `json:"e2b,omitempty"`
+}
+
+// RequestedMaxTokens 返回请求的 max_tokens，未设置时为0
+func (r ChatRequest) RequestedMaxTokens() int {
+	if r.MaxTokens == nil {
+		return 0
+	}
+	return *r.MaxTokens
+}
+
+// E2BExtension 请求中的网关扩展字段
+type E2BExtension struct {
+	Template     string  `json:"template,omitempty"`
+	Extraction   string  `json:"extraction,omitempty"`
+	Separator    *string `json:"separator,omitempty"`
+	PromptPreset string  `json:"prompt_preset,omitempty"`
+	ParamMode    string  `json:"param_mode,omitempty"`
+	SchemaMode   string  `json:"schema_mode,omitempty"`
 }
 
 // E2BRequest E2B请求
@@ -246,10 +628,34 @@ type E2BRequest struct {
 
 // E2BResponse E2B响应
 type E2BResponse struct {
-	Code string `json:"code,omitempty"`
-	Text string `json:"text,omitempty"`
+	Commentary                 string   `json:"commentary,omitempty"`
+	Template                   string   `json:"template,omitempty"`
+	Title                      string   `json:"title,omitempty"`
+	Description                string   `json:"description,omitempty"`
+	AdditionalDependencies     []string `json:"additional_dependencies,omitempty"`
+	HasAdditionalDependencies  bool     `json:"has_additional_dependencies,omitempty"`
+	InstallDependenciesCommand string   `json:"install_dependencies_command,omitempty"`
+	Port                       *int     `json:"port,omitempty"`
+	FilePath                   string   `json:"file_path,omitempty"`
+	Code                       string   `json:"code,omitempty"`
+	Text                       string   `json:"text,omitempty"`
 }
 
+// ModelObject 模型列表中的模型，别名通过 root 指向实际模型
+type ModelObject struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+	Root    string `json:"root,omitempty"`
+}
+
+// finish_reason 取值
+const (
+	FINISH_REASON_STOP   = "stop"   // 输出完整结束或命中停止序列
+	FINISH_REASON_LENGTH = "length" // 输出达到 max_tokens 被截断
+)
+
 // ChatChoice 响应选择
 type ChatChoice struct {
 	Index        int         `json:"index"`
@@ -258,6 +664,23 @@ type ChatChoice struct {
 	FinishReason string      `json:"finish_reason"`
 }
 
+// ChunkChoice 流式分块中的选择
+type ChunkChoice struct {
+	Index        int         `json:"index"`
+	Delta        interface{} `json:"delta"`
+	FinishReason *string     `json:"finish_reason"`
+}
+
+// ChatCompletionChunk 流式响应分块
+type ChatCompletionChunk struct {
+	ID      string        `json:"id"`
+	Object  string        `json:"object"`
+	Created int64         `json:"created"`
+	Model   string        `json:"model"`
+	Choices []ChunkChoice `json:"choices"`
+	Usage   *Usage        `json:"usage,omitempty"` // 只在 stream_options.include_usage 时的最后一个分块中出现
+}
+
 // ChatCompletionResponse 聊天完成响应
 type ChatCompletionResponse struct {
 	ID      string       `json:"id"`
@@ -265,10 +688,16 @@ type ChatCompletionResponse struct {
 	Created int64        `json:"created"`
 	Model   string       `json:"model"`
 	Choices []ChatChoice `json:"choices"`
-	Usage   interface{}  `json:"usage"`
+	Usage   *Usage       `json:"usage"`
 }
 
 func main() {
+	// 子命令: 启动本地模拟上游
+	if len(os.Args) > 1 && os.Args[1] == "mock-upstream" {
+		runMockUpstream(os.Args[2:])
+		return
+	}
+	
 	// 设置 Gin 为发布模式
 	if os.Getenv("GIN_MODE") == "" {
 		gin.SetMode(gin.ReleaseMode)
@@ -286,15 +715,17 @@ func main() {
 	
 	// 注册路由
 	r.GET("/v1/models", handleModelsRequestGin)
-	r.POST("/v1/chat/completions", handleChatRequestGin)
+	r.GET("/v1/templates", handleTemplatesRequestGin)
+	// 补全请求统计进行中的数量，优雅退出时等待它们完成
+	r.POST("/v1/chat/completions", inFlightMiddleware(), metricsMiddleware(), handleChatRequestGin)
+	r.POST("/v1/fragments", inFlightMiddleware(), metricsMiddleware(), handleFragmentsRequestGin)
+	registerAdminRoutes(r)
+	registerDashboardRoutes(r)
 	
-	// 添加健康检查端点
-	r.GET("/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{
-			"status": "ok",
-			"version": "1.0.0",
-		})
-	})
+	// 健康检查端点：/livez 只反映进程存活，/readyz 和 /health 还会检查上游和配置
+	r.GET("/livez", handleLivez)
+	r.GET("/readyz", handleReadyz)
+	r.GET("/health", handleReadyz)
 	
 	// 处理404
 	r.NoRoute(func(c *gin.Context) {
@@ -303,10 +734,21 @@ func main() {
 		c.String(http.StatusNotFound, "服务运行成功，请使用正确请求路径")
 	})
 	
-	// 从环境变量获取端口，如果未设置则默认为8080
-	port := getEnv(ENV_PORT, "8080")
-	log.Printf("服务启动在 http://localhost:%s", port)
-	r.Run(":" + port)
+	log.Printf("版本: %s, 提交: %s, 构建时间: %s", version, commit, buildTime)
+	if CONFIG.PROBE.MODEL != "" {
+		if _, ok := lookupModel(CONFIG.PROBE.MODEL); !ok {
+			log.Fatalf("探测模型不存在: %s", CONFIG.PROBE.MODEL)
+		}
+	}
+	prober.start(CONFIG.PROBE.INTERVAL)
+	
+	// 按 E2B_LISTENERS 打开所有监听地址，未设置时只监听 E2B_PORT
+	listeners, err := openListeners(CONFIG.SERVER.LISTENERS, r)
+	if err != nil {
+		log.Fatalf("服务启动失败: %v", err)
+	}
+	logListeners(listeners)
+	serveUntilSignal(listeners)
 }
 
 // CORS 中间件
@@ -332,144 +774,394 @@ func handleModelsRequestGin(c *gin.Context) {
 	requestID := GenerateUUID()
 	logInfo(requestID, "获取模型列表")
 	
+	models := enabledModelNames()
+	aliases := enabledAliases()
 	modelsResponse := struct {
-		Object string `json:"object"`
-		Data   []struct {
-			ID       string `json:"id"`
-			Object   string `json:"object"`
-			Created  int64  `json:"created"`
-			OwnedBy  string `json:"owned_by"`
-		} `json:"data"`
+		Object string        `json:"object"`
+		Data   []ModelObject `json:"data"`
 	}{
 		Object: "list",
-		Data:   make([]struct {
-			ID       string `json:"id"`
-			Object   string `json:"object"`
-			Created  int64  `json:"created"`
-			OwnedBy  string `json:"owned_by"`
-		}, 0, len(CONFIG.MODEL_CONFIG)),
+		Data:   make([]ModelObject, 0, len(models)+len(aliases)),
 	}
 	
 	now := time.Now().Unix()
-	for model := range CONFIG.MODEL_CONFIG {
-		modelsResponse.Data = append(modelsResponse.Data, struct {
-			ID       string `json:"id"`
-			Object   string `json:"object"`
-			Created  int64  `json:"created"`
-			OwnedBy  string `json:"owned_by"`
-		}{
-			ID:       model,
-			Object:   "model",
-			Created:  now,
-			OwnedBy:  "e2b",
+	for _, model := range models {
+		modelsResponse.Data = append(modelsResponse.Data, ModelObject{
+			ID:      model,
+			Object:  "model",
+			Created: now,
+			OwnedBy: "e2b",
+		})
+	}
+	for _, alias := range aliases {
+		modelsResponse.Data = append(modelsResponse.Data, ModelObject{
+			ID:      alias.Name,
+			Object:  "model",
+			Created: now,
+			OwnedBy: "e2b",
+			Root:    alias.Target,
+		})
+	}
+	virtualModels := virtualModelNames()
+	for _, name := range virtualModels {
+		modelsResponse.Data = append(modelsResponse.Data, ModelObject{
+			ID:      name,
+			Object:  "model",
+			Created: now,
+			OwnedBy: "e2b-gateway",
 		})
 	}
 	
 	c.JSON(http.StatusOK, modelsResponse)
-	logInfo(requestID, fmt.Sprintf("模型列表返回成功，模型数量: %d", len(CONFIG.MODEL_CONFIG)))
+	logInfo(requestID, fmt.Sprintf("模型列表返回成功，模型数量: %d, 别名数量: %d, 虚拟模型数量: %d", len(models), len(aliases), len(virtualModels)))
 }
 
 // 使用 Gin 处理聊天请求
 func handleChatRequestGin(c *gin.Context) {
 	requestID := GenerateUUID()
+	c.Set(CTX_REQUEST_ID, requestID)
 	logInfo(requestID, "处理聊天完成请求")
 	
+	chatRequest, results, ok := executeCompletion(c, requestID, CONFIG.DEFAULT_TEMPLATE, true)
+	if !ok {
+		return
+	}
+	
+	// 按提取策略生成每个候选的响应内容，流式和普通响应使用相同的内容
+	contents := make([]string, len(results))
+	for i, result := range results {
+		extraction := resolveExtraction(chatRequest.E2B, result.ModelConfig)
+		contents[i] = ExtractContent(result.Response, extraction)
+		if i == 0 {
+			c.Header("x-gateway-extraction", extraction.Mode)
+		}
+	}
+	
+	// 停止序列和 max_tokens 在网关侧执行，流式响应由 stopFilter 和 tokenBudget 逐块处理
+	stops, _ := parseStopSequences(chatRequest.Stop)
+	
+	// 根据请求类型返回流式或普通响应，model 字段为第一个候选实际使用的模型
+	// usage 按网关的token估算规则计算，提示词为调用方发送的原始消息
+	model := results[0].Model
+	promptTokens := EstimateMessagesTokens(chatRequest.Messages)
+	if chatRequest.Stream {
+		handleStreamResponseGin(c, contents, model, requestID, streamSettings{
+			Stops:        stops,
+			MaxTokens:    chatRequest.RequestedMaxTokens(),
+			IncludeUsage: chatRequest.StreamOptions != nil && chatRequest.StreamOptions.IncludeUsage,
+			PromptTokens: promptTokens,
+		})
+	} else {
+		choices := make([]completionChoice, len(contents))
+		completionTokens := 0
+		for i, content := range contents {
+			choices[i] = finishChoice(requestID, i, content, stops, chatRequest.RequestedMaxTokens())
+			completionTokens += EstimateTokens(choices[i].Content)
+		}
+		handleNormalResponseGin(c, choices, model, requestID, newUsage(promptTokens, completionTokens))
+	}
+}
+
+// completionChoice 一个候选的最终内容和结束原因
+type completionChoice struct {
+	Content      string
+	FinishReason string
+}
+
+// finishChoice 先在第一个停止序列处截断，再按 max_tokens 截断输出。
+// 只有停止序列之前的内容仍超出 max_tokens 时 finish_reason 才为 length
+func finishChoice(requestID string, index int, content string, stops []string, maxTokens int) completionChoice {
+	choice := completionChoice{Content: content, FinishReason: FINISH_REASON_STOP}
+	if truncated, matched := applyStopSequences(choice.Content, stops); matched {
+		logInfo(requestID, fmt.Sprintf("候选 %d 命中停止序列，内容从 %d 字节截断为 %d 字节", index, len(choice.Content), len(truncated)))
+		choice.Content = truncated
+	}
+	if truncated, exceeded := TruncateToTokens(choice.Content, maxTokens); exceeded {
+		logInfo(requestID, fmt.Sprintf("候选 %d 超出 max_tokens=%d，内容从 %d 字节截断为 %d 字节", index, maxTokens, len(choice.Content), len(truncated)))
+		choice.Content = truncated
+		choice.FinishReason = FINISH_REASON_LENGTH
+	}
+	return choice
+}
+
+// executeCompletion 完成认证、请求解析、模板选择、路由和上游调用，失败时已写入错误响应。
+// allowChoices 为 false 的接口只接受 n=1，返回的结果按候选顺序排列
+func executeCompletion(c *gin.Context, requestID string, defaultTemplate string, allowChoices bool) (*ChatRequest, []*upstreamResult, bool) {
 	// 验证认证
 	authHeader := c.GetHeader("Authorization")
 	authToken := strings.TrimPrefix(authHeader, "Bearer ")
-	if authToken != CONFIG.API.API_KEY {
+	apiKey, ok := keyStore.Authenticate(authToken)
+	if !ok {
 		logError(requestID, fmt.Sprintf("认证失败，提供的令牌: %s...", maskString(authToken, 8)), nil)
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"error": "Unauthorized",
-		})
-		return
+		writeAPIError(c, apierror.InvalidAPIKey())
+		return nil, nil, false
 	}
+	c.Set(CTX_KEY_ID, apiKey.ID)
 	
-	// 解析请求体
+	// 解析请求体，读取时限制大小
 	var chatRequest ChatRequest
-	if err := c.BindJSON(&chatRequest); err != nil {
+	body, err := readRequestBody(c)
+	if err != nil {
+		logError(requestID, "读取请求体失败", err)
+		writeAPIError(c, err)
+		return nil, nil, false
+	}
+	if err := json.Unmarshal(body, &chatRequest); err != nil {
 		logError(requestID, "解析请求体失败", err)
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": gin.H{
-				"message": "无法解析请求体: " + err.Error(),
-				"type":    "invalid_request_error",
-				"param":   nil,
-				"code":    nil,
-			},
-		})
-		return
+		writeAPIError(c, apierror.InvalidRequest("", apierror.MsgInvalidBody, err.Error()))
+		return nil, nil, false
+	}
+	
+	// 按 OpenAI 请求字段表检查不支持的字段，并转换模拟支持的字段
+	schemaMode := resolveSchemaMode(chatRequest.E2B)
+	if !validSchemaMode(schemaMode) {
+		err = apierror.InvalidValue("e2b.schema_mode", schemaMode, strings.Join(schemaModes, ", "))
+	}
+	var dropped []string
+	if err == nil {
+		dropped, err = checkRequestFields(body, schemaMode)
+	}
+	if err == nil {
+		err = applyEmulatedFields(&chatRequest)
+	}
+	if err != nil {
+		logError(requestID, "请求字段无效", err)
+		writeAPIError(c, err)
+		return nil, nil, false
+	}
+	if len(dropped) > 0 {
+		logInfo(requestID, "已丢弃不支持的请求字段: "+strings.Join(dropped, ", "))
+		c.Header("x-gateway-dropped-params", strings.Join(dropped, ", "))
+	}
+	
+	// 检查消息数量、长度和图片
+	if err := validateMessages(chatRequest.Messages); err != nil {
+		logError(requestID, "消息校验失败", err)
+		writeAPIError(c, err)
+		return nil, nil, false
 	}
 	
 	// 记录请求信息
 	logInfo(requestID, "用户请求体", map[string]interface{}{
-		"model":          chatRequest.Model,
-		"messages_count": len(chatRequest.Messages),
-		"stream":         chatRequest.Stream,
-		"temperature":    chatRequest.Temperature,
-		"max_tokens":     chatRequest.MaxTokens,
+		"key_id":            apiKey.ID,
+		"model":             chatRequest.Model,
+		"messages_count":    len(chatRequest.Messages),
+		"stream":            chatRequest.Stream,
+		"temperature":       chatRequest.Temperature,
+		"max_tokens":        chatRequest.MaxTokens,
+		"metadata":          chatRequest.Metadata,
+		"prompt_cache_key":  chatRequest.PromptCacheKey,
+		"safety_identifier": chatRequest.SafetyIdentifier,
 	})
 	
-	// 检查模型是否支持
-	modelConfig, ok := CONFIG.MODEL_CONFIG[chatRequest.Model]
-	if !ok {
-		logError(requestID, "不支持的模型: "+chatRequest.Model, nil)
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": gin.H{
-				"message": "不支持的模型: " + chatRequest.Model,
-				"type":    "invalid_request_error",
-				"param":   "model",
-				"code":    nil,
-			},
-		})
-		return
+	// 选择模板，模型名可以带 "@模板" 后缀
+	requestedModel, templateSuffix := splitModelTemplate(chatRequest.Model)
+	templateID, err := selectTemplate(templateSuffix, chatRequest.E2B, defaultTemplate)
+	if err != nil {
+		logError(requestID, "模板选择失败", err)
+		writeAPIError(c, err)
+		return nil, nil, false
+	}
+	c.Header("x-gateway-template", templateID)
+	if err := validateExtraction(chatRequest.E2B); err != nil {
+		logError(requestID, "提取策略无效", err)
+		writeAPIError(c, err)
+		return nil, nil, false
+	}
+	stops, err := parseStopSequences(chatRequest.Stop)
+	if err != nil {
+		logError(requestID, "stop 参数无效", err)
+		writeAPIError(c, err)
+		return nil, nil, false
+	}
+	if err := validateParamMode(chatRequest.E2B); err != nil {
+		logError(requestID, "参数处理方式无效", err)
+		writeAPIError(c, err)
+		return nil, nil, false
+	}
+	requestedPreset, err := requestedPromptPreset(c.GetHeader(HEADER_PROMPT_PRESET), chatRequest.E2B)
+	if err != nil {
+		logError(requestID, "提示词预设无效", err)
+		writeAPIError(c, err)
+		return nil, nil, false
 	}
 	
-	// 配置选项
-	params := map[string]interface{}{
-		"temperature":       chatRequest.Temperature,
-		"max_tokens":        chatRequest.MaxTokens,
-		"presence_penalty":  chatRequest.PresencePenalty,
-		"frequency_penalty": chatRequest.FrequencyPenalty,
-		"top_p":             chatRequest.TopP,
-		"top_k":             chatRequest.TopK,
+	n, err := resolveChoiceCount(chatRequest.N, allowChoices)
+	if err != nil {
+		logError(requestID, "n 参数无效", err)
+		writeAPIError(c, err)
+		return nil, nil, false
+	}
+	
+	// 模型解析成功前按 unknown 统计，避免任意模型名使指标无限增长
+	c.Set(CTX_MODEL, METRICS_MODEL_UNKNOWN)
+	
+	// 虚拟模型按路由规则选择实际模型
+	if isVirtualModel(requestedModel) {
+		facts := collectRouteFacts(chatRequest, apiKey)
+		decision := routeVirtualModel(requestedModel, facts)
+		logInfo(requestID, fmt.Sprintf("路由: %s -> %s (规则: %s)", requestedModel, decision.Model, decision.Rule), map[string]interface{}{
+			"prompt_tokens": facts.PromptTokens,
+			"has_images":    facts.HasImages,
+			"has_tools":     facts.HasTools,
+			"tier":          facts.Tier,
+			"hour":          facts.Hour,
+		})
+		c.Header("x-gateway-routed-model", decision.Model)
+		c.Header("x-gateway-route-rule", decision.Rule)
+		requestedModel = decision.Model
 	}
-	configOpt := ConfigOpt(params, modelConfig)
 	
-	// 准备E2B请求
-	e2bRequest, err := PrepareChatRequest(modelConfig, requestID, chatRequest, configOpt)
+	// 解析模型别名和备用模型链
+	chain, err := resolveModelChain(requestedModel)
 	if err != nil {
-		logError(requestID, "准备聊天请求失败", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": gin.H{
-				"message": "准备请求失败: " + err.Error(),
-				"type":    "server_error",
-				"param":   nil,
-				"code":    nil,
-			},
+		// 虚拟模型路由到的模型可能已被禁用，错误中给出实际路由到的模型名
+		logError(requestID, "不支持的模型: "+requestedModel, err)
+		writeAPIError(c, apierror.ModelNotFound(requestedModel))
+		return nil, nil, false
+	}
+	c.Set(CTX_MODEL, chatRequest.Model)
+	if len(chain) > 1 || chain[0] != requestedModel {
+		logInfo(requestID, fmt.Sprintf("模型解析: %s -> %s", requestedModel, strings.Join(chain, " -> ")))
+	}
+	
+	userID := ResolveUserID(apiKey.ID)
+	
+	// 准备E2B请求，每个候选模型分别按自身的参数上限和上下文窗口约束。
+	// 多个候选并发调用时同一模型的请求只构造一次，避免重复生成对话摘要；
+	// buildMu 只保护 built，构造（可能包含摘要调用）在锁外进行，不同模型互不阻塞
+	type builtEntry struct {
+		once     sync.Once
+		prepared preparedRequest
+		err      error
+	}
+	var buildMu sync.Mutex
+	built := make(map[string]*builtEntry)
+	prepare := func(ctx context.Context, modelName string, modelConfig ModelConfig) (preparedRequest, error) {
+		messages, fitted, err := fitContextWindow(ctx, requestID, modelName, modelConfig, chatRequest.Messages, chatRequest.RequestedMaxTokens(), userID)
+		if err != nil {
+			return preparedRequest{}, err
+		}
+		if fitted.Applied() {
+			logInfo(requestID, fmt.Sprintf("上下文超出模型 %s 的预算，已处理: %s", modelName, fitted.Header()))
+		}
+		fittedRequest := chatRequest
+		fittedRequest.Messages = messages
+		
+		configOpt, adjustments, err := ConfigOpt(fittedRequest, modelName, modelConfig, resolveParamMode(chatRequest.E2B, modelConfig))
+		if err != nil {
+			return preparedRequest{}, err
+		}
+		if len(adjustments) > 0 {
+			logInfo(requestID, fmt.Sprintf("模型 %s 的参数已调整: %s", modelName, formatParamAdjustments(adjustments)))
+		}
+		if modelConfig.SupportsStop && len(stops) > 0 {
+			if configOpt == nil {
+				configOpt = make(map[string]interface{})
+			}
+			configOpt["stop"] = stops
+		}
+		e2bRequest, err := PrepareChatRequest(modelConfig, requestID, fittedRequest, configOpt, PrepareOptions{
+			UserID:       userID,
+			TemplateID:   templateID,
+			PromptPreset: resolvePromptPreset(requestedPreset, apiKey, modelConfig),
+			PromptVars:   newPromptVars(fittedRequest, apiKey, modelName, modelConfig),
 		})
-		return
+		if err != nil {
+			return preparedRequest{}, err
+		}
+		logInfo(requestID, "发送到E2B的请求", map[string]interface{}{
+			"model":          e2bRequest.Model.Name,
+			"template":       templateID,
+			"messages_count": len(e2bRequest.Messages),
+			"config":         e2bRequest.Config,
+		})
+		return preparedRequest{Request: e2bRequest, Context: fitted, Adjustments: adjustments}, nil
+	}
+	build := func(ctx context.Context, modelName string, modelConfig ModelConfig) (preparedRequest, error) {
+		buildMu.Lock()
+		entry, ok := built[modelName]
+		if !ok {
+			entry = &builtEntry{}
+			built[modelName] = entry
+		}
+		buildMu.Unlock()
+		entry.once.Do(func() {
+			entry.prepared, entry.err = prepare(ctx, modelName, modelConfig)
+		})
+		return entry.prepared, entry.err
 	}
 	
-	logInfo(requestID, "发送到E2B的请求", map[string]interface{}{
-		"model":          e2bRequest.Model.Name,
-		"messages_count": len(e2bRequest.Messages),
-		"config":         e2bRequest.Config,
-	})
+	// 每次上游调用占用密钥的一个并发名额，n>1 时每个候选分别占用
+	acquire := func() (func(), error) {
+		return keyLimiter.Acquire(c.Request.Context(), apiKey.ID, keyConcurrencyLimit(apiKey), CONFIG.LIMITS.KEY_QUEUE_TIMEOUT)
+	}
 	
-	// 发送请求到E2B
+	// 根据 Cache-Control 头决定是否读写缓存
+	cacheRead, cacheWrite := cacheDirectives(c.GetHeader("Cache-Control"))
+	results, err := completeChoices(c.Request.Context(), requestID, n, chain, build, cacheRead, cacheWrite, acquire)
+	if err != nil {
+		logError(requestID, "所有候选模型均调用失败", err)
+		var failure *upstreamError
+		if errors.As(err, &failure) && failure.RetryAfter > 0 {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(failure.RetryAfter.Seconds()))))
+		}
+		writeAPIError(c, err)
+		return nil, nil, false
+	}
+	result := results[0]
+	c.Set(CTX_MODEL, result.Model)
+	c.Header("x-cache", result.CacheStatus)
+	c.Header("x-gateway-model", result.Model)
+	c.Header("x-gateway-attempts", strconv.Itoa(result.Attempts))
+	if n > 1 {
+		c.Header("x-gateway-choices", strconv.Itoa(n))
+	}
+	if result.Context.Applied() {
+		c.Header("x-gateway-context", result.Context.Header())
+	}
+	if len(result.Adjustments) > 0 {
+		c.Header("x-gateway-adjusted-params", formatParamAdjustments(result.Adjustments))
+	}
+	return &chatRequest, results, true
+}
+
+// resolveChoiceCount 校验请求的 n，未指定时为1
+func resolveChoiceCount(n int, allowChoices bool) (int, error) {
+	if n == 0 {
+		return 1, nil
+	}
+	if n < 0 {
+		return 0, apierror.InvalidRequest("n", apierror.MsgNTooSmall)
+	}
+	if n > 1 && !allowChoices {
+		return 0, apierror.InvalidRequest("n", apierror.MsgNNotSupported)
+	}
+	if n > CONFIG.LIMITS.MAX_N {
+		return 0, apierror.InvalidRequest("n", apierror.MsgNTooLarge, CONFIG.LIMITS.MAX_N, n)
+	}
+	return n, nil
+}
+
+// callE2B 发送请求到E2B并解析响应
+func callE2B(ctx context.Context, requestID string, e2bRequest E2BRequest) (*E2BResponse, error) {
 	requestData, err := json.Marshal(e2bRequest)
 	if err != nil {
 		logError(requestID, "请求序列化失败", err)
-		handleInternalErrorGin(c, requestID, "请求序列化失败: "+err.Error())
-		return
+		return nil, fmt.Errorf("请求序列化失败: %w", err)
 	}
 	
-	client := &http.Client{}
-	req, err := http.NewRequest("POST", CONFIG.API.BASE_URL+"/api/chat", bytes.NewBuffer(requestData))
+	// 单次调用的超时，超时按 timeout 分类，可以重试
+	parent := ctx
+	if CONFIG.RETRY.TIMEOUT > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, CONFIG.RETRY.TIMEOUT)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", CONFIG.API.BASE_URL+"/api/chat", bytes.NewBuffer(requestData))
 	if err != nil {
 		logError(requestID, "创建HTTP请求失败", err)
-		handleInternalErrorGin(c, requestID, "创建HTTP请求失败: "+err.Error())
-		return
+		return nil, fmt.Errorf("创建HTTP请求失败: %w", err)
 	}
 	
 	// 设置请求头
@@ -477,25 +1169,53 @@ func handleChatRequestGin(c *gin.Context) {
 		req.Header.Set(key, value)
 	}
 	
+	// 熔断中直接失败，不再请求上游
+	if ok, wait := upstreamBreaker.Allow(); !ok {
+		return nil, upstreamCircuitOpen(wait)
+	}
+	
 	// 发送请求并记录时间
 	fetchStartTime := time.Now()
-	resp, err := client.Do(req)
+	resp, err := upstreamClient.Do(req)
 	fetchEndTime := time.Now()
 	
 	if err != nil {
+		if errors.Is(parent.Err(), context.Canceled) {
+			// 调用方已断开，与上游是否可用无关
+			upstreamBreaker.Release()
+			return nil, fmt.Errorf("调用方已取消请求: %w", parent.Err())
+		}
 		logError(requestID, "请求E2B失败", err)
-		handleInternalErrorGin(c, requestID, "请求上游服务失败: "+err.Error())
-		return
+		failure := upstreamTransportError(err)
+		recordBreakerResult(requestID, failure)
+		return nil, failure
 	}
 	defer resp.Body.Close()
 	
-	// 解析E2B响应
+	// 非2xx响应按状态码和响应体中的错误信息分类，不再当作正常响应解析
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, errorBodyCaptureMax))
+		failure := classifyUpstreamResponse(resp.StatusCode, resp.Header, body)
+		logError(requestID, fmt.Sprintf("E2B返回错误状态码: %d, 耗时: %dms", resp.StatusCode, fetchEndTime.Sub(fetchStartTime).Milliseconds()), failure)
+		recordBreakerResult(requestID, failure)
+		return nil, failure
+	}
+	
+	// 解析E2B响应，2xx但无法解析或没有内容的响应同样记为上游失败
 	var e2bResponse E2BResponse
 	if err := json.NewDecoder(resp.Body).Decode(&e2bResponse); err != nil {
 		logError(requestID, "解析E2B响应失败", err)
-		handleInternalErrorGin(c, requestID, "解析上游服务响应失败: "+err.Error())
-		return
+		failure := upstreamInvalidResponse(apierror.MsgUpstreamInvalidResponse, err)
+		recordBreakerResult(requestID, failure)
+		return nil, failure
+	}
+	if isEmptyE2BResponse(&e2bResponse) {
+		failure := upstreamInvalidResponse(apierror.MsgUpstreamEmpty, nil)
+		logError(requestID, "E2B返回了空响应", failure)
+		recordBreakerResult(requestID, failure)
+		return nil, failure
 	}
+	recordBreakerResult(requestID, nil)
 	
 	logInfo(requestID, fmt.Sprintf("收到E2B的响应: %d, 耗时: %dms", resp.StatusCode, fetchEndTime.Sub(fetchStartTime).Milliseconds()), map[string]interface{}{
 		"status":           resp.StatusCode,
@@ -504,67 +1224,94 @@ func handleChatRequestGin(c *gin.Context) {
 		"response_preview": truncateString(e2bResponse.Code+e2bResponse.Text, 100),
 	})
 	
-	// 提取响应内容
-	chatMessage := strings.TrimSpace(e2bResponse.Code)
-	if chatMessage == "" {
-		chatMessage = strings.TrimSpace(e2bResponse.Text)
-	}
-	
-	if chatMessage == "" {
-		logError(requestID, "E2B没有返回有效响应", nil)
-		handleInternalErrorGin(c, requestID, "未从上游服务获取到响应")
-		return
-	}
-	
-	// 根据请求类型返回流式或普通响应
-	if chatRequest.Stream {
-		handleStreamResponseGin(c, chatMessage, chatRequest.Model, requestID)
-	} else {
-		handleNormalResponseGin(c, chatMessage, chatRequest.Model, requestID)
-	}
+	return &e2bResponse, nil
 }
 
-// 使用 Gin 处理内部错误
-func handleInternalErrorGin(c *gin.Context, requestID, message string) {
-	c.JSON(http.StatusInternalServerError, gin.H{
-		"error": gin.H{
-			"message": message + " 请求失败，可能是上下文超出限制或其他错误，请稍后重试。",
-			"type":    "server_error",
-			"param":   nil,
-			"code":    nil,
-		},
-	})
+// writeAPIError 按 OpenAI 错误格式写入响应，信息语言由 Accept-Language 决定。
+// 不是 apierror 的错误按内部错误处理，原始信息只记录在日志中
+func writeAPIError(c *gin.Context, err error) {
+	apiErr := apierror.From(err)
+	c.JSON(apiErr.Status, apiErr.Body(apierror.NegotiateLanguage(c.GetHeader("Accept-Language"))))
 }
 
-// 使用 Gin 处理普通响应
-func handleNormalResponseGin(c *gin.Context, chatMessage string, model string, requestID string) {
-	logInfo(requestID, fmt.Sprintf("处理普通响应，内容长度: %d 字符", len(chatMessage)))
+// 使用 Gin 处理普通响应，每个候选对应一个 choice
+func handleNormalResponseGin(c *gin.Context, outputs []completionChoice, model string, requestID string, usage *Usage) {
+	choices := make([]ChatChoice, len(outputs))
+	totalLen := 0
+	for i, output := range outputs {
+		choices[i] = ChatChoice{
+			Index: i,
+			Message: ChatMessage{
+				Role:    "assistant",
+				Content: output.Content,
+			},
+			FinishReason: output.FinishReason,
+		}
+		totalLen += len(output.Content)
+	}
+	logInfo(requestID, fmt.Sprintf("处理普通响应，候选数: %d, 内容长度: %d 字符", len(outputs), totalLen))
 	
 	response := ChatCompletionResponse{
-		ID:      GenerateUUID(),
+		ID:      GenerateCompletionID(),
 		Object:  "chat.completion",
 		Created: time.Now().Unix(),
 		Model:   model,
-		Choices: []ChatChoice{
-			{
-				Index: 0,
-				Message: ChatMessage{
-					Role:    "assistant",
-					Content: chatMessage,
-				},
-				FinishReason: "stop",
-			},
-		},
-		Usage: nil,
+		Choices: choices,
+		Usage:   usage,
 	}
 	
 	c.JSON(http.StatusOK, response)
 	logInfo(requestID, "返回普通响应成功")
 }
 
-// 使用 Gin 处理流式响应
-func handleStreamResponseGin(c *gin.Context, chatMessage string, model string, requestID string) {
-	logInfo(requestID, fmt.Sprintf("处理流式响应，内容长度: %d 字符", len(chatMessage)))
+// choiceStream 流式响应中单个候选的分块、停止序列过滤和输出token预算状态
+type choiceStream struct {
+	chunks       []string
+	next         int
+	filter       *stopFilter
+	budget       *tokenBudget
+	done         bool
+	finishReason string
+}
+
+// nextChunk 返回下一个要发送的分块，last 表示该候选已结束；没有可发送内容时 ok 为 false。
+// 经过停止序列过滤后的内容才计入 max_tokens，与普通响应先停止序列后长度的顺序一致
+func (s *choiceStream) nextChunk() (chunk string, last bool, ok bool) {
+	for !s.done {
+		chunk = s.filter.Write(s.chunks[s.next])
+		s.next++
+		last = s.next == len(s.chunks) || s.filter.Stopped()
+		if last {
+			chunk += s.filter.Flush()
+		}
+		if kept, exceeded := s.budget.Take(chunk); exceeded {
+			s.done = true
+			s.finishReason = FINISH_REASON_LENGTH
+			return kept, true, true
+		}
+		if last {
+			s.done = true
+			s.finishReason = FINISH_REASON_STOP
+			return chunk, true, true
+		}
+		if chunk != "" {
+			return chunk, false, true
+		}
+	}
+	return "", false, false
+}
+
+// streamSettings 流式响应在网关侧执行的选项
+type streamSettings struct {
+	Stops        []string
+	MaxTokens    int
+	IncludeUsage bool // stream_options.include_usage，在 [DONE] 之前发送只含 usage 的分块
+	PromptTokens int
+}
+
+// 使用 Gin 处理流式响应。多个候选按轮次交错发送，每个分块只包含一个候选并带有它的 index
+func handleStreamResponseGin(c *gin.Context, contents []string, model string, requestID string, settings streamSettings) {
+	logInfo(requestID, fmt.Sprintf("处理流式响应，候选数: %d", len(contents)))
 	
 	// 设置响应头
 	c.Writer.Header().Set("Content-Type", "text/event-stream")
@@ -572,68 +1319,100 @@ func handleStreamResponseGin(c *gin.Context, chatMessage string, model string, r
 	c.Writer.Header().Set("Connection", "keep-alive")
 	c.Writer.WriteHeader(http.StatusOK)
 	
-	// 分段发送响应
-	index := 0
-	for index < len(chatMessage) {
-		// 计算随机块大小
-		chunkSize := rand.Intn(15) + 15 // 15到29之间
-		if index+chunkSize > len(chatMessage) {
-			chunkSize = len(chatMessage) - index
+	// 优雅退出时等待流式响应发送完毕
+	defer serverLifecycle.StreamStarted()()
+	
+	// 同一次流式响应的所有分块共用一个ID
+	completionID := GenerateCompletionID()
+	
+	// 分段发送响应，分块不会切断多字节字符，每个候选独立过滤停止序列
+	streams := make([]*choiceStream, len(contents))
+	for i, content := range contents {
+		chunks := splitStreamChunks(content)
+		if len(chunks) == 0 {
+			chunks = []string{""}
 		}
-		
-		chunk := chatMessage[index:index+chunkSize]
-		index += chunkSize
-		
-		// 创建事件数据
-		eventData := struct {
-			ID      string `json:"id"`
-			Object  string `json:"object"`
-			Created int64  `json:"created"`
-			Model   string `json:"model"`
-			Choices []struct {
-				Index        int         `json:"index"`
-				Delta        interface{} `json:"delta"`
-				FinishReason *string     `json:"finish_reason"`
-			} `json:"choices"`
-		}{
-			ID:      GenerateUUID(),
-			Object:  "chat.completion.chunk",
-			Created: time.Now().Unix(),
-			Model:   model,
-			Choices: []struct {
-				Index        int         `json:"index"`
-				Delta        interface{} `json:"delta"`
-				FinishReason *string     `json:"finish_reason"`
-			}{
-				{
-					Index: 0,
-					Delta: map[string]string{
-						"content": chunk,
+		streams[i] = &choiceStream{chunks: chunks, filter: newStopFilter(settings.Stops), budget: &tokenBudget{limit: settings.MaxTokens}}
+	}
+	
+	for active := len(streams); active > 0; {
+		for index, stream := range streams {
+			chunk, last, ok := stream.nextChunk()
+			if !ok {
+				continue
+			}
+			
+			// 创建事件数据
+			eventData := ChatCompletionChunk{
+				ID:      completionID,
+				Object:  "chat.completion.chunk",
+				Created: time.Now().Unix(),
+				Model:   model,
+				Choices: []ChunkChoice{
+					{
+						Index: index,
+						Delta: map[string]string{
+							"content": chunk,
+						},
+						FinishReason: nil,
 					},
-					FinishReason: nil,
 				},
-			},
+			}
+			
+			// 如果是该候选的最后一个分块，设置finish_reason
+			if last {
+				finishReason := stream.finishReason
+				eventData.Choices[0].FinishReason = &finishReason
+				if finishReason == FINISH_REASON_LENGTH {
+					logInfo(requestID, fmt.Sprintf("候选 %d 的流式响应达到 max_tokens=%d", index, settings.MaxTokens))
+				} else if stream.filter.Stopped() {
+					logInfo(requestID, fmt.Sprintf("候选 %d 的流式响应命中停止序列", index))
+				}
+				active--
+			}
+			
+			// 序列化事件数据
+			eventJSON, err := json.Marshal(eventData)
+			if err != nil {
+				logError(requestID, "序列化事件数据失败", err)
+				return
+			}
+			
+			// 写入事件流
+			fmt.Fprintf(c.Writer, "data: %s\n\n", eventJSON)
+			c.Writer.Flush()
 		}
 		
-		// 如果是最后一个分块，设置finish_reason
-		if index >= len(chatMessage) {
-			finishReason := "stop"
-			eventData.Choices[0].FinishReason = &finishReason
+		// 添加小延迟模拟真实速度，调用方断开后不再继续发送
+		if active > 0 {
+			select {
+			case <-time.After(50 * time.Millisecond):
+			case <-c.Request.Context().Done():
+				logInfo(requestID, "调用方已断开，停止流式响应")
+				return
+			}
 		}
-		
-		// 序列化事件数据
-		eventJSON, err := json.Marshal(eventData)
+	}
+	
+	// 按 OpenAI 的约定，用量在单独的分块中发送，choices 为空数组
+	if settings.IncludeUsage {
+		completionTokens := 0
+		for _, stream := range streams {
+			completionTokens += stream.budget.Used()
+		}
+		usageJSON, err := json.Marshal(ChatCompletionChunk{
+			ID:      completionID,
+			Object:  "chat.completion.chunk",
+			Created: time.Now().Unix(),
+			Model:   model,
+			Choices: []ChunkChoice{},
+			Usage:   newUsage(settings.PromptTokens, completionTokens),
+		})
 		if err != nil {
-			logError(requestID, "序列化事件数据失败", err)
+			logError(requestID, "序列化用量数据失败", err)
 			return
 		}
-		
-		// 写入事件流
-		fmt.Fprintf(c.Writer, "data: %s\n\n", eventJSON)
-		c.Writer.Flush()
-		
-		// 添加小延迟模拟真实速度
-		time.Sleep(50 * time.Millisecond)
+		fmt.Fprintf(c.Writer, "data: %s\n\n", usageJSON)
 	}
 	
 	// 发送结束标记
@@ -669,74 +1448,6 @@ func logError(requestID string, message string, err error) {
 	log.Printf("[%s][%s] ERROR: %s - %v", timestamp, requestID, message, err)
 }
 
-// GenerateUUID 生成UUID
-func GenerateUUID() string {
-	b := make([]byte, 16)
-	_, err := rand.Read(b)
-	if err != nil {
-		return fmt.Sprintf("%d", time.Now().UnixNano())
-	}
-	
-	// 设置版本和变体位
-	b[6] = (b[6] & 0x0f) | 0x40 // Version 4
-	b[8] = (b[8] & 0x3f) | 0x80 // Variant 1
-	
-	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
-}
-
-// ConfigOpt 配置选项
-func ConfigOpt(params map[string]interface{}, modelConfig ModelConfig) map[string]interface{} {
-	if modelConfig.OptMax == (OptMax{}) {
-		return nil
-	}
-	
-	optionsMap := map[string]string{
-		"temperature":       "TemperatureMax",
-		"max_tokens":        "MaxTokensMax",
-		"presence_penalty":  "PresencePenaltyMax",
-		"frequency_penalty": "FrequencyPenaltyMax",
-		"top_p":             "TopPMax",
-		"top_k":             "TopKMax",
-	}
-	
-	constrainedParams := make(map[string]interface{})
-	for key, value := range params {
-		maxKey, ok := optionsMap[key]
-		if !ok || value == nil {
-			continue
-		}
-		
-		switch maxKey {
-		case "TemperatureMax":
-			if temp, ok := value.(float64); ok && modelConfig.OptMax.TemperatureMax > 0 {
-				constrainedParams[key] = math.Min(temp, modelConfig.OptMax.TemperatureMax)
-			}
-		case "MaxTokensMax":
-			if tokens, ok := value.(int); ok && modelConfig.OptMax.MaxTokensMax > 0 {
-				constrainedParams[key] = int(math.Min(float64(tokens), float64(modelConfig.OptMax.MaxTokensMax)))
-			}
-		case "PresencePenaltyMax":
-			if penalty, ok := value.(float64); ok && modelConfig.OptMax.PresencePenaltyMax > 0 {
-				constrainedParams[key] = math.Min(penalty, modelConfig.OptMax.PresencePenaltyMax)
-			}
-		case "FrequencyPenaltyMax":
-			if penalty, ok := value.(float64); ok && modelConfig.OptMax.FrequencyPenaltyMax > 0 {
-				constrainedParams[key] = math.Min(penalty, modelConfig.OptMax.FrequencyPenaltyMax)
-			}
-		case "TopPMax":
-			if topP, ok := value.(float64); ok && modelConfig.OptMax.TopPMax > 0 {
-				constrainedParams[key] = math.Min(topP, modelConfig.OptMax.TopPMax)
-			}
-		case "TopKMax":
-			if topK, ok := value.(int); ok && modelConfig.OptMax.TopKMax > 0 {
-				constrainedParams[key] = int(math.Min(float64(topK), float64(modelConfig.OptMax.TopKMax)))
-			}
-		}
-	}
-	
-	return constrainedParams
-}
-
 // ProcessMessageContent 处理消息内容
 func ProcessMessageContent(content interface{}) string {
 	switch v := content.(type) {
@@ -763,14 +1474,17 @@ func ProcessMessageContent(content interface{}) string {
 	return ""
 }
 
-// TransformMessages 转换消息
-func TransformMessages(messages []ChatMessage) []ChatMessage {
+// TransformMessages 转换消息，strategy 决定系统消息的处理方式，separator 用于保留合并后的消息边界。
+// instructions 策略下系统消息不进入消息列表，而是通过第二个返回值交给模板的 instructions
+func TransformMessages(messages []ChatMessage, strategy, separator string) ([]ChatMessage, string) {
 	if len(messages) == 0 {
-		return messages
+		return messages, ""
 	}
 	
+	var systemParts []string
 	var mergedMessages []ChatMessage
 	var lastMessage *ChatMessage
+	lastMergeable := false
 	
 	for _, current := range messages {
 		currentContent := ProcessMessageContent(current.Content)
@@ -778,10 +1492,24 @@ func TransformMessages(messages []ChatMessage) []ChatMessage {
 			continue
 		}
 		
-		if lastMessage != nil && lastMessage.Role == current.Role {
+		mergeable := true
+		if current.Role == "system" {
+			switch strategy {
+			case SYSTEM_STRATEGY_INSTRUCTIONS:
+				systemParts = append(systemParts, currentContent)
+				continue
+			case SYSTEM_STRATEGY_SEPARATE:
+				current = ChatMessage{Role: "user", Content: systemMessageLabel + "\n" + currentContent}
+				mergeable = false
+			default:
+				current = ChatMessage{Role: "user", Content: currentContent}
+			}
+		}
+		
+		if lastMessage != nil && mergeable && lastMergeable && lastMessage.Role == current.Role {
 			lastContent := ProcessMessageContent(lastMessage.Content)
 			if lastContent != "" {
-				lastMessage.Content = lastContent + "\n" + currentContent
+				lastMessage.Content = lastContent + separator + currentContent
 				continue
 			}
 		}
@@ -789,6 +1517,7 @@ func TransformMessages(messages []ChatMessage) []ChatMessage {
 		messageCopy := current
 		mergedMessages = append(mergedMessages, messageCopy)
 		lastMessage = &mergedMessages[len(mergedMessages)-1]
+		lastMergeable = mergeable
 	}
 	
 	// 转换为E2B要求的格式
@@ -796,19 +1525,9 @@ func TransformMessages(messages []ChatMessage) []ChatMessage {
 	for _, msg := range mergedMessages {
 		content := ProcessMessageContent(msg.Content)
 		switch msg.Role {
-		case "system", "user":
+		case "user", "assistant":
 			transformed = append(transformed, ChatMessage{
-				Role: "user",
-				Content: []TextContent{
-					{
-						Type: "text",
-						Text: content,
-					},
-				},
-			})
-		case "assistant":
-			transformed = append(transformed, ChatMessage{
-				Role: "assistant",
+				Role: msg.Role,
 				Content: []TextContent{
 					{
 						Type: "text",
@@ -821,15 +1540,28 @@ func TransformMessages(messages []ChatMessage) []ChatMessage {
 		}
 	}
 	
-	return transformed
+	return transformed, strings.Join(systemParts, separator)
+}
+
+// PrepareOptions 构造E2B请求时的请求级选项
+type PrepareOptions struct {
+	UserID       string
+	TemplateID   string
+	PromptPreset string // 生效的提示词预设，为空时使用模板自身的 instructions
+	PromptVars   PromptVars
 }
 
 // PrepareChatRequest 准备聊天请求
-func PrepareChatRequest(modelConfig ModelConfig, requestID string, request ChatRequest, config map[string]interface{}) (E2BRequest, error) {
-	logInfo(requestID, fmt.Sprintf("准备聊天请求, 模型: %s, 消息数: %d", modelConfig.Name, len(request.Messages)))
+func PrepareChatRequest(modelConfig ModelConfig, requestID string, request ChatRequest, config map[string]interface{}, opts PrepareOptions) (E2BRequest, error) {
+	logInfo(requestID, fmt.Sprintf("准备聊天请求, 模型: %s, 模板: %s, 提示词预设: %s, 消息数: %d", modelConfig.Name, opts.TemplateID, opts.PromptPreset, len(request.Messages)))
 	
-	transformedMessages := TransformMessages(request.Messages)
-	logInfo(requestID, fmt.Sprintf("转换后的消息数量: %d", len(transformedMessages)))
+	systemStrategy := resolveSystemStrategy(modelConfig)
+	transformedMessages, systemText := TransformMessages(request.Messages, systemStrategy, CONFIG.MESSAGES.SEPARATOR)
+	logInfo(requestID, fmt.Sprintf("转换后的消息数量: %d, 系统消息策略: %s", len(transformedMessages), systemStrategy))
+	if len(transformedMessages) == 0 {
+		// instructions 策略下系统消息并入模板说明，没有其他消息时上游会收到空对话
+		return E2BRequest{}, apierror.InvalidRequest("messages", apierror.MsgNoConversationMessages)
+	}
 	
 	if config == nil {
 		config = map[string]interface{}{
@@ -837,18 +1569,26 @@ func PrepareChatRequest(modelConfig ModelConfig, requestID string, request ChatR
 		}
 	}
 	
+	// 模板的 instructions 按提示词预设渲染，instructions 策略下再追加系统消息
+	render := func(templateID, instructions string) (string, error) {
+		if opts.PromptPreset != "" {
+			rendered, err := renderPromptPreset(opts.PromptPreset, opts.PromptVars, templateID, instructions)
+			if err != nil {
+				return "", err
+			}
+			instructions = rendered
+		}
+		return appendInstructions(instructions, systemText, CONFIG.MESSAGES.SEPARATOR), nil
+	}
+	templatePayload, err := buildTemplatePayload(opts.TemplateID, modelConfig, render)
+	if err != nil {
+		return E2BRequest{}, err
+	}
+	
 	e2bRequest := E2BRequest{
-		UserID:   GenerateUUID(),
+		UserID:   opts.UserID,
 		Messages: transformedMessages,
-		Template: map[string]interface{}{
-			"text": map[string]interface{}{
-				"name":         CONFIG.MODEL_PROMPT,
-				"lib":          []string{""},
-				"file":         "pages/ChatWithUsers.txt",
-				"instructions": modelConfig.SystemPrompt,
-				"port":         nil,
-			},
-		},
+		Template: templatePayload,
 		Model: struct {
 			ID         string `json:"id"`
 			Provider   string `json:"provider"`
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/yourusername/e2b-api-gateway/apierror"
+)
+
+// textMessages 把转换结果还原为 角色:文本 便于比较
+func textMessages(messages []ChatMessage) []string {
+	var out []string
+	for _, msg := range messages {
+		text := ""
+		if parts, ok := msg.Content.([]TextContent); ok {
+			for _, part := range parts {
+				text += part.Text
+			}
+		} else {
+			text = ProcessMessageContent(msg.Content)
+		}
+		out = append(out, msg.Role+":"+text)
+	}
+	return out
+}
+
+func TestTransformMessages(t *testing.T) {
+	messages := []ChatMessage{
+		{Role: "system", Content: "be brief"},
+		{Role: "user", Content: "hi"},
+		{Role: "user", Content: []interface{}{map[string]interface{}{"type": "text", "text": "there"}}},
+		{Role: "assistant", Content: "hello"},
+		{Role: "system", Content: "now formal"},
+		{Role: "user", Content: "ok"},
+		{Role: "user", Content: ""},
+	}
+	tests := []struct {
+		strategy   string
+		wantSystem string
+		want       []string
+	}{
+		{
+			strategy:   SYSTEM_STRATEGY_INSTRUCTIONS,
+			wantSystem: "be brief|now formal",
+			want:       []string{"user:hi|there", "assistant:hello", "user:ok"},
+		},
+		{
+			strategy: SYSTEM_STRATEGY_SEPARATE,
+			want: []string{
+				"user:" + systemMessageLabel + "\nbe brief",
+				"user:hi|there",
+				"assistant:hello",
+				"user:" + systemMessageLabel + "\nnow formal",
+				"user:ok",
+			},
+		},
+		{
+			strategy: SYSTEM_STRATEGY_MERGE,
+			want:     []string{"user:be brief|hi|there", "assistant:hello", "user:now formal|ok"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			transformed, system := TransformMessages(messages, tt.strategy, "|")
+			if system != tt.wantSystem {
+				t.Errorf("系统消息 = %q，期望 %q", system, tt.wantSystem)
+			}
+			if got := textMessages(transformed); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("消息 = %q，期望 %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransformMessagesSystemOnly(t *testing.T) {
+	messages := []ChatMessage{{Role: "system", Content: "be brief"}}
+	transformed, system := TransformMessages(messages, SYSTEM_STRATEGY_INSTRUCTIONS, "\n")
+	if len(transformed) != 0 || system != "be brief" {
+		t.Fatalf("TransformMessages = %v, %q", transformed, system)
+	}
+
+	modelConfig, ok := lookupModel("claude-3-5-sonnet-20240620")
+	if !ok {
+		t.Fatal("测试模型不存在")
+	}
+	modelConfig.SystemStrategy = SYSTEM_STRATEGY_INSTRUCTIONS
+	_, err := PrepareChatRequest(modelConfig, "test", ChatRequest{Model: modelConfig.ID, Messages: messages}, nil, PrepareOptions{TemplateID: TEMPLATE_TEXT})
+	var apiErr *apierror.Error
+	if !errors.As(err, &apiErr) || apiErr.Status != 400 || apiErr.Param != "messages" {
+		t.Fatalf("只有系统消息时应返回400，实际: %v", err)
+	}
+
+	modelConfig.SystemStrategy = SYSTEM_STRATEGY_MERGE
+	if _, err := PrepareChatRequest(modelConfig, "test", ChatRequest{Model: modelConfig.ID, Messages: messages}, nil, PrepareOptions{TemplateID: TEMPLATE_TEXT}); err != nil {
+		t.Fatalf("merge 策略下系统消息会转为 user 消息，不应出错: %v", err)
+	}
+}
+
+func TestResolveSystemStrategy(t *testing.T) {
+	saved := CONFIG.MESSAGES.SYSTEM_STRATEGY
+	CONFIG.MESSAGES.SYSTEM_STRATEGY = SYSTEM_STRATEGY_INSTRUCTIONS
+	t.Cleanup(func() { CONFIG.MESSAGES.SYSTEM_STRATEGY = saved })
+
+	if got := resolveSystemStrategy(ModelConfig{}); got != SYSTEM_STRATEGY_INSTRUCTIONS {
+		t.Errorf("未配置模型策略时应使用全局策略，实际 %s", got)
+	}
+	if got := resolveSystemStrategy(ModelConfig{SystemStrategy: SYSTEM_STRATEGY_MERGE}); got != SYSTEM_STRATEGY_MERGE {
+		t.Errorf("模型策略应优先，实际 %s", got)
+	}
+}
+
+func TestAppendInstructions(t *testing.T) {
+	tests := []struct {
+		instructions, system, want string
+	}{
+		{"base", "", "base"},
+		{" ", "sys", "sys"},
+		{"base", "sys", "base|sys"},
+	}
+	for _, tt := range tests {
+		if got := appendInstructions(tt.instructions, tt.system, "|"); got != tt.want {
+			t.Errorf("appendInstructions(%q, %q) = %q，期望 %q", tt.instructions, tt.system, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// 系统消息处理相关环境变量
+const (
+	ENV_SYSTEM_STRATEGY       = "E2B_SYSTEM_STRATEGY"       // 默认的系统消息处理策略
+	ENV_MODEL_SYSTEM_STRATEGY = "E2B_MODEL_SYSTEM_STRATEGY" // 按模型设置策略，格式: 模型=策略,模型=策略
+	ENV_MESSAGE_SEPARATOR     = "E2B_MESSAGE_SEPARATOR"     // 合并相邻同角色消息时使用的分隔符
+)
+
+// 系统消息处理策略
+const (
+	SYSTEM_STRATEGY_INSTRUCTIONS = "instructions" // 写入模板的 instructions 字段，不出现在消息列表中
+	SYSTEM_STRATEGY_SEPARATE     = "separate"     // 作为带标记的独立 user 消息发送，不与相邻消息合并
+	SYSTEM_STRATEGY_MERGE        = "merge"        // 转为 user 消息并与相邻的 user 消息合并
+)
+
+// systemMessageLabel separate 策略下系统消息的标记行
+const systemMessageLabel = "[System]"
+
+var systemStrategies = []string{SYSTEM_STRATEGY_INSTRUCTIONS, SYSTEM_STRATEGY_SEPARATE, SYSTEM_STRATEGY_MERGE}
+
+// validSystemStrategy 判断系统消息处理策略是否受支持
+func validSystemStrategy(strategy string) bool {
+	return containsString(systemStrategies, strategy)
+}
+
+// applyModelSystemStrategy 把 E2B_MODEL_SYSTEM_STRATEGY 中的策略写入模型配置
+func applyModelSystemStrategy(value string) {
+	for model, strategy := range parseKeyValueList(value, "系统消息策略") {
+		modelConfig, ok := CONFIG.MODEL_CONFIG[model]
+		if !ok {
+			log.Printf("警告: 系统消息策略配置中的模型不存在: %s", model)
+			continue
+		}
+		if !validSystemStrategy(strategy) {
+			log.Fatalf("模型 %s 的系统消息策略无效: %s，可选: %s", model, strategy, strings.Join(systemStrategies, ", "))
+		}
+		modelConfig.SystemStrategy = strategy
+		CONFIG.MODEL_CONFIG[model] = modelConfig
+	}
+}
+
+// resolveSystemStrategy 返回模型生效的系统消息处理策略
+func resolveSystemStrategy(modelConfig ModelConfig) string {
+	if modelConfig.SystemStrategy != "" {
+		return modelConfig.SystemStrategy
+	}
+	return CONFIG.MESSAGES.SYSTEM_STRATEGY
+}
+
+// appendInstructions 把系统消息追加到模板 instructions 之后
+func appendInstructions(instructions, system, separator string) string {
+	if system == "" {
+		return instructions
+	}
+	if strings.TrimSpace(instructions) == "" {
+		return system
+	}
+	return fmt.Sprintf("%s%s%s", instructions, separator, system)
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 在 gin.Context 中传递指标维度使用的键
+const (
+	CTX_REQUEST_ID = "request_id"
+	CTX_MODEL      = "metrics_model"
+	CTX_KEY_ID     = "metrics_key_id"
+)
+
+// METRICS_MODEL_UNKNOWN 请求的模型无法解析时统计使用的模型名
+const METRICS_MODEL_UNKNOWN = "unknown"
+
+const (
+	metricsWindowSeconds = 300 // 请求速率保留最近5分钟的秒级数据
+	latencySampleSize    = 200 // 每个模型保留的延迟样本数
+	recentErrorsSize     = 50  // 保留的最近错误条数
+	errorBodyCaptureMax  = 4096
+)
+
+// Metrics 进程内的请求指标，供管理面板使用
+type Metrics struct {
+	mu           sync.Mutex
+	startedAt    time.Time
+	buckets      [metricsWindowSeconds]rateBucket
+	models       map[string]*modelMetrics
+	keys         map[string]*keyMetrics
+	upstream     map[upstreamErrorKey]*upstreamErrorMetrics
+	recentErrors []RecentError
+}
+
+type rateBucket struct {
+	second   int64
+	requests int64
+	errors   int64
+}
+
+type modelMetrics struct {
+	requests  int64
+	errors    int64
+	totalMs   int64
+	samples   []int64
+	sampleIdx int
+}
+
+type keyMetrics struct {
+	requests int64
+	errors   int64
+	lastUsed time.Time
+}
+
+type upstreamErrorKey struct {
+	model string
+	class string
+}
+
+type upstreamErrorMetrics struct {
+	count   int64
+	retried int64
+}
+
+// RecentError 最近发生的错误
+type RecentError struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id,omitempty"`
+	Path      string    `json:"path"`
+	Model     string    `json:"model,omitempty"`
+	KeyID     string    `json:"key_id,omitempty"`
+	Status    int       `json:"status"`
+	Message   string    `json:"message"`
+}
+
+// RatePoint 每秒请求数
+type RatePoint struct {
+	Time     int64 `json:"time"`
+	Requests int64 `json:"requests"`
+	Errors   int64 `json:"errors"`
+}
+
+// ModelMetricsView 单个模型的请求统计
+type ModelMetricsView struct {
+	Model    string  `json:"model"`
+	Requests int64   `json:"requests"`
+	Errors   int64   `json:"errors"`
+	AvgMs    float64 `json:"avg_ms"`
+	P50Ms    int64   `json:"p50_ms"`
+	P95Ms    int64   `json:"p95_ms"`
+}
+
+// KeyMetricsView 单个API密钥的使用统计
+type KeyMetricsView struct {
+	KeyID    string    `json:"key_id"`
+	Name     string    `json:"name,omitempty"`
+	Requests int64     `json:"requests"`
+	Errors   int64     `json:"errors"`
+	LastUsed time.Time `json:"last_used"`
+}
+
+// UpstreamErrorView 按模型和错误分类统计的上游失败次数，Retried 为其中随后重试的次数
+type UpstreamErrorView struct {
+	Model   string `json:"model"`
+	Class   string `json:"class"`
+	Count   int64  `json:"count"`
+	Retried int64  `json:"retried"`
+}
+
+// MetricsSnapshot 指标快照
+type MetricsSnapshot struct {
+	UptimeSeconds  int64               `json:"uptime_seconds"`
+	RPS10s         float64             `json:"rps_10s"`
+	RPS60s         float64             `json:"rps_60s"`
+	Series         []RatePoint         `json:"series"`
+	Models         []ModelMetricsView  `json:"models"`
+	Keys           []KeyMetricsView    `json:"keys"`
+	UpstreamErrors []UpstreamErrorView `json:"upstream_errors"`
+	RecentErrors   []RecentError       `json:"recent_errors"`
+}
+
+// metrics 全局指标实例
+var metrics = NewMetrics()
+
+// NewMetrics 创建指标收集器
+func NewMetrics() *Metrics {
+	return &Metrics{
+		startedAt: time.Now(),
+		models:    make(map[string]*modelMetrics),
+		keys:      make(map[string]*keyMetrics),
+		upstream:  make(map[upstreamErrorKey]*upstreamErrorMetrics),
+	}
+}
+
+// Record 记录一次请求
+func (m *Metrics) Record(model, keyID string, latency time.Duration, failed bool) {
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	second := now.Unix()
+	bucket := &m.buckets[second%metricsWindowSeconds]
+	if bucket.second != second {
+		*bucket = rateBucket{second: second}
+	}
+	bucket.requests++
+	if failed {
+		bucket.errors++
+	}
+
+	if model != "" {
+		mm, ok := m.models[model]
+		if !ok {
+			mm = &modelMetrics{}
+			m.models[model] = mm
+		}
+		mm.requests++
+		if failed {
+			mm.errors++
+		} else {
+			ms := latency.Milliseconds()
+			mm.totalMs += ms
+			if len(mm.samples) < latencySampleSize {
+				mm.samples = append(mm.samples, ms)
+			} else {
+				mm.samples[mm.sampleIdx] = ms
+				mm.sampleIdx = (mm.sampleIdx + 1) % latencySampleSize
+			}
+		}
+	}
+
+	if keyID != "" {
+		km, ok := m.keys[keyID]
+		if !ok {
+			km = &keyMetrics{}
+			m.keys[keyID] = km
+		}
+		km.requests++
+		if failed {
+			km.errors++
+		}
+		km.lastUsed = now
+	}
+}
+
+// RecordUpstreamError 记录一次失败的上游调用及是否随后重试
+func (m *Metrics) RecordUpstreamError(model, class string, retried bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := upstreamErrorKey{model: model, class: class}
+	um, ok := m.upstream[key]
+	if !ok {
+		um = &upstreamErrorMetrics{}
+		m.upstream[key] = um
+	}
+	um.count++
+	if retried {
+		um.retried++
+	}
+}
+
+// RecordError 记录一条最近错误
+func (m *Metrics) RecordError(e RecentError) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.recentErrors = append(m.recentErrors, e)
+	if len(m.recentErrors) > recentErrorsSize {
+		m.recentErrors = m.recentErrors[len(m.recentErrors)-recentErrorsSize:]
+	}
+}
+
+// Snapshot 返回当前指标快照
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshot := MetricsSnapshot{
+		UptimeSeconds: int64(now.Sub(m.startedAt).Seconds()),
+		Series:        make([]RatePoint, 0, 60),
+	}
+
+	var last10, last60 int64
+	for i := int64(59); i >= 0; i-- {
+		second := now.Unix() - i
+		point := RatePoint{Time: second}
+		if bucket := m.buckets[second%metricsWindowSeconds]; bucket.second == second {
+			point.Requests = bucket.requests
+			point.Errors = bucket.errors
+		}
+		snapshot.Series = append(snapshot.Series, point)
+		last60 += point.Requests
+		if i < 10 {
+			last10 += point.Requests
+		}
+	}
+	snapshot.RPS10s = float64(last10) / 10
+	snapshot.RPS60s = float64(last60) / 60
+
+	for model, mm := range m.models {
+		view := ModelMetricsView{Model: model, Requests: mm.requests, Errors: mm.errors}
+		if succeeded := mm.requests - mm.errors; succeeded > 0 {
+			view.AvgMs = float64(mm.totalMs) / float64(succeeded)
+		}
+		view.P50Ms, view.P95Ms = percentiles(mm.samples)
+		snapshot.Models = append(snapshot.Models, view)
+	}
+	sort.Slice(snapshot.Models, func(i, j int) bool {
+		return snapshot.Models[i].Requests > snapshot.Models[j].Requests
+	})
+
+	for keyID, km := range m.keys {
+		view := KeyMetricsView{KeyID: keyID, Requests: km.requests, Errors: km.errors, LastUsed: km.lastUsed}
+		if keyStore != nil {
+			if key, ok := keyStore.Get(keyID); ok {
+				view.Name = key.Name
+			}
+		}
+		snapshot.Keys = append(snapshot.Keys, view)
+	}
+	sort.Slice(snapshot.Keys, func(i, j int) bool {
+		return snapshot.Keys[i].Requests > snapshot.Keys[j].Requests
+	})
+
+	snapshot.UpstreamErrors = make([]UpstreamErrorView, 0, len(m.upstream))
+	for key, um := range m.upstream {
+		snapshot.UpstreamErrors = append(snapshot.UpstreamErrors, UpstreamErrorView{Model: key.model, Class: key.class, Count: um.count, Retried: um.retried})
+	}
+	sort.Slice(snapshot.UpstreamErrors, func(i, j int) bool {
+		a, b := snapshot.UpstreamErrors[i], snapshot.UpstreamErrors[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		if a.Model != b.Model {
+			return a.Model < b.Model
+		}
+		return a.Class < b.Class
+	})
+
+	snapshot.RecentErrors = make([]RecentError, len(m.recentErrors))
+	for i, e := range m.recentErrors {
+		snapshot.RecentErrors[len(m.recentErrors)-1-i] = e
+	}
+	return snapshot
+}
+
+func percentiles(samples []int64) (p50, p95 int64) {
+	if len(samples) == 0 {
+		return 0, 0
+	}
+	sorted := append([]int64(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[len(sorted)*50/100], sorted[(len(sorted)*95)/100]
+}
+
+// errorCaptureWriter 在响应状态码为错误时保留响应体，用于提取错误信息
+type errorCaptureWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *errorCaptureWriter) Write(data []byte) (int, error) {
+	if w.Status() >= 400 && w.body.Len() < errorBodyCaptureMax {
+		w.body.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// metricsMiddleware 记录请求速率、模型延迟、密钥用量和错误信息
+func metricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		writer := &errorCaptureWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+
+		c.Next()
+
+		status := c.Writer.Status()
+		model := c.GetString(CTX_MODEL)
+		keyID := c.GetString(CTX_KEY_ID)
+		failed := status >= 400
+		metrics.Record(model, keyID, time.Since(start), failed)
+
+		if failed {
+			metrics.RecordError(RecentError{
+				Time:      time.Now(),
+				RequestID: c.GetString(CTX_REQUEST_ID),
+				Path:      c.Request.URL.Path,
+				Model:     model,
+				KeyID:     keyID,
+				Status:    status,
+				Message:   errorMessageFromBody(writer.body.Bytes()),
+			})
+		}
+	}
+}
+
+// errorMessageFromBody 从错误响应体中提取错误信息，兼容字符串和对象两种格式
+func errorMessageFromBody(body []byte) string {
+	var payload struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal(body, &payload); err == nil && len(payload.Error) > 0 {
+		var message string
+		if err := json.Unmarshal(payload.Error, &message); err == nil {
+			return message
+		}
+		var object struct {
+			Message string `json:"message"`
+		}
+		if err := json.Unmarshal(payload.Error, &object); err == nil && object.Message != "" {
+			return object.Message
+		}
+	}
+	return truncateString(string(body), 200)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPercentiles(t *testing.T) {
+	seq := func(n int) []int64 {
+		samples := make([]int64, n)
+		for i := range samples {
+			samples[i] = int64(n - i)
+		}
+		return samples
+	}
+	tests := []struct {
+		name     string
+		samples  []int64
+		p50, p95 int64
+	}{
+		{"没有样本", nil, 0, 0},
+		{"一个样本", []int64{7}, 7, 7},
+		{"两个样本", []int64{9, 1}, 9, 9},
+		{"乱序的100个样本", seq(100), 51, 96},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := append([]int64(nil), tt.samples...)
+			p50, p95 := percentiles(tt.samples)
+			if p50 != tt.p50 || p95 != tt.p95 {
+				t.Errorf("percentiles = %d, %d，期望 %d, %d", p50, p95, tt.p50, tt.p95)
+			}
+			for i := range original {
+				if original[i] != tt.samples[i] {
+					t.Fatal("percentiles 不应修改样本")
+				}
+			}
+		})
+	}
+}
+
+func TestMetricsRecordAndSnapshot(t *testing.T) {
+	m := NewMetrics()
+	m.Record("a", "k1", 100*time.Millisecond, false)
+	m.Record("a", "k1", 300*time.Millisecond, false)
+	m.Record("a", "k2", time.Second, true)
+	m.Record("b", "", 50*time.Millisecond, false)
+	m.Record("", "k2", 0, true)
+
+	snapshot := m.Snapshot()
+	if len(snapshot.Series) != 60 {
+		t.Fatalf("Series 长度 = %d", len(snapshot.Series))
+	}
+	var requests, errors int64
+	for _, point := range snapshot.Series {
+		requests += point.Requests
+		errors += point.Errors
+	}
+	if requests != 5 || errors != 2 || snapshot.RPS60s != 5.0/60 {
+		t.Errorf("速率 = %d 请求, %d 错误, rps60 = %f", requests, errors, snapshot.RPS60s)
+	}
+
+	if len(snapshot.Models) != 2 || snapshot.Models[0].Model != "a" {
+		t.Fatalf("Models = %+v", snapshot.Models)
+	}
+	// 失败的请求不计入延迟
+	if a := snapshot.Models[0]; a.Requests != 3 || a.Errors != 1 || a.AvgMs != 200 || a.P50Ms != 300 || a.P95Ms != 300 {
+		t.Errorf("模型 a = %+v", a)
+	}
+
+	if len(snapshot.Keys) != 2 {
+		t.Fatalf("Keys = %+v", snapshot.Keys)
+	}
+	for _, key := range snapshot.Keys {
+		if key.Requests != 2 || (key.KeyID == "k2" && key.Errors != 2) || key.LastUsed.IsZero() {
+			t.Errorf("密钥 = %+v", key)
+		}
+	}
+}
+
+func TestMetricsLatencySamplesBounded(t *testing.T) {
+	m := NewMetrics()
+	for i := 0; i < latencySampleSize*2; i++ {
+		m.Record("a", "", time.Duration(i)*time.Millisecond, false)
+	}
+	if n := len(m.models["a"].samples); n != latencySampleSize {
+		t.Fatalf("样本数 = %d，期望 %d", n, latencySampleSize)
+	}
+	// 只保留最近的样本
+	if p50, _ := percentiles(m.models["a"].samples); p50 < latencySampleSize {
+		t.Errorf("p50 = %d，应来自最近的样本", p50)
+	}
+}
+
+func TestMetricsRecentErrors(t *testing.T) {
+	m := NewMetrics()
+	for i := 0; i < recentErrorsSize+5; i++ {
+		m.RecordError(RecentError{Status: 500 + i})
+	}
+	errors := m.Snapshot().RecentErrors
+	if len(errors) != recentErrorsSize {
+		t.Fatalf("最近错误数 = %d", len(errors))
+	}
+	if errors[0].Status != 500+recentErrorsSize+4 || errors[len(errors)-1].Status != 505 {
+		t.Errorf("最近错误应按时间倒序且只保留最新的: 第一条 %d, 最后一条 %d", errors[0].Status, errors[len(errors)-1].Status)
+	}
+}
+
+func TestErrorMessageFromBody(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+	}{
+		{`{"error":"plain"}`, "plain"},
+		{`{"error":{"message":"nested","type":"x"}}`, "nested"},
+		{`{"error":{"code":"x"}}`, `{"error":{"code":"x"}}`},
+		{`{"message":"no error field"}`, `{"message":"no error field"}`},
+		{`not json`, "not json"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := errorMessageFromBody([]byte(tt.body)); got != tt.want {
+			t.Errorf("errorMessageFromBody(%s) = %q，期望 %q", tt.body, got, tt.want)
+		}
+	}
+	if got := errorMessageFromBody([]byte(strings.Repeat("x", 500))); len(got) > 210 {
+		t.Errorf("过长的响应体应截断，实际长度 %d", len(got))
+	}
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	saved := metrics
+	defer func() { metrics = saved }()
+	metrics = NewMetrics()
+
+	r := gin.New()
+	r.Use(metricsMiddleware())
+	r.GET("/ok", func(c *gin.Context) {
+		c.Set(CTX_MODEL, "a")
+		c.String(http.StatusOK, "ok")
+	})
+	r.GET("/fail", func(c *gin.Context) {
+		c.Set(CTX_MODEL, "a")
+		c.Set(CTX_KEY_ID, "k")
+		c.JSON(http.StatusBadGateway, gin.H{"error": gin.H{"message": "upstream down"}})
+	})
+	for _, path := range []string{"/ok", "/fail"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	snapshot := metrics.Snapshot()
+	if len(snapshot.Models) != 1 || snapshot.Models[0].Requests != 2 || snapshot.Models[0].Errors != 1 {
+		t.Fatalf("Models = %+v", snapshot.Models)
+	}
+	if len(snapshot.RecentErrors) != 1 {
+		t.Fatalf("RecentErrors = %+v", snapshot.RecentErrors)
+	}
+	if e := snapshot.RecentErrors[0]; e.Status != http.StatusBadGateway || e.Message != "upstream down" || e.Path != "/fail" || e.KeyID != "k" {
+		t.Errorf("最近错误 = %+v", e)
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"log"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 模拟上游相关环境变量，均可被命令行参数覆盖
+const (
+	ENV_MOCK_ADDR            = "E2B_MOCK_ADDR"
+	ENV_MOCK_MODE            = "E2B_MOCK_MODE"
+	ENV_MOCK_RESPONSE        = "E2B_MOCK_RESPONSE"
+	ENV_MOCK_TEMPLATE        = "E2B_MOCK_TEMPLATE"
+	ENV_MOCK_FIELD           = "E2B_MOCK_FIELD"
+	ENV_MOCK_LATENCY         = "E2B_MOCK_LATENCY"
+	ENV_MOCK_JITTER          = "E2B_MOCK_JITTER"
+	ENV_MOCK_ERROR_RATE      = "E2B_MOCK_ERROR_RATE"
+	ENV_MOCK_RATE_LIMIT_RATE = "E2B_MOCK_RATE_LIMIT_RATE"
+	ENV_MOCK_MALFORMED_RATE  = "E2B_MOCK_MALFORMED_RATE"
+	ENV_MOCK_EMPTY_RATE      = "E2B_MOCK_EMPTY_RATE"
+)
+
+// mockDirectivePattern 匹配提示词中的故障注入指令，例如 [mock:429]、[mock:latency=2s]
+var mockDirectivePattern = regexp.MustCompile(`\[mock:([a-z0-9_]+)(?:=([^\]]+))?\]`)
+
+// mockOptions 模拟上游的运行参数
+type mockOptions struct {
+	Addr          string
+	Mode          string
+	Response      string
+	Template      string
+	Field         string
+	Latency       time.Duration
+	Jitter        time.Duration
+	ErrorRate     float64
+	RateLimitRate float64
+	MalformedRate float64
+	EmptyRate     float64
+}
+
+// mockTemplateData 模板模式可用的变量
+type mockTemplateData struct {
+	Prompt       string
+	Model        string
+	Provider     string
+	UserID       string
+	MessageCount int
+	Template     string
+	Time         string
+}
+
+// runMockUpstream 启动实现 fragments /api/chat 接口的本地模拟上游
+func runMockUpstream(args []string) {
+	opts := mockOptions{}
+	fs := flag.NewFlagSet("mock-upstream", flag.ExitOnError)
+	fs.StringVar(&opts.Addr, "addr", getEnv(ENV_MOCK_ADDR, ":3001"), "监听地址")
+	fs.StringVar(&opts.Mode, "mode", getEnv(ENV_MOCK_MODE, "echo"), "响应模式: echo, canned, template")
+	fs.StringVar(&opts.Response, "response", getEnv(ENV_MOCK_RESPONSE, "This is a canned response from the mock upstream."), "canned 模式返回的固定内容")
+	fs.StringVar(&opts.Template, "template", getEnv(ENV_MOCK_TEMPLATE, "[{{.Model}}] {{.Prompt}}"), "template 模式使用的 Go text/template")
+	fs.StringVar(&opts.Field, "field", getEnv(ENV_MOCK_FIELD, "code"), "内容写入的字段: code, text, both")
+	fs.DurationVar(&opts.Latency, "latency", getEnvDuration(ENV_MOCK_LATENCY, 0), "每个请求的固定延迟")
+	fs.DurationVar(&opts.Jitter, "jitter", getEnvDuration(ENV_MOCK_JITTER, 0), "在固定延迟之上增加的随机延迟上限")
+	fs.Float64Var(&opts.ErrorRate, "error-rate", getEnvFloat(ENV_MOCK_ERROR_RATE, 0), "返回500的概率(0-1)")
+	fs.Float64Var(&opts.RateLimitRate, "rate-limit-rate", getEnvFloat(ENV_MOCK_RATE_LIMIT_RATE, 0), "返回429的概率(0-1)")
+	fs.Float64Var(&opts.MalformedRate, "malformed-rate", getEnvFloat(ENV_MOCK_MALFORMED_RATE, 0), "返回非法JSON的概率(0-1)")
+	fs.Float64Var(&opts.EmptyRate, "empty-rate", getEnvFloat(ENV_MOCK_EMPTY_RATE, 0), "返回空内容的概率(0-1)")
+	fs.Parse(args)
+
+	tmpl, err := template.New("mock").Parse(opts.Template)
+	if err != nil {
+		log.Fatalf("解析模拟响应模板失败: %v", err)
+	}
+
+	r := gin.New()
+	r.Use(gin.Logger())
+	r.Use(gin.Recovery())
+	r.POST("/api/chat", func(c *gin.Context) {
+		handleMockChat(c, opts, tmpl)
+	})
+	r.GET("/health", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok", "mock": true})
+	})
+
+	log.Printf("模拟上游启动在 %s, 模式: %s, 延迟: %s(+%s), 错误率: %.2f, 429率: %.2f, 非法JSON率: %.2f, 空响应率: %.2f",
+		opts.Addr, opts.Mode, opts.Latency, opts.Jitter, opts.ErrorRate, opts.RateLimitRate, opts.MalformedRate, opts.EmptyRate)
+	if err := r.Run(opts.Addr); err != nil {
+		log.Fatalf("模拟上游启动失败: %v", err)
+	}
+}
+
+// handleMockChat 处理 /api/chat 请求，按配置注入延迟和故障
+func handleMockChat(c *gin.Context, opts mockOptions, tmpl *template.Template) {
+	requestID := GenerateUUID()
+
+	var request E2BRequest
+	if err := c.BindJSON(&request); err != nil {
+		logError(requestID, "模拟上游: 解析请求失败", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	prompt := lastUserPrompt(request.Messages)
+	directives := parseMockDirectives(prompt)
+	logInfo(requestID, "模拟上游收到请求", map[string]interface{}{
+		"model":      request.Model.ID,
+		"messages":   len(request.Messages),
+		"directives": directives,
+	})
+
+	// 延迟：提示词中的 latency 指令优先
+	latency := opts.Latency
+	if value, ok := directives["latency"]; ok {
+		if d, err := time.ParseDuration(value); err == nil {
+			latency = d
+		}
+	}
+	if opts.Jitter > 0 {
+		latency += time.Duration(rand.Int63n(int64(opts.Jitter)))
+	}
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+
+	// 故障注入：指令优先，其次按概率
+	switch {
+	case hasDirective(directives, "error", request.Model.ID) || chance(opts.ErrorRate):
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "mock upstream internal error"})
+		return
+	case hasDirective(directives, "429", request.Model.ID) || chance(opts.RateLimitRate):
+		c.Header("Retry-After", "1")
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit exceeded. Please try again later."})
+		return
+	case hasDirective(directives, "policy", request.Model.ID):
+		c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{"message": "Your request was rejected as a result of our safety system.", "type": "content_policy"}})
+		return
+	case hasDirective(directives, "overflow", request.Model.ID):
+		c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{"message": "prompt is too long: 250000 tokens > 200000 maximum", "type": "invalid_request_error"}})
+		return
+	case hasDirective(directives, "malformed", request.Model.ID) || chance(opts.MalformedRate):
+		c.Data(http.StatusOK, "application/json", []byte(`{"code": "unterminated`))
+		return
+	case hasDirective(directives, "empty", request.Model.ID) || chance(opts.EmptyRate):
+		c.JSON(http.StatusOK, E2BResponse{})
+		return
+	}
+
+	content, err := renderMockContent(opts, tmpl, request, mockDirectivePattern.ReplaceAllString(prompt, ""))
+	if err != nil {
+		logError(requestID, "模拟上游: 渲染响应失败", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := E2BResponse{}
+	switch opts.Field {
+	case "text":
+		response.Text = content
+	case "both":
+		response.Text = content
+		response.Code = content
+	default:
+		response.Code = content
+	}
+	fillMockFragment(&response, request, mockDirectivePattern.ReplaceAllString(prompt, ""))
+	c.JSON(http.StatusOK, response)
+}
+
+// fillMockFragment 请求代码类模板时像 fragments 一样填充结构化字段，text 模板只返回内容
+func fillMockFragment(response *E2BResponse, request E2BRequest, prompt string) {
+	names := make([]string, 0, len(request.Template))
+	for name := range request.Template {
+		if name != TEMPLATE_TEXT {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return
+	}
+	sort.Strings(names)
+	name := names[0]
+	spec, _ := request.Template[name].(map[string]interface{})
+
+	response.Template = name
+	response.Title = "Mock " + name
+	response.Description = "Mock fragment generated by the local mock upstream."
+	response.Commentary = "Generating a " + name + " fragment for: " + truncateString(strings.TrimSpace(prompt), 80)
+	response.AdditionalDependencies = []string{}
+	if file, ok := spec["file"].(string); ok {
+		response.FilePath = file
+	}
+	if port, ok := spec["port"].(float64); ok {
+		p := int(port)
+		response.Port = &p
+	}
+}
+
+// renderMockContent 按模式生成响应内容
+func renderMockContent(opts mockOptions, tmpl *template.Template, request E2BRequest, prompt string) (string, error) {
+	switch opts.Mode {
+	case "canned":
+		return opts.Response, nil
+	case "template":
+		templateNames := make([]string, 0, len(request.Template))
+		for name := range request.Template {
+			templateNames = append(templateNames, name)
+		}
+		var buf bytes.Buffer
+		err := tmpl.Execute(&buf, mockTemplateData{
+			Prompt:       strings.TrimSpace(prompt),
+			Model:        request.Model.ID,
+			Provider:     request.Model.Provider,
+			UserID:       request.UserID,
+			MessageCount: len(request.Messages),
+			Template:     strings.Join(templateNames, ","),
+			Time:         time.Now().Format(time.RFC3339),
+		})
+		return buf.String(), err
+	default:
+		if strings.TrimSpace(prompt) == "" {
+			return "(empty prompt)", nil
+		}
+		return strings.TrimSpace(prompt), nil
+	}
+}
+
+// lastUserPrompt 取最后一条用户消息的文本
+func lastUserPrompt(messages []ChatMessage) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" {
+			return ProcessMessageContent(messages[i].Content)
+		}
+	}
+	return ""
+}
+
+// parseMockDirectives 提取提示词中的 [mock:xxx] 指令
+func parseMockDirectives(prompt string) map[string]string {
+	directives := make(map[string]string)
+	for _, match := range mockDirectivePattern.FindAllStringSubmatch(prompt, -1) {
+		directives[match[1]] = match[2]
+	}
+	return directives
+}
+
+// hasDirective 判断指令是否生效，带值的故障指令(如 [mock:error=claude-3-opus-20240229])只对指定模型生效
+func hasDirective(directives map[string]string, name string, modelID string) bool {
+	value, ok := directives[name]
+	return ok && (value == "" || value == modelID)
+}
+
+func chance(rate float64) bool {
+	return rate > 0 && rand.Float64() < rate
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// 模型别名和备用模型相关环境变量
+const (
+	ENV_MODEL_ALIASES   = "E2B_MODEL_ALIASES"   // 格式: 别名=模型,别名=模型
+	ENV_MODEL_FALLBACKS = "E2B_MODEL_FALLBACKS" // 格式: 模型=备用1|备用2,模型=备用1
+	maxAliasDepth       = 5
+)
+
+// modelMu 保护 CONFIG.MODEL_CONFIG 和 disabledModels，模型可在运行时通过管理接口启用或禁用
+var (
+	modelMu        sync.RWMutex
+	disabledModels = make(map[string]bool)
+)
+
+// ModelAlias 模型别名
+type ModelAlias struct {
+	Name   string `json:"name"`
+	Target string `json:"target"`
+}
+
+// ModelStatus 模型及其启用状态
+type ModelStatus struct {
+	Name    string      `json:"name"`
+	Enabled bool        `json:"enabled"`
+	Config  ModelConfig `json:"config"`
+}
+
+// lookupModel 查找已启用的模型配置
+func lookupModel(name string) (ModelConfig, bool) {
+	modelMu.RLock()
+	defer modelMu.RUnlock()
+	if disabledModels[name] {
+		return ModelConfig{}, false
+	}
+	modelConfig, ok := CONFIG.MODEL_CONFIG[name]
+	return modelConfig, ok
+}
+
+// enabledModelNames 按名称排序返回所有已启用的模型
+func enabledModelNames() []string {
+	modelMu.RLock()
+	defer modelMu.RUnlock()
+	names := make([]string, 0, len(CONFIG.MODEL_CONFIG))
+	for name := range CONFIG.MODEL_CONFIG {
+		if !disabledModels[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// listModelStatus 返回所有模型及其启用状态
+func listModelStatus() []ModelStatus {
+	modelMu.RLock()
+	defer modelMu.RUnlock()
+	statuses := make([]ModelStatus, 0, len(CONFIG.MODEL_CONFIG))
+	for name, modelConfig := range CONFIG.MODEL_CONFIG {
+		statuses = append(statuses, ModelStatus{
+			Name:    name,
+			Enabled: !disabledModels[name],
+			Config:  modelConfig,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+// setModelEnabled 在运行时启用或禁用模型
+func setModelEnabled(name string, enabled bool) error {
+	modelMu.Lock()
+	defer modelMu.Unlock()
+	if _, ok := CONFIG.MODEL_CONFIG[name]; !ok {
+		return fmt.Errorf("模型不存在: %s", name)
+	}
+	if enabled {
+		delete(disabledModels, name)
+	} else {
+		disabledModels[name] = true
+	}
+	return nil
+}
+
+// resolveAlias 把别名解析为实际模型名，支持别名指向别名
+func resolveAlias(name string) (string, error) {
+	modelMu.RLock()
+	defer modelMu.RUnlock()
+
+	resolved := name
+	for depth := 0; depth < maxAliasDepth; depth++ {
+		if _, ok := CONFIG.MODEL_CONFIG[resolved]; ok {
+			return resolved, nil
+		}
+		target, ok := CONFIG.MODEL_ALIASES[resolved]
+		if !ok {
+			return "", fmt.Errorf("模型不存在: %s", name)
+		}
+		resolved = target
+	}
+	return "", fmt.Errorf("模型别名嵌套过深: %s", name)
+}
+
+// resolveModelChain 解析请求的模型，返回主模型及其已启用的备用模型，主模型排在第一位
+func resolveModelChain(requested string) ([]string, error) {
+	primary, err := resolveAlias(requested)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := lookupModel(primary); !ok {
+		return nil, fmt.Errorf("模型已禁用: %s", primary)
+	}
+
+	modelMu.RLock()
+	fallbacks, ok := CONFIG.MODEL_FALLBACKS[requested]
+	if !ok {
+		fallbacks = CONFIG.MODEL_FALLBACKS[primary]
+	}
+	fallbacks = append([]string(nil), fallbacks...)
+	modelMu.RUnlock()
+
+	chain := []string{primary}
+	seen := map[string]bool{primary: true}
+	for _, fallback := range fallbacks {
+		resolved, err := resolveAlias(fallback)
+		if err != nil || seen[resolved] {
+			continue
+		}
+		if _, ok := lookupModel(resolved); !ok {
+			continue
+		}
+		seen[resolved] = true
+		chain = append(chain, resolved)
+	}
+	return chain, nil
+}
+
+// enabledAliases 返回目标模型已启用的别名
+func enabledAliases() []ModelAlias {
+	modelMu.RLock()
+	names := make([]string, 0, len(CONFIG.MODEL_ALIASES))
+	for alias := range CONFIG.MODEL_ALIASES {
+		if _, ok := CONFIG.MODEL_CONFIG[alias]; !ok {
+			names = append(names, alias)
+		}
+	}
+	modelMu.RUnlock()
+	sort.Strings(names)
+
+	aliases := make([]ModelAlias, 0, len(names))
+	for _, alias := range names {
+		target, err := resolveAlias(alias)
+		if err != nil {
+			continue
+		}
+		if _, ok := lookupModel(target); ok {
+			aliases = append(aliases, ModelAlias{Name: alias, Target: target})
+		}
+	}
+	return aliases
+}
+
+// parseModelAliases 解析 "别名=模型,别名=模型" 格式的别名配置
+func parseModelAliases(value string) map[string]string {
+	return parseKeyValueList(value, "模型别名")
+}
+
+// parseKeyValueList 解析 "键=值,键=值" 格式的配置，what 用于警告信息
+func parseKeyValueList(value, what string) map[string]string {
+	aliases := make(map[string]string)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			log.Printf("警告: 无法解析%s配置 %q，已忽略", what, item)
+			continue
+		}
+		aliases[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return aliases
+}
+
+// parseModelFallbacks 解析 "模型=备用1|备用2,模型=备用1" 格式的备用模型配置
+func parseModelFallbacks(value string) map[string][]string {
+	fallbacks := make(map[string][]string)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			log.Printf("警告: 无法解析备用模型配置 %q，已忽略", item)
+			continue
+		}
+		var models []string
+		for _, model := range strings.Split(parts[1], "|") {
+			if model = strings.TrimSpace(model); model != "" {
+				models = append(models, model)
+			}
+		}
+		fallbacks[strings.TrimSpace(parts[0])] = models
+	}
+	return fallbacks
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// withModelRouting 临时替换别名和备用模型配置，测试结束时恢复并重新启用所有模型
+func withModelRouting(t *testing.T, aliases map[string]string, fallbacks map[string][]string) {
+	savedAliases, savedFallbacks := CONFIG.MODEL_ALIASES, CONFIG.MODEL_FALLBACKS
+	CONFIG.MODEL_ALIASES, CONFIG.MODEL_FALLBACKS = aliases, fallbacks
+	t.Cleanup(func() {
+		CONFIG.MODEL_ALIASES, CONFIG.MODEL_FALLBACKS = savedAliases, savedFallbacks
+		for name := range CONFIG.MODEL_CONFIG {
+			setModelEnabled(name, true)
+		}
+	})
+}
+
+func TestResolveModelChain(t *testing.T) {
+	const sonnet, haiku, opus = "claude-3-5-sonnet-20240620", "claude-3-haiku-20240307", "claude-3-opus-20240229"
+	withModelRouting(t,
+		map[string]string{
+			"fast":  haiku,
+			"quick": "fast",
+			"smart": sonnet,
+			"loop1": "loop2",
+			"loop2": "loop1",
+			"ghost": "missing-model",
+		},
+		map[string][]string{
+			sonnet:  {"fast", "missing-model", haiku, sonnet, opus},
+			"smart": {opus},
+		},
+	)
+
+	tests := []struct {
+		name      string
+		requested string
+		disabled  []string
+		want      []string
+		wantErr   bool
+	}{
+		{"没有备用模型", haiku, nil, []string{haiku}, false},
+		{"别名", "fast", nil, []string{haiku}, false},
+		{"别名指向别名", "quick", nil, []string{haiku}, false},
+		{"备用模型去重并忽略不存在的模型", sonnet, nil, []string{sonnet, haiku, opus}, false},
+		{"别名自身的备用模型优先", "smart", nil, []string{sonnet, opus}, false},
+		{"跳过已禁用的备用模型", sonnet, []string{haiku}, []string{sonnet, opus}, false},
+		{"主模型已禁用", sonnet, []string{sonnet}, nil, true},
+		{"未知模型", "nope", nil, nil, true},
+		{"别名循环", "loop1", nil, nil, true},
+		{"别名指向不存在的模型", "ghost", nil, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name := range CONFIG.MODEL_CONFIG {
+				setModelEnabled(name, true)
+			}
+			for _, name := range tt.disabled {
+				setModelEnabled(name, false)
+			}
+			chain, err := resolveModelChain(tt.requested)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("错误 = %v，期望出错 %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(chain, tt.want) {
+				t.Errorf("resolveModelChain(%s) = %v，期望 %v", tt.requested, chain, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnabledAliases(t *testing.T) {
+	const haiku = "claude-3-haiku-20240307"
+	withModelRouting(t, map[string]string{"fast": haiku, "ghost": "missing-model", haiku: "ignored"}, nil)
+
+	if got := enabledAliases(); !reflect.DeepEqual(got, []ModelAlias{{Name: "fast", Target: haiku}}) {
+		t.Errorf("enabledAliases = %+v", got)
+	}
+	setModelEnabled(haiku, false)
+	if got := enabledAliases(); len(got) != 0 {
+		t.Errorf("目标模型禁用后不应列出别名: %+v", got)
+	}
+}
+
+func TestSetModelEnabled(t *testing.T) {
+	withModelRouting(t, nil, nil)
+	if err := setModelEnabled("missing-model", false); err == nil {
+		t.Fatal("不存在的模型应返回错误")
+	}
+	name := enabledModelNames()[0]
+	setModelEnabled(name, false)
+	for _, enabled := range enabledModelNames() {
+		if enabled == name {
+			t.Fatal("禁用的模型不应出现在已启用列表中")
+		}
+	}
+	for _, status := range listModelStatus() {
+		if status.Name == name && status.Enabled {
+			t.Fatal("模型状态应为禁用")
+		}
+	}
+}
+
+func TestParseModelAliasesAndFallbacks(t *testing.T) {
+	aliases := parseModelAliases(" fast = haiku ,bad, =x, y= ,smart=sonnet")
+	if !reflect.DeepEqual(aliases, map[string]string{"fast": "haiku", "smart": "sonnet"}) {
+		t.Errorf("parseModelAliases = %v", aliases)
+	}
+	fallbacks := parseModelFallbacks("sonnet=haiku| opus ||,bad,haiku=")
+	if !reflect.DeepEqual(fallbacks, map[string][]string{"sonnet": {"haiku", "opus"}, "haiku": nil}) {
+		t.Errorf("parseModelFallbacks = %v", fallbacks)
+	}
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/yourusername/e2b-api-gateway/apierror"
+)
+
+// 采样参数相关环境变量
+const (
+	ENV_PARAM_MODE         = "E2B_PARAM_MODE"         // 参数超出范围时的默认处理方式
+	ENV_MODEL_PARAM_MODE   = "E2B_MODEL_PARAM_MODE"   // 按模型设置处理方式，格式: 模型=方式,模型=方式
+	ENV_MODEL_PARAM_LIMITS = "E2B_MODEL_PARAM_LIMITS" // 按模型覆盖参数范围，格式: 模型=参数:最小..最大;参数:off,模型=...
+)
+
+// 参数超出范围时的处理方式
+const (
+	PARAM_MODE_CLAMP  = "clamp"  // 调整到范围边界并通过响应头告知调用方
+	PARAM_MODE_REJECT = "reject" // 返回400错误
+)
+
+// paramUnsupported 参数范围配置中表示上游不支持该参数
+const paramUnsupported = "off"
+
+var paramModes = []string{PARAM_MODE_CLAMP, PARAM_MODE_REJECT}
+
+// paramNames 发送给上游的采样参数，按此顺序处理和展示
+var paramNames = []string{"temperature", "max_tokens", "presence_penalty", "frequency_penalty", "top_p", "top_k"}
+
+// integerParams 取值为整数的参数
+var integerParams = map[string]bool{"max_tokens": true, "top_k": true}
+
+// ParamRange 参数的取值范围，Min/Max 为 nil 表示该侧不限制。
+// Unsupported 表示上游不支持该参数，请求中的值不会转发
+type ParamRange struct {
+	Min         *float64 `json:"min,omitempty"`
+	Max         *float64 `json:"max,omitempty"`
+	Unsupported bool     `json:"unsupported,omitempty"`
+}
+
+// clamp 把值限制在范围内
+func (r ParamRange) clamp(value float64) float64 {
+	if r.Min != nil && value < *r.Min {
+		value = *r.Min
+	}
+	if r.Max != nil && value > *r.Max {
+		value = *r.Max
+	}
+	return value
+}
+
+func (r ParamRange) String() string {
+	if r.Unsupported {
+		return paramUnsupported
+	}
+	bound := func(v *float64) string {
+		if v == nil {
+			return ""
+		}
+		return formatParamValue(*v)
+	}
+	return bound(r.Min) + ".." + bound(r.Max)
+}
+
+// ParamAdjustment 网关对请求参数所做的一处修改
+type ParamAdjustment struct {
+	Param   string
+	From    float64
+	To      float64
+	Dropped bool
+}
+
+// String 响应头 x-gateway-adjusted-params 中的一项，例如 temperature=1.5->1 或 top_k=dropped
+func (a ParamAdjustment) String() string {
+	if a.Dropped {
+		return a.Param + "=dropped"
+	}
+	return fmt.Sprintf("%s=%s->%s", a.Param, formatParamValue(a.From), formatParamValue(a.To))
+}
+
+func formatParamValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func floatPtr(v float64) *float64 {
+	return &v
+}
+
+// validParamMode 判断参数处理方式是否受支持
+func validParamMode(mode string) bool {
+	return containsString(paramModes, mode)
+}
+
+// defaultParamRange 由模型的 OptMax 推导参数范围。max_tokens 的上限为0表示不限制，
+// 其余参数的上限为0表示上游不支持该参数
+func defaultParamRange(name string, opt OptMax) ParamRange {
+	limited := func(min, max float64) ParamRange {
+		if max <= 0 {
+			return ParamRange{Unsupported: true}
+		}
+		return ParamRange{Min: floatPtr(min), Max: floatPtr(max)}
+	}
+	switch name {
+	case "temperature":
+		return limited(0, opt.TemperatureMax)
+	case "max_tokens":
+		r := ParamRange{Min: floatPtr(1)}
+		if opt.MaxTokensMax > 0 {
+			r.Max = floatPtr(float64(opt.MaxTokensMax))
+		}
+		return r
+	case "presence_penalty":
+		return limited(-opt.PresencePenaltyMax, opt.PresencePenaltyMax)
+	case "frequency_penalty":
+		return limited(-opt.FrequencyPenaltyMax, opt.FrequencyPenaltyMax)
+	case "top_p":
+		return limited(0, opt.TopPMax)
+	case "top_k":
+		return limited(1, float64(opt.TopKMax))
+	}
+	return ParamRange{Unsupported: true}
+}
+
+// resolveParamRange 返回模型生效的参数范围，模型配置中的 ParamLimits 优先于 OptMax
+func resolveParamRange(name string, modelConfig ModelConfig) ParamRange {
+	if r, ok := modelConfig.ParamLimits[name]; ok {
+		return r
+	}
+	return defaultParamRange(name, modelConfig.OptMax)
+}
+
+// resolveParamMode 确定生效的参数处理方式，优先级为 请求 > 模型 > 全局默认
+func resolveParamMode(extension *E2BExtension, modelConfig ModelConfig) string {
+	mode := CONFIG.PARAMS.MODE
+	if modelConfig.ParamMode != "" {
+		mode = modelConfig.ParamMode
+	}
+	if extension != nil && extension.ParamMode != "" {
+		mode = extension.ParamMode
+	}
+	return mode
+}
+
+// validateParamMode 校验请求中的参数处理方式
+func validateParamMode(extension *E2BExtension) error {
+	if extension != nil && extension.ParamMode != "" && !validParamMode(extension.ParamMode) {
+		return apierror.InvalidValue("e2b.param_mode", extension.ParamMode, strings.Join(paramModes, ", "))
+	}
+	return nil
+}
+
+// requestParamValues 收集请求中显式设置的参数，未设置的参数不出现
+func requestParamValues(request ChatRequest) map[string]float64 {
+	values := make(map[string]float64)
+	setFloat := func(name string, v *float64) {
+		if v != nil {
+			values[name] = *v
+		}
+	}
+	setInt := func(name string, v *int) {
+		if v != nil {
+			values[name] = float64(*v)
+		}
+	}
+	setFloat("temperature", request.Temperature)
+	setInt("max_tokens", request.MaxTokens)
+	setFloat("presence_penalty", request.PresencePenalty)
+	setFloat("frequency_penalty", request.FrequencyPenalty)
+	setFloat("top_p", request.TopP)
+	setInt("top_k", request.TopK)
+	return values
+}
+
+// ConfigOpt 按模型的参数范围处理请求中显式设置的参数。显式的0会原样转发，未设置的参数不转发。
+// 上游不支持的参数总是被丢弃；超出范围的值在 clamp 模式下调整到边界，在 reject 模式下返回 parameter_out_of_range 错误
+func ConfigOpt(request ChatRequest, modelName string, modelConfig ModelConfig, mode string) (map[string]interface{}, []ParamAdjustment, error) {
+	values := requestParamValues(request)
+	var config map[string]interface{}
+	var adjustments []ParamAdjustment
+	for _, name := range paramNames {
+		value, ok := values[name]
+		if !ok {
+			continue
+		}
+		limit := resolveParamRange(name, modelConfig)
+		if limit.Unsupported {
+			adjustments = append(adjustments, ParamAdjustment{Param: name, From: value, Dropped: true})
+			continue
+		}
+		adjusted := limit.clamp(value)
+		if integerParams[name] {
+			adjusted = math.Round(adjusted)
+		}
+		if adjusted != value {
+			if mode == PARAM_MODE_REJECT {
+				return nil, nil, apierror.New(http.StatusBadRequest, apierror.TypeInvalidRequest, apierror.CodeParameterOutOfRange,
+					apierror.MsgParameterOutOfRange, modelName, name, limit.String(), formatParamValue(value)).WithParam(name)
+			}
+			adjustments = append(adjustments, ParamAdjustment{Param: name, From: value, To: adjusted})
+		}
+
+		if config == nil {
+			config = make(map[string]interface{})
+		}
+		if integerParams[name] {
+			config[name] = int(adjusted)
+		} else {
+			config[name] = adjusted
+		}
+	}
+	return config, adjustments, nil
+}
+
+// formatParamAdjustments 生成响应头 x-gateway-adjusted-params 的值
+func formatParamAdjustments(adjustments []ParamAdjustment) string {
+	parts := make([]string, len(adjustments))
+	for i, adjustment := range adjustments {
+		parts[i] = adjustment.String()
+	}
+	return strings.Join(parts, ", ")
+}
+
+// parseParamLimits 解析 "参数:最小..最大;参数:off" 格式的参数范围，任一侧可以留空表示不限制
+func parseParamLimits(value string) (map[string]ParamRange, error) {
+	limits := make(map[string]ParamRange)
+	for _, item := range strings.Split(value, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, ":", 2)
+		name := strings.TrimSpace(parts[0])
+		if !containsString(paramNames, name) {
+			return nil, fmt.Errorf("未知参数: %s", name)
+		}
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("参数 %s 缺少范围", name)
+		}
+		spec := strings.TrimSpace(parts[1])
+		if spec == paramUnsupported {
+			limits[name] = ParamRange{Unsupported: true}
+			continue
+		}
+		bounds := strings.SplitN(spec, "..", 2)
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("参数 %s 的范围格式应为 最小..最大: %s", name, spec)
+		}
+		var r ParamRange
+		for i, bound := range bounds {
+			bound = strings.TrimSpace(bound)
+			if bound == "" {
+				continue
+			}
+			v, err := strconv.ParseFloat(bound, 64)
+			if err != nil {
+				return nil, fmt.Errorf("参数 %s 的范围无效: %s", name, spec)
+			}
+			if i == 0 {
+				r.Min = floatPtr(v)
+			} else {
+				r.Max = floatPtr(v)
+			}
+		}
+		if r.Min != nil && r.Max != nil && *r.Min > *r.Max {
+			return nil, fmt.Errorf("参数 %s 的最小值大于最大值: %s", name, spec)
+		}
+		limits[name] = r
+	}
+	return limits, nil
+}
+
+// applyModelParamConfig 把环境变量中的参数范围和处理方式写入模型配置
+func applyModelParamConfig(limits, modes string) {
+	for model, value := range parseKeyValueList(limits, "参数范围") {
+		modelConfig, ok := CONFIG.MODEL_CONFIG[model]
+		if !ok {
+			log.Printf("警告: 参数范围配置中的模型不存在: %s", model)
+			continue
+		}
+		parsed, err := parseParamLimits(value)
+		if err != nil {
+			log.Fatalf("模型 %s 的参数范围无效: %v", model, err)
+		}
+		if modelConfig.ParamLimits == nil {
+			modelConfig.ParamLimits = make(map[string]ParamRange)
+		}
+		for name, r := range parsed {
+			modelConfig.ParamLimits[name] = r
+		}
+		CONFIG.MODEL_CONFIG[model] = modelConfig
+	}
+	for model, mode := range parseKeyValueList(modes, "参数处理方式") {
+		modelConfig, ok := CONFIG.MODEL_CONFIG[model]
+		if !ok {
+			log.Printf("警告: 参数处理方式配置中的模型不存在: %s", model)
+			continue
+		}
+		if !validParamMode(mode) {
+			log.Fatalf("模型 %s 的参数处理方式无效: %s，可选: %s", model, mode, strings.Join(paramModes, ", "))
+		}
+		modelConfig.ParamMode = mode
+		CONFIG.MODEL_CONFIG[model] = modelConfig
+	}
+}
+
+// modelParamRanges 返回模型所有参数生效的范围，用于管理接口展示
+func modelParamRanges(modelConfig ModelConfig) map[string]string {
+	ranges := make(map[string]string, len(paramNames))
+	for _, name := range paramNames {
+		ranges[name] = resolveParamRange(name, modelConfig).String()
+	}
+	return ranges
+}
+
+// modelParamRangesByModel 返回所有已启用模型的参数范围
+func modelParamRangesByModel() map[string]map[string]string {
+	ranges := make(map[string]map[string]string)
+	for _, name := range enabledModelNames() {
+		if modelConfig, ok := lookupModel(name); ok {
+			ranges[name] = modelParamRanges(modelConfig)
+		}
+	}
+	return ranges
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/yourusername/e2b-api-gateway/apierror"
+)
+
+func TestParseParamLimits(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"", map[string]string{}, false},
+		{"temperature:0..0.8;top_k:1..40", map[string]string{"temperature": "0..0.8", "top_k": "1..40"}, false},
+		{" top_p : ..0.9 ; max_tokens:100.. ", map[string]string{"top_p": "..0.9", "max_tokens": "100.."}, false},
+		{"top_k:off", map[string]string{"top_k": "off"}, false},
+		{"presence_penalty:-1..1;", map[string]string{"presence_penalty": "-1..1"}, false},
+		{"seed:1..2", nil, true},
+		{"temperature", nil, true},
+		{"temperature:0-1", nil, true},
+		{"temperature:a..1", nil, true},
+		{"temperature:2..1", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			limits, err := parseParamLimits(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("错误 = %v，期望出错 %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			got := make(map[string]string)
+			for name, r := range limits {
+				got[name] = r.String()
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseParamLimits = %v，期望 %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigOpt(t *testing.T) {
+	f := func(v float64) *float64 { return &v }
+	i := func(v int) *int { return &v }
+	modelConfig := ModelConfig{
+		OptMax: OptMax{TemperatureMax: 1, MaxTokensMax: 4096, PresencePenaltyMax: 2, FrequencyPenaltyMax: 2, TopPMax: 1, TopKMax: 0},
+		ParamLimits: map[string]ParamRange{
+			"top_p": {Min: f(0.1), Max: f(0.9)},
+		},
+	}
+
+	tests := []struct {
+		name         string
+		request      ChatRequest
+		mode         string
+		wantConfig   map[string]interface{}
+		wantAdjust   string
+		wantErrParam string
+	}{
+		{"未设置的参数不转发", ChatRequest{}, PARAM_MODE_CLAMP, nil, "", ""},
+		{"显式的0原样转发", ChatRequest{Temperature: f(0), PresencePenalty: f(0)}, PARAM_MODE_CLAMP,
+			map[string]interface{}{"temperature": 0.0, "presence_penalty": 0.0}, "", ""},
+		{"范围内的值", ChatRequest{Temperature: f(0.7), MaxTokens: i(100)}, PARAM_MODE_CLAMP,
+			map[string]interface{}{"temperature": 0.7, "max_tokens": 100}, "", ""},
+		{"clamp 调整到上限", ChatRequest{Temperature: f(1.5), MaxTokens: i(10000)}, PARAM_MODE_CLAMP,
+			map[string]interface{}{"temperature": 1.0, "max_tokens": 4096}, "temperature=1.5->1, max_tokens=10000->4096", ""},
+		{"clamp 调整到下限", ChatRequest{FrequencyPenalty: f(-3)}, PARAM_MODE_CLAMP,
+			map[string]interface{}{"frequency_penalty": -2.0}, "frequency_penalty=-3->-2", ""},
+		{"模型配置的范围优先", ChatRequest{TopP: f(0.95)}, PARAM_MODE_CLAMP,
+			map[string]interface{}{"top_p": 0.9}, "top_p=0.95->0.9", ""},
+		{"不支持的参数总是丢弃", ChatRequest{TopK: i(40)}, PARAM_MODE_REJECT, nil, "top_k=dropped", ""},
+		{"reject 超出范围", ChatRequest{Temperature: f(0.5), TopP: f(0.05)}, PARAM_MODE_REJECT, nil, "", "top_p"},
+		{"reject 范围内", ChatRequest{Temperature: f(0.5)}, PARAM_MODE_REJECT,
+			map[string]interface{}{"temperature": 0.5}, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, adjustments, err := ConfigOpt(tt.request, "test-model", modelConfig, tt.mode)
+			if tt.wantErrParam != "" {
+				var apiErr *apierror.Error
+				if !errors.As(err, &apiErr) || apiErr.Code != apierror.CodeParameterOutOfRange || apiErr.Param != tt.wantErrParam {
+					t.Fatalf("应返回 %s 的 parameter_out_of_range，实际: %v", tt.wantErrParam, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(config, tt.wantConfig) {
+				t.Errorf("config = %v，期望 %v", config, tt.wantConfig)
+			}
+			if got := formatParamAdjustments(adjustments); got != tt.wantAdjust {
+				t.Errorf("调整 = %q，期望 %q", got, tt.wantAdjust)
+			}
+		})
+	}
+}
+
+func TestResolveParamMode(t *testing.T) {
+	saved := CONFIG.PARAMS.MODE
+	defer func() { CONFIG.PARAMS.MODE = saved }()
+	CONFIG.PARAMS.MODE = PARAM_MODE_CLAMP
+
+	if got := resolveParamMode(nil, ModelConfig{}); got != PARAM_MODE_CLAMP {
+		t.Errorf("全局默认 = %s", got)
+	}
+	if got := resolveParamMode(nil, ModelConfig{ParamMode: PARAM_MODE_REJECT}); got != PARAM_MODE_REJECT {
+		t.Errorf("模型设置 = %s", got)
+	}
+	if got := resolveParamMode(&E2BExtension{ParamMode: PARAM_MODE_CLAMP}, ModelConfig{ParamMode: PARAM_MODE_REJECT}); got != PARAM_MODE_CLAMP {
+		t.Errorf("请求设置 = %s", got)
+	}
+	if err := validateParamMode(&E2BExtension{ParamMode: "ignore"}); err == nil {
+		t.Error("无效的处理方式应返回错误")
+	}
+}
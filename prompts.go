@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/yourusername/e2b-api-gateway/apierror"
+)
+
+// 提示词预设相关环境变量
+const (
+	ENV_PROMPT_PRESETS_FILE   = "E2B_PROMPT_PRESETS_FILE"   // 提示词预设JSON文件，会覆盖或追加内置预设
+	ENV_DEFAULT_PROMPT_PRESET = "E2B_DEFAULT_PROMPT_PRESET" // 全局默认预设，留空则使用模板自身的 instructions
+	ENV_MODEL_PROMPT_PRESETS  = "E2B_MODEL_PROMPT_PRESETS"  // 按模型设置默认预设，格式: 模型=预设,模型=预设
+	HEADER_PROMPT_PRESET      = "X-Prompt-Preset"
+	PROMPT_PRESET_NONE        = "none" // 显式不使用任何预设
+)
+
+// PromptPreset 命名的提示词预设，instructions 为 Go text/template，渲染结果写入模板的 instructions 字段
+type PromptPreset struct {
+	Description  string `json:"description,omitempty"`
+	Instructions string `json:"instructions"`
+
+	tmpl *template.Template
+}
+
+// PromptVars 预设模板中可用的变量
+type PromptVars struct {
+	Date                 string // 当前日期，例如 2024-06-20
+	Time                 string // 当前时间，RFC3339 格式
+	Weekday              string
+	User                 string // 请求中的 user 字段，为空时使用密钥名称
+	KeyID                string
+	KeyName              string
+	Model                string // 模型配置名，例如 claude-3-5-sonnet-20240620
+	ModelName            string // 模型展示名
+	Provider             string
+	Template             string // fragments 模板ID
+	TemplateInstructions string // 模板自身的 instructions，可在预设中引用
+}
+
+// PromptPresetView 对外展示的预设信息
+type PromptPresetView struct {
+	Name         string `json:"name"`
+	Description  string `json:"description,omitempty"`
+	Instructions string `json:"instructions"`
+}
+
+// defaultPromptPresets 内置预设
+func defaultPromptPresets() map[string]*PromptPreset {
+	return map[string]*PromptPreset{
+		"assistant": {
+			Description:  "通用助手，附带当前日期",
+			Instructions: "你是一个有用的AI助手。今天是{{.Date}}（{{.Weekday}}）。{{if .User}}当前用户: {{.User}}。{{end}}{{.TemplateInstructions}}",
+		},
+		"concise": {
+			Description:  "简洁回答",
+			Instructions: "回答尽量简洁，直接给出结论，不要重复问题。{{.TemplateInstructions}}",
+		},
+		"code-only": {
+			Description:  "只输出代码",
+			Instructions: "{{.TemplateInstructions}} Only output code for {{.Template}}, without explanations.",
+		},
+	}
+}
+
+// loadPromptPresets 加载并解析提示词预设，预设模板语法错误时启动失败
+func loadPromptPresets() map[string]*PromptPreset {
+	presets := defaultPromptPresets()
+
+	if path := getEnv(ENV_PROMPT_PRESETS_FILE, ""); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("读取提示词预设文件失败: %v", err)
+		}
+		var configured map[string]*PromptPreset
+		if err := json.Unmarshal(data, &configured); err != nil {
+			log.Fatalf("解析提示词预设失败: %v", err)
+		}
+		for name, preset := range configured {
+			if name == PROMPT_PRESET_NONE {
+				log.Fatalf("提示词预设名 %s 为保留名称", PROMPT_PRESET_NONE)
+			}
+			presets[name] = preset
+		}
+	}
+
+	for name, preset := range presets {
+		tmpl, err := template.New(name).Option("missingkey=error").Parse(preset.Instructions)
+		if err != nil {
+			log.Fatalf("解析提示词预设 %s 失败: %v", name, err)
+		}
+		preset.tmpl = tmpl
+	}
+	return presets
+}
+
+// applyModelPromptPresets 把 E2B_MODEL_PROMPT_PRESETS 中的默认预设写入模型配置
+func applyModelPromptPresets(value string) {
+	for model, preset := range parseKeyValueList(value, "模型提示词预设") {
+		modelConfig, ok := CONFIG.MODEL_CONFIG[model]
+		if !ok {
+			log.Printf("警告: 提示词预设配置中的模型不存在: %s", model)
+			continue
+		}
+		if !validPromptPreset(preset) {
+			log.Fatalf("模型 %s 的提示词预设不存在: %s", model, preset)
+		}
+		modelConfig.PromptPreset = preset
+		CONFIG.MODEL_CONFIG[model] = modelConfig
+	}
+}
+
+// validPromptPreset 判断预设名是否可用，none 表示不使用预设
+func validPromptPreset(name string) bool {
+	if name == PROMPT_PRESET_NONE {
+		return true
+	}
+	_, ok := CONFIG.PROMPTS.PRESETS[name]
+	return ok
+}
+
+// promptPresetNames 按名称排序返回所有预设
+func promptPresetNames() []string {
+	names := make([]string, 0, len(CONFIG.PROMPTS.PRESETS))
+	for name := range CONFIG.PROMPTS.PRESETS {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// listPromptPresets 返回所有预设
+func listPromptPresets() []PromptPresetView {
+	views := make([]PromptPresetView, 0, len(CONFIG.PROMPTS.PRESETS))
+	for _, name := range promptPresetNames() {
+		preset := CONFIG.PROMPTS.PRESETS[name]
+		views = append(views, PromptPresetView{Name: name, Description: preset.Description, Instructions: preset.Instructions})
+	}
+	return views
+}
+
+// requestedPromptPreset 读取请求指定的预设，扩展字段优先于请求头
+func requestedPromptPreset(header string, extension *E2BExtension) (string, error) {
+	name := strings.TrimSpace(header)
+	if extension != nil && extension.PromptPreset != "" {
+		name = extension.PromptPreset
+	}
+	if name != "" && !validPromptPreset(name) {
+		return "", apierror.InvalidRequest("e2b.prompt_preset", apierror.MsgPromptPresetNotFound, name)
+	}
+	return name, nil
+}
+
+// resolvePromptPreset 确定生效的预设，优先级为 请求 > 密钥 > 模型 > 全局默认，返回空字符串表示不使用预设
+func resolvePromptPreset(requested string, apiKey *APIKey, modelConfig ModelConfig) string {
+	preset := CONFIG.PROMPTS.DEFAULT
+	if modelConfig.PromptPreset != "" {
+		preset = modelConfig.PromptPreset
+	}
+	if apiKey != nil && apiKey.PromptPreset != "" {
+		preset = apiKey.PromptPreset
+	}
+	if requested != "" {
+		preset = requested
+	}
+	if preset == PROMPT_PRESET_NONE {
+		return ""
+	}
+	return preset
+}
+
+// newPromptVars 构造预设模板变量，模板相关字段在渲染每个模板时填充
+func newPromptVars(request ChatRequest, apiKey *APIKey, modelName string, modelConfig ModelConfig) PromptVars {
+	now := time.Now()
+	vars := PromptVars{
+		Date:      now.Format("2006-01-02"),
+		Time:      now.Format(time.RFC3339),
+		Weekday:   now.Weekday().String(),
+		User:      request.User,
+		Model:     modelName,
+		ModelName: modelConfig.Name,
+		Provider:  modelConfig.Provider,
+	}
+	if apiKey != nil {
+		vars.KeyID = apiKey.ID
+		vars.KeyName = apiKey.Name
+		if vars.User == "" {
+			vars.User = apiKey.Name
+		}
+	}
+	return vars
+}
+
+// renderPromptPreset 渲染预设，templateID 和 templateInstructions 对应正在构造的模板
+func renderPromptPreset(name string, vars PromptVars, templateID, templateInstructions string) (string, error) {
+	preset, ok := CONFIG.PROMPTS.PRESETS[name]
+	if !ok {
+		return "", apierror.InvalidRequest("prompt_preset", apierror.MsgPromptPresetNotFound, name)
+	}
+	vars.Template = templateID
+	vars.TemplateInstructions = templateInstructions
+
+	var buf bytes.Buffer
+	if err := preset.tmpl.Execute(&buf, vars); err != nil {
+		return "", fmt.Errorf("渲染提示词预设 %s 失败: %w", name, err)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/yourusername/e2b-api-gateway/apierror"
+)
+
+// withPromptPresets 临时替换提示词预设和全局默认预设
+func withPromptPresets(t *testing.T, defaultPreset string, presets map[string]string) {
+	saved := CONFIG.PROMPTS
+	t.Cleanup(func() { CONFIG.PROMPTS = saved })
+
+	CONFIG.PROMPTS.DEFAULT = defaultPreset
+	CONFIG.PROMPTS.PRESETS = make(map[string]*PromptPreset, len(presets))
+	for name, instructions := range presets {
+		CONFIG.PROMPTS.PRESETS[name] = &PromptPreset{
+			Instructions: instructions,
+			tmpl:         template.Must(template.New(name).Option("missingkey=error").Parse(instructions)),
+		}
+	}
+}
+
+func TestResolvePromptPreset(t *testing.T) {
+	withPromptPresets(t, "global", map[string]string{"global": "", "model": "", "key": "", "request": ""})
+
+	tests := []struct {
+		name      string
+		requested string
+		apiKey    *APIKey
+		model     ModelConfig
+		want      string
+	}{
+		{"全局默认", "", nil, ModelConfig{}, "global"},
+		{"模型", "", &APIKey{}, ModelConfig{PromptPreset: "model"}, "model"},
+		{"密钥优先于模型", "", &APIKey{PromptPreset: "key"}, ModelConfig{PromptPreset: "model"}, "key"},
+		{"请求优先", "request", &APIKey{PromptPreset: "key"}, ModelConfig{PromptPreset: "model"}, "request"},
+		{"请求显式关闭", PROMPT_PRESET_NONE, &APIKey{PromptPreset: "key"}, ModelConfig{}, ""},
+		{"模型显式关闭", "", nil, ModelConfig{PromptPreset: PROMPT_PRESET_NONE}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := resolvePromptPreset(tt.requested, tt.apiKey, tt.model); got != tt.want {
+				t.Errorf("resolvePromptPreset = %q，期望 %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestedPromptPreset(t *testing.T) {
+	withPromptPresets(t, "", map[string]string{"header": "", "body": ""})
+
+	tests := []struct {
+		name      string
+		header    string
+		extension *E2BExtension
+		want      string
+		wantErr   bool
+	}{
+		{"未指定", "", nil, "", false},
+		{"请求头", " header ", nil, "header", false},
+		{"扩展字段优先", "header", &E2BExtension{PromptPreset: "body"}, "body", false},
+		{"none", PROMPT_PRESET_NONE, nil, PROMPT_PRESET_NONE, false},
+		{"不存在", "missing", nil, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := requestedPromptPreset(tt.header, tt.extension)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v，期望出错: %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("requestedPromptPreset = %q，期望 %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderPromptPreset(t *testing.T) {
+	withPromptPresets(t, "", map[string]string{
+		"full":    " {{.User}}|{{.KeyID}}|{{.Model}}|{{.Template}}|{{.TemplateInstructions}} ",
+		"date":    "{{.Date}}",
+		"unknown": "{{.Missing}}",
+	})
+
+	vars := newPromptVars(ChatRequest{}, &APIKey{ID: "key_1", Name: "ci"}, "claude-3-haiku-20240307", ModelConfig{Name: "Claude 3 Haiku"})
+	tests := []struct {
+		name       string
+		preset     string
+		want       string
+		wantErr    bool
+		wantStatus int
+	}{
+		{"变量", "full", "ci|key_1|claude-3-haiku-20240307|nextjs-developer|Use pages router.", false, 0},
+		{"日期", "date", vars.Date, false, 0},
+		{"未知变量", "unknown", "", true, 0},
+		{"预设不存在", "missing", "", true, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderPromptPreset(tt.preset, vars, "nextjs-developer", "Use pages router.")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v，期望出错: %v", err, tt.wantErr)
+			}
+			var apiErr *apierror.Error
+			if tt.wantStatus != 0 && (!errors.As(err, &apiErr) || apiErr.Status != tt.wantStatus) {
+				t.Fatalf("应返回 %d，实际: %v", tt.wantStatus, err)
+			}
+			if got != tt.want {
+				t.Errorf("renderPromptPreset = %q，期望 %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewPromptVarsUser(t *testing.T) {
+	key := &APIKey{Name: "ci"}
+	if vars := newPromptVars(ChatRequest{User: "alice"}, key, "", ModelConfig{}); vars.User != "alice" {
+		t.Errorf("请求中的 user 应优先，实际 %q", vars.User)
+	}
+	if vars := newPromptVars(ChatRequest{}, key, "", ModelConfig{}); vars.User != "ci" {
+		t.Errorf("未指定 user 时应使用密钥名称，实际 %q", vars.User)
+	}
+	if vars := newPromptVars(ChatRequest{}, nil, "", ModelConfig{}); vars.User != "" || vars.KeyID != "" {
+		t.Errorf("没有密钥时不应填充密钥信息: %+v", vars)
+	}
+}
+
+func TestDefaultPromptPresetsParse(t *testing.T) {
+	for name, preset := range defaultPromptPresets() {
+		if _, err := template.New(name).Parse(preset.Instructions); err != nil {
+			t.Errorf("内置预设 %s 无法解析: %v", name, err)
+		}
+		if !strings.Contains(preset.Instructions, "{{.TemplateInstructions}}") {
+			t.Errorf("内置预设 %s 应保留模板自身的 instructions", name)
+		}
+	}
+}
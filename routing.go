@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 路由相关环境变量
+const (
+	ENV_ROUTING_RULES      = "E2B_ROUTING_RULES"      // 路由规则JSON
+	ENV_ROUTING_RULES_FILE = "E2B_ROUTING_RULES_FILE" // 路由规则JSON文件，优先于 E2B_ROUTING_RULES
+	ENV_ROUTING_TIMEZONE   = "E2B_ROUTING_TIMEZONE"   // 按时间段路由使用的时区，例如 Asia/Shanghai
+	DEFAULT_KEY_TIER       = "default"
+)
+
+// RouteCondition 路由规则的匹配条件，未设置的条件不参与匹配，所有已设置的条件都满足时规则命中
+type RouteCondition struct {
+	MinTokens int      `json:"min_tokens,omitempty"`
+	MaxTokens int      `json:"max_tokens,omitempty"`
+	HasImages *bool    `json:"has_images,omitempty"`
+	HasTools  *bool    `json:"has_tools,omitempty"`
+	Tiers     []string `json:"tiers,omitempty"`
+	Hours     string   `json:"hours,omitempty"` // 小时区间，左闭右开，例如 "9-18"，支持跨零点的 "22-6"
+}
+
+// RouteRule 一条路由规则
+type RouteRule struct {
+	Name  string         `json:"name"`
+	Model string         `json:"model"`
+	When  RouteCondition `json:"when"`
+}
+
+// VirtualModel 虚拟模型，按顺序匹配规则，都不命中时使用默认模型
+type VirtualModel struct {
+	Default string      `json:"default"`
+	Rules   []RouteRule `json:"rules"`
+}
+
+// RouteFacts 参与路由匹配的请求特征
+type RouteFacts struct {
+	PromptTokens int
+	HasImages    bool
+	HasTools     bool
+	Tier         string
+	Hour         int
+}
+
+// RouteDecision 路由结果
+type RouteDecision struct {
+	Model string
+	Rule  string
+}
+
+// defaultVirtualModels 内置的 auto 虚拟模型
+func defaultVirtualModels() map[string]VirtualModel {
+	yes := true
+	return map[string]VirtualModel{
+		"auto": {
+			Default: "claude-3-5-sonnet-20240620",
+			Rules: []RouteRule{
+				{Name: "vision", Model: "claude-3-5-sonnet-20240620", When: RouteCondition{HasImages: &yes}},
+				{Name: "tools", Model: "claude-3-5-sonnet-20240620", When: RouteCondition{HasTools: &yes}},
+				{Name: "free-tier", Model: "claude-3-haiku-20240307", When: RouteCondition{Tiers: []string{"free"}}},
+				{Name: "short-prompt", Model: "claude-3-haiku-20240307", When: RouteCondition{MaxTokens: 1000}},
+			},
+		},
+	}
+}
+
+// loadVirtualModels 加载路由规则配置，配置中的虚拟模型会覆盖同名的内置虚拟模型
+func loadVirtualModels() map[string]VirtualModel {
+	virtualModels := defaultVirtualModels()
+
+	data := []byte(getEnv(ENV_ROUTING_RULES, ""))
+	if path := getEnv(ENV_ROUTING_RULES_FILE, ""); path != "" {
+		fileData, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("读取路由规则文件失败: %v", err)
+		}
+		data = fileData
+	}
+	if len(data) == 0 {
+		return virtualModels
+	}
+
+	var configured map[string]VirtualModel
+	if err := json.Unmarshal(data, &configured); err != nil {
+		log.Fatalf("解析路由规则失败: %v", err)
+	}
+	for name, virtualModel := range configured {
+		virtualModels[name] = virtualModel
+	}
+	return virtualModels
+}
+
+// validateVirtualModels 检查路由规则引用的模型和时间段是否有效
+func validateVirtualModels(virtualModels map[string]VirtualModel) []error {
+	var errs []error
+	for name, virtualModel := range virtualModels {
+		if _, err := resolveAlias(virtualModel.Default); err != nil {
+			errs = append(errs, fmt.Errorf("虚拟模型 %s 的默认模型无效: %w", name, err))
+		}
+		for _, rule := range virtualModel.Rules {
+			if _, err := resolveAlias(rule.Model); err != nil {
+				errs = append(errs, fmt.Errorf("虚拟模型 %s 的规则 %s 引用了无效模型: %w", name, rule.Name, err))
+			}
+			if rule.When.Hours != "" {
+				if _, _, err := parseHourRange(rule.When.Hours); err != nil {
+					errs = append(errs, fmt.Errorf("虚拟模型 %s 的规则 %s 时间段无效: %w", name, rule.Name, err))
+				}
+			}
+		}
+	}
+	return errs
+}
+
+// isVirtualModel 判断请求的模型是否为虚拟模型
+func isVirtualModel(name string) bool {
+	_, ok := CONFIG.ROUTING.VIRTUAL_MODELS[name]
+	return ok
+}
+
+// virtualModelNames 按名称排序返回所有虚拟模型
+func virtualModelNames() []string {
+	names := make([]string, 0, len(CONFIG.ROUTING.VIRTUAL_MODELS))
+	for name := range CONFIG.ROUTING.VIRTUAL_MODELS {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// collectRouteFacts 从请求和调用方密钥中提取路由特征
+func collectRouteFacts(request ChatRequest, apiKey *APIKey) RouteFacts {
+	facts := RouteFacts{
+		PromptTokens: EstimateMessagesTokens(request.Messages),
+		HasTools:     len(request.Tools) > 0,
+		Tier:         apiKey.EffectiveTier(),
+		Hour:         time.Now().In(CONFIG.ROUTING.LOCATION).Hour(),
+	}
+	for _, msg := range request.Messages {
+		if countImageParts(msg.Content) > 0 {
+			facts.HasImages = true
+			break
+		}
+	}
+	return facts
+}
+
+// routeVirtualModel 按规则顺序为虚拟模型选择实际模型
+func routeVirtualModel(name string, facts RouteFacts) RouteDecision {
+	virtualModel := CONFIG.ROUTING.VIRTUAL_MODELS[name]
+	for _, rule := range virtualModel.Rules {
+		if rule.When.matches(facts) {
+			return RouteDecision{Model: rule.Model, Rule: rule.Name}
+		}
+	}
+	return RouteDecision{Model: virtualModel.Default, Rule: "default"}
+}
+
+func (cond RouteCondition) matches(facts RouteFacts) bool {
+	if cond.MinTokens > 0 && facts.PromptTokens < cond.MinTokens {
+		return false
+	}
+	if cond.MaxTokens > 0 && facts.PromptTokens > cond.MaxTokens {
+		return false
+	}
+	if cond.HasImages != nil && *cond.HasImages != facts.HasImages {
+		return false
+	}
+	if cond.HasTools != nil && *cond.HasTools != facts.HasTools {
+		return false
+	}
+	if len(cond.Tiers) > 0 && !containsString(cond.Tiers, facts.Tier) {
+		return false
+	}
+	if cond.Hours != "" {
+		start, end, err := parseHourRange(cond.Hours)
+		if err != nil {
+			return false
+		}
+		if start <= end {
+			if facts.Hour < start || facts.Hour >= end {
+				return false
+			}
+		} else if facts.Hour < start && facts.Hour >= end {
+			return false
+		}
+	}
+	return true
+}
+
+// parseHourRange 解析 "9-18" 格式的小时区间
+func parseHourRange(value string) (int, int, error) {
+	parts := strings.SplitN(value, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("格式应为 起始小时-结束小时: %q", value)
+	}
+	start, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil || start < 0 || start > 24 {
+		return 0, 0, fmt.Errorf("起始小时无效: %q", value)
+	}
+	end, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err != nil || end < 0 || end > 24 {
+		return 0, 0, fmt.Errorf("结束小时无效: %q", value)
+	}
+	return start, end, nil
+}
+
+func containsString(values []string, target string) bool {
+	for _, value := range values {
+		if value == target {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRouteConditionMatches(t *testing.T) {
+	yes, no := true, false
+	tests := []struct {
+		name  string
+		cond  RouteCondition
+		facts RouteFacts
+		want  bool
+	}{
+		{"空条件总是命中", RouteCondition{}, RouteFacts{PromptTokens: 10}, true},
+		{"低于最小token数", RouteCondition{MinTokens: 100}, RouteFacts{PromptTokens: 99}, false},
+		{"等于最小token数", RouteCondition{MinTokens: 100}, RouteFacts{PromptTokens: 100}, true},
+		{"等于最大token数", RouteCondition{MaxTokens: 100}, RouteFacts{PromptTokens: 100}, true},
+		{"超过最大token数", RouteCondition{MaxTokens: 100}, RouteFacts{PromptTokens: 101}, false},
+		{"要求图片", RouteCondition{HasImages: &yes}, RouteFacts{HasImages: false}, false},
+		{"要求没有工具", RouteCondition{HasTools: &no}, RouteFacts{HasTools: false}, true},
+		{"等级匹配", RouteCondition{Tiers: []string{"free", "pro"}}, RouteFacts{Tier: "pro"}, true},
+		{"等级不匹配", RouteCondition{Tiers: []string{"free"}}, RouteFacts{Tier: "default"}, false},
+		{"白天区间内", RouteCondition{Hours: "9-18"}, RouteFacts{Hour: 9}, true},
+		{"白天区间右端不含", RouteCondition{Hours: "9-18"}, RouteFacts{Hour: 18}, false},
+		{"白天区间外", RouteCondition{Hours: "9-18"}, RouteFacts{Hour: 3}, false},
+		{"跨零点区间的晚上", RouteCondition{Hours: "22-6"}, RouteFacts{Hour: 23}, true},
+		{"跨零点区间的零点", RouteCondition{Hours: "22-6"}, RouteFacts{Hour: 0}, true},
+		{"跨零点区间的清晨", RouteCondition{Hours: "22-6"}, RouteFacts{Hour: 5}, true},
+		{"跨零点区间右端不含", RouteCondition{Hours: "22-6"}, RouteFacts{Hour: 6}, false},
+		{"跨零点区间外", RouteCondition{Hours: "22-6"}, RouteFacts{Hour: 12}, false},
+		{"全天", RouteCondition{Hours: "0-24"}, RouteFacts{Hour: 23}, true},
+		{"无效区间不命中", RouteCondition{Hours: "bad"}, RouteFacts{Hour: 1}, false},
+		{"多个条件需同时满足", RouteCondition{Tiers: []string{"free"}, Hours: "22-6"}, RouteFacts{Tier: "free", Hour: 12}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cond.matches(tt.facts); got != tt.want {
+				t.Errorf("matches(%+v) = %v，期望 %v", tt.facts, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseHourRange(t *testing.T) {
+	tests := []struct {
+		value      string
+		start, end int
+		wantErr    bool
+	}{
+		{"9-18", 9, 18, false},
+		{" 22 - 6 ", 22, 6, false},
+		{"0-24", 0, 24, false},
+		{"9", 0, 0, true},
+		{"a-3", 0, 0, true},
+		{"3-25", 0, 0, true},
+		{"-1-3", 0, 0, true},
+	}
+	for _, tt := range tests {
+		start, end, err := parseHourRange(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseHourRange(%q) 错误 = %v，期望出错 %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (start != tt.start || end != tt.end) {
+			t.Errorf("parseHourRange(%q) = %d, %d，期望 %d, %d", tt.value, start, end, tt.start, tt.end)
+		}
+	}
+}
+
+func TestRouteVirtualModel(t *testing.T) {
+	saved := CONFIG.ROUTING.VIRTUAL_MODELS
+	defer func() { CONFIG.ROUTING.VIRTUAL_MODELS = saved }()
+	CONFIG.ROUTING.VIRTUAL_MODELS = map[string]VirtualModel{
+		"auto": {
+			Default: "fallback",
+			Rules: []RouteRule{
+				{Name: "night", Model: "night-model", When: RouteCondition{Hours: "22-6"}},
+				{Name: "short", Model: "short-model", When: RouteCondition{MaxTokens: 10}},
+			},
+		},
+	}
+	tests := []struct {
+		facts RouteFacts
+		want  RouteDecision
+	}{
+		{RouteFacts{Hour: 23, PromptTokens: 5}, RouteDecision{Model: "night-model", Rule: "night"}},
+		{RouteFacts{Hour: 12, PromptTokens: 5}, RouteDecision{Model: "short-model", Rule: "short"}},
+		{RouteFacts{Hour: 12, PromptTokens: 50}, RouteDecision{Model: "fallback", Rule: "default"}},
+	}
+	for _, tt := range tests {
+		if got := routeVirtualModel("auto", tt.facts); got != tt.want {
+			t.Errorf("routeVirtualModel(%+v) = %+v，期望 %+v", tt.facts, got, tt.want)
+		}
+	}
+}
+
+func TestValidateVirtualModels(t *testing.T) {
+	if errs := validateVirtualModels(defaultVirtualModels()); len(errs) > 0 {
+		t.Fatalf("内置虚拟模型无效: %v", errs)
+	}
+	errs := validateVirtualModels(map[string]VirtualModel{
+		"auto": {
+			Default: "missing-default",
+			Rules: []RouteRule{
+				{Name: "bad-model", Model: "missing-rule"},
+				{Name: "bad-hours", Model: "claude-3-haiku-20240307", When: RouteCondition{Hours: "25-3"}},
+			},
+		},
+	})
+	if len(errs) != 3 {
+		t.Fatalf("错误数 = %d，期望 3: %v", len(errs), errs)
+	}
+	for i, want := range []string{"missing-default", "missing-rule", "bad-hours"} {
+		if !strings.Contains(errs[i].Error(), want) {
+			t.Errorf("错误 %q 未包含 %q", errs[i], want)
+		}
+	}
+}
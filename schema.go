@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+
+	"github.com/yourusername/e2b-api-gateway/apierror"
+)
+
+// 请求字段校验相关环境变量
+const (
+	ENV_SCHEMA_MODE = "E2B_SCHEMA_MODE" // 遇到不支持的字段时的处理方式
+)
+
+// 不支持字段的处理方式
+const (
+	SCHEMA_MODE_LENIENT = "lenient" // 丢弃不支持的字段，并在响应头 x-gateway-dropped-params 中列出
+	SCHEMA_MODE_STRICT  = "strict"  // 返回400错误
+)
+
+var schemaModes = []string{SCHEMA_MODE_LENIENT, SCHEMA_MODE_STRICT}
+
+// OpenAI 请求字段的支持程度
+const (
+	FIELD_SUPPORTED   = "supported"   // 网关或上游按 OpenAI 语义处理
+	FIELD_EMULATED    = "emulated"    // 网关转换为等价的已支持字段或自行实现
+	FIELD_UNSUPPORTED = "unsupported" // 无法实现，取中性值时忽略，否则按 schema 模式丢弃或拒绝
+)
+
+// requestField OpenAI 聊天请求中的一个字段
+type requestField struct {
+	Support string
+	Note    string
+	// Neutral 判断字段取值是否与未设置等价，等价时不算作丢弃；为 nil 时只有 null 是中性值
+	Neutral func(raw json.RawMessage) bool
+}
+
+// RequestFieldView 对外展示的字段支持情况
+type RequestFieldView struct {
+	Name    string `json:"name"`
+	Support string `json:"support"`
+	Note    string `json:"note,omitempty"`
+}
+
+// StreamOptions 流式响应选项
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage,omitempty"`
+}
+
+// Usage token用量，由网关按估算规则计算
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+func newUsage(promptTokens, completionTokens int) *Usage {
+	return &Usage{
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+		TotalTokens:      promptTokens + completionTokens,
+	}
+}
+
+// neutralJSON 返回判断字段是否等于给定中性值之一的函数
+func neutralJSON(values ...string) func(json.RawMessage) bool {
+	neutral := make([]interface{}, len(values))
+	for i, value := range values {
+		json.Unmarshal([]byte(value), &neutral[i])
+	}
+	return func(raw json.RawMessage) bool {
+		var actual interface{}
+		if err := json.Unmarshal(raw, &actual); err != nil {
+			return false
+		}
+		for _, expected := range neutral {
+			if reflect.DeepEqual(actual, expected) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// openAIRequestFields OpenAI 聊天补全请求的全部字段及网关的支持情况
+var openAIRequestFields = map[string]requestField{
+	"model":             {Support: FIELD_SUPPORTED},
+	"messages":          {Support: FIELD_SUPPORTED},
+	"stream":            {Support: FIELD_SUPPORTED},
+	"n":                 {Support: FIELD_SUPPORTED, Note: "每个候选单独调用上游"},
+	"stop":              {Support: FIELD_SUPPORTED, Note: "在网关侧截断"},
+	"temperature":       {Support: FIELD_SUPPORTED},
+	"top_p":             {Support: FIELD_SUPPORTED},
+	"max_tokens":        {Support: FIELD_SUPPORTED, Note: "在网关侧截断输出"},
+	"presence_penalty":  {Support: FIELD_SUPPORTED},
+	"frequency_penalty": {Support: FIELD_SUPPORTED},
+	"user":              {Support: FIELD_SUPPORTED, Note: "用于提示词预设变量"},
+	"metadata":          {Support: FIELD_SUPPORTED, Note: "只记录到请求日志"},
+	"prompt_cache_key":  {Support: FIELD_SUPPORTED, Note: "只记录到请求日志"},
+	"safety_identifier": {Support: FIELD_SUPPORTED, Note: "只记录到请求日志"},
+	"top_k":             {Support: FIELD_SUPPORTED, Note: "非 OpenAI 字段，转发给支持的模型"},
+	"e2b":               {Support: FIELD_SUPPORTED, Note: "网关扩展字段"},
+
+	"max_completion_tokens": {Support: FIELD_EMULATED, Note: "等同于 max_tokens"},
+	"stream_options":        {Support: FIELD_EMULATED, Note: "include_usage 时在最后发送估算的用量"},
+
+	"seed":                {Support: FIELD_UNSUPPORTED},
+	"logit_bias":          {Support: FIELD_UNSUPPORTED, Neutral: neutralJSON(`{}`)},
+	"logprobs":            {Support: FIELD_UNSUPPORTED, Neutral: neutralJSON(`false`)},
+	"top_logprobs":        {Support: FIELD_UNSUPPORTED, Neutral: neutralJSON(`0`)},
+	"store":               {Support: FIELD_UNSUPPORTED, Neutral: neutralJSON(`false`)},
+	"service_tier":        {Support: FIELD_UNSUPPORTED, Neutral: neutralJSON(`"auto"`, `"default"`)},
+	"tools":               {Support: FIELD_UNSUPPORTED, Note: "只用于虚拟模型路由", Neutral: neutralJSON(`[]`)},
+	"tool_choice":         {Support: FIELD_UNSUPPORTED, Neutral: neutralJSON(`"none"`, `"auto"`)},
+	"parallel_tool_calls": {Support: FIELD_UNSUPPORTED, Neutral: neutralJSON(`true`, `false`)},
+	"functions":           {Support: FIELD_UNSUPPORTED, Neutral: neutralJSON(`[]`)},
+	"function_call":       {Support: FIELD_UNSUPPORTED, Neutral: neutralJSON(`"none"`, `"auto"`)},
+	"response_format":     {Support: FIELD_UNSUPPORTED, Neutral: neutralJSON(`{"type":"text"}`)},
+	"modalities":          {Support: FIELD_UNSUPPORTED, Neutral: neutralJSON(`["text"]`)},
+	"audio":               {Support: FIELD_UNSUPPORTED},
+	"prediction":          {Support: FIELD_UNSUPPORTED},
+	"reasoning_effort":    {Support: FIELD_UNSUPPORTED},
+	"verbosity":           {Support: FIELD_UNSUPPORTED},
+	"web_search_options":  {Support: FIELD_UNSUPPORTED},
+}
+
+// validSchemaMode 判断 schema 模式是否受支持
+func validSchemaMode(mode string) bool {
+	return containsString(schemaModes, mode)
+}
+
+// listRequestFields 按名称返回所有字段的支持情况
+func listRequestFields() []RequestFieldView {
+	views := make([]RequestFieldView, 0, len(openAIRequestFields))
+	for name, field := range openAIRequestFields {
+		views = append(views, RequestFieldView{Name: name, Support: field.Support, Note: field.Note})
+	}
+	sort.Slice(views, func(i, j int) bool {
+		return views[i].Name < views[j].Name
+	})
+	return views
+}
+
+// resolveSchemaMode 确定生效的 schema 模式，请求扩展字段优先于全局默认
+func resolveSchemaMode(extension *E2BExtension) string {
+	if extension != nil && extension.SchemaMode != "" {
+		return extension.SchemaMode
+	}
+	return CONFIG.SCHEMA.MODE
+}
+
+// checkRequestFields 检查请求体中的字段。不支持的字段取非中性值或字段未知时，
+// lenient 模式下返回被丢弃的字段名，strict 模式下返回 unsupported_parameter 或 unknown_parameter 错误
+func checkRequestFields(body []byte, mode string) ([]string, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var dropped []string
+	for _, name := range names {
+		raw := fields[name]
+		if string(raw) == "null" {
+			continue
+		}
+		field, known := openAIRequestFields[name]
+		if known && field.Support != FIELD_UNSUPPORTED {
+			continue
+		}
+		if known && field.Neutral != nil && field.Neutral(raw) {
+			continue
+		}
+		if mode == SCHEMA_MODE_STRICT {
+			if !known {
+				return nil, apierror.New(http.StatusBadRequest, apierror.TypeInvalidRequest, apierror.CodeUnknownParameter, apierror.MsgUnknownParameter, name).WithParam(name)
+			}
+			return nil, apierror.New(http.StatusBadRequest, apierror.TypeInvalidRequest, apierror.CodeUnsupportedParameter, apierror.MsgUnsupportedParameter, name).WithParam(name)
+		}
+		dropped = append(dropped, name)
+	}
+	return dropped, nil
+}
+
+// applyEmulatedFields 把模拟支持的字段转换为网关已支持的字段，并校验它们的组合。
+// max_tokens 小于1时直接拒绝，避免上游按参数下限调整为1而输出截断仍视为不限制
+func applyEmulatedFields(request *ChatRequest) error {
+	maxTokensParam := "max_tokens"
+	if request.MaxCompletionTokens != nil {
+		if request.MaxTokens != nil && *request.MaxTokens != *request.MaxCompletionTokens {
+			return apierror.InvalidRequest("max_completion_tokens", apierror.MsgMaxTokensConflict)
+		}
+		request.MaxTokens = request.MaxCompletionTokens
+		maxTokensParam = "max_completion_tokens"
+	}
+	if request.MaxTokens != nil && *request.MaxTokens < 1 {
+		return apierror.InvalidRequest(maxTokensParam, apierror.MsgMaxTokensTooSmall, maxTokensParam, *request.MaxTokens)
+	}
+	if request.StreamOptions != nil && !request.Stream {
+		return apierror.InvalidRequest("stream_options", apierror.MsgStreamOptionsNeedsStream)
+	}
+	return nil
+}
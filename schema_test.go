@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/yourusername/e2b-api-gateway/apierror"
+)
+
+func TestApplyEmulatedFields(t *testing.T) {
+	intPtr := func(v int) *int { return &v }
+	tests := []struct {
+		name          string
+		request       ChatRequest
+		wantParam     string
+		wantMaxTokens int
+	}{
+		{"未设置", ChatRequest{}, "", 0},
+		{"max_completion_tokens 等同于 max_tokens", ChatRequest{MaxCompletionTokens: intPtr(50)}, "", 50},
+		{"两者相同", ChatRequest{MaxTokens: intPtr(50), MaxCompletionTokens: intPtr(50)}, "", 50},
+		{"两者不同", ChatRequest{MaxTokens: intPtr(50), MaxCompletionTokens: intPtr(60)}, "max_completion_tokens", 0},
+		{"max_tokens 为0", ChatRequest{MaxTokens: intPtr(0)}, "max_tokens", 0},
+		{"max_tokens 为负数", ChatRequest{MaxTokens: intPtr(-5)}, "max_tokens", 0},
+		{"max_completion_tokens 为0", ChatRequest{MaxCompletionTokens: intPtr(0)}, "max_completion_tokens", 0},
+		{"max_tokens 为1", ChatRequest{MaxTokens: intPtr(1)}, "", 1},
+		{"stream_options 需要 stream", ChatRequest{StreamOptions: &StreamOptions{}}, "stream_options", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := tt.request
+			err := applyEmulatedFields(&request)
+			if tt.wantParam != "" {
+				var apiErr *apierror.Error
+				if !errors.As(err, &apiErr) || apiErr.Status != 400 || apiErr.Param != tt.wantParam {
+					t.Fatalf("应返回 %s 的400错误，实际: %v", tt.wantParam, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := request.RequestedMaxTokens(); got != tt.wantMaxTokens {
+				t.Errorf("RequestedMaxTokens = %d，期望 %d", got, tt.wantMaxTokens)
+			}
+		})
+	}
+}
+
+func TestCheckRequestFields(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		wantDropped string
+		wantCode    string
+	}{
+		{"只有支持的字段", `{"model":"m","messages":[],"temperature":0.5,"e2b":{}}`, "", ""},
+		{"模拟支持的字段", `{"max_completion_tokens":10,"stream_options":{"include_usage":true}}`, "", ""},
+		{"null 视为未设置", `{"seed":null,"foo":null}`, "", ""},
+		{"中性值不算丢弃", `{"logprobs":false,"tools":[],"tool_choice":"auto","response_format":{"type":"text"},"service_tier":"default"}`, "", ""},
+		{"不支持的字段", `{"seed":42,"logprobs":true}`, "logprobs,seed", apierror.CodeUnsupportedParameter},
+		{"非中性的 response_format", `{"response_format":{"type":"json_object"}}`, "response_format", apierror.CodeUnsupportedParameter},
+		{"未知字段", `{"foo":1}`, "foo", apierror.CodeUnknownParameter},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dropped, err := checkRequestFields([]byte(tt.body), SCHEMA_MODE_LENIENT)
+			if err != nil {
+				t.Fatalf("lenient 模式不应出错: %v", err)
+			}
+			if got := strings.Join(dropped, ","); got != tt.wantDropped {
+				t.Errorf("lenient 丢弃 = %q，期望 %q", got, tt.wantDropped)
+			}
+
+			dropped, err = checkRequestFields([]byte(tt.body), SCHEMA_MODE_STRICT)
+			if tt.wantCode == "" {
+				if err != nil || len(dropped) != 0 {
+					t.Errorf("strict = %v, %v，期望通过", dropped, err)
+				}
+				return
+			}
+			var apiErr *apierror.Error
+			if !errors.As(err, &apiErr) || apiErr.Status != 400 || apiErr.Code != tt.wantCode {
+				t.Fatalf("strict 应返回 %s，实际: %v", tt.wantCode, err)
+			}
+			// 按字段名排序，报告第一个
+			if first := strings.Split(tt.wantDropped, ",")[0]; apiErr.Param != first {
+				t.Errorf("strict 报告的字段 = %s，期望 %s", apiErr.Param, first)
+			}
+		})
+	}
+
+	if _, err := checkRequestFields([]byte(`[1]`), SCHEMA_MODE_LENIENT); err == nil {
+		t.Error("请求体不是对象时应返回错误")
+	}
+}
+
+func TestRequestFieldTable(t *testing.T) {
+	// 请求结构中的每个字段都应出现在字段表中，否则 strict 模式会把它们当作未知字段
+	typ := reflect.TypeOf(ChatRequest{})
+	for i := 0; i < typ.NumField(); i++ {
+		name := strings.Split(typ.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		if _, ok := openAIRequestFields[name]; !ok {
+			t.Errorf("请求字段 %s 不在字段表中", name)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 优雅退出相关环境变量
+const (
+	ENV_SHUTDOWN_GRACE           = "E2B_SHUTDOWN_GRACE"           // 收到退出信号后等待进行中的请求和流式响应完成的最长时间
+	ENV_SHUTDOWN_READINESS_DELAY = "E2B_SHUTDOWN_READINESS_DELAY" // 停止接受新连接前让 /readyz 先返回503的时间，便于负载均衡摘除实例
+)
+
+// lifecycle 跟踪进程状态和进行中的请求，供健康检查和优雅退出使用
+type lifecycle struct {
+	shuttingDown atomic.Bool
+
+	mu       sync.Mutex
+	requests int
+	streams  int
+	// 开始退出后完成的请求和流式响应数，用于退出时的汇总日志
+	drainedRequests int
+	drainedStreams  int
+}
+
+var serverLifecycle = &lifecycle{}
+
+// ShuttingDown 是否已收到退出信号
+func (l *lifecycle) ShuttingDown() bool {
+	return l.shuttingDown.Load()
+}
+
+// InFlight 返回进行中的请求数和其中的流式响应数
+func (l *lifecycle) InFlight() (requests, streams int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.requests, l.streams
+}
+
+// StreamStarted 标记一个流式响应开始，返回结束时调用的函数
+func (l *lifecycle) StreamStarted() func() {
+	l.mu.Lock()
+	l.streams++
+	l.mu.Unlock()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			l.streams--
+			if l.ShuttingDown() {
+				l.drainedStreams++
+			}
+			l.mu.Unlock()
+		})
+	}
+}
+
+// inFlightMiddleware 统计进行中的补全请求
+func inFlightMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		serverLifecycle.mu.Lock()
+		serverLifecycle.requests++
+		serverLifecycle.mu.Unlock()
+		defer func() {
+			serverLifecycle.mu.Lock()
+			serverLifecycle.requests--
+			if serverLifecycle.ShuttingDown() {
+				serverLifecycle.drainedRequests++
+			}
+			serverLifecycle.mu.Unlock()
+		}()
+		c.Next()
+	}
+}
+
+// serveUntilSignal 在所有监听地址上启动HTTP服务，收到 SIGTERM 或 SIGINT 后先让就绪检查失败，再停止接受新连接，
+// 等待进行中的请求和流式响应在 E2B_SHUTDOWN_GRACE 内完成，超时则强制关闭剩余连接
+func serveUntilSignal(listeners []*gatewayListener) {
+	serveErr := make(chan error, len(listeners))
+	for _, l := range listeners {
+		l := l
+		go func() {
+			if err := l.serve(); !errors.Is(err, http.ErrServerClosed) {
+				serveErr <- fmt.Errorf("%s: %w", l.spec, err)
+			}
+		}()
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(signals)
+
+	select {
+	case err := <-serveErr:
+		log.Fatalf("服务运行失败: %v", err)
+	case sig := <-signals:
+		shutdownServers(listeners, sig)
+	}
+}
+
+func shutdownServers(listeners []*gatewayListener, sig os.Signal) {
+	start := time.Now()
+	serverLifecycle.shuttingDown.Store(true)
+	requests, streams := serverLifecycle.InFlight()
+	log.Printf("收到信号 %s，开始优雅退出: 进行中的请求 %d 个（流式 %d 个），最长等待 %s", sig, requests, streams, CONFIG.SHUTDOWN.GRACE)
+
+	if delay := CONFIG.SHUTDOWN.READINESS_DELAY; delay > 0 {
+		log.Printf("就绪检查已返回503，%s 后停止接受新连接", delay)
+		time.Sleep(delay)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), CONFIG.SHUTDOWN.GRACE)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, l := range listeners {
+		wg.Add(1)
+		go func(l *gatewayListener) {
+			defer wg.Done()
+			if err := l.server.Shutdown(ctx); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+				log.Printf("关闭监听 %s 失败: %v", l.spec, err)
+			}
+		}(l)
+	}
+	wg.Wait()
+	// h2c 连接被接管后不受 Shutdown 跟踪，按进行中的请求数继续等待
+	waitForDrain(ctx)
+	if ctx.Err() != nil {
+		for _, l := range listeners {
+			l.server.Close()
+		}
+	}
+
+	serverLifecycle.mu.Lock()
+	summary := fmt.Sprintf("耗时 %s，退出期间完成请求 %d 个（流式 %d 个）",
+		time.Since(start).Round(time.Millisecond), serverLifecycle.drainedRequests, serverLifecycle.drainedStreams)
+	interrupted, interruptedStreams := serverLifecycle.requests, serverLifecycle.streams
+	serverLifecycle.mu.Unlock()
+	if interrupted > 0 {
+		log.Printf("优雅退出超时，强制中断请求 %d 个（流式 %d 个），%s", interrupted, interruptedStreams, summary)
+		return
+	}
+	log.Printf("优雅退出完成，%s", summary)
+}
+
+// waitForDrain 等待进行中的请求全部完成，或 ctx 结束
+func waitForDrain(ctx context.Context) {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if requests, _ := serverLifecycle.InFlight(); requests == 0 {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
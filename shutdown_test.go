@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// startDrainTestServer 启动一个经过 inFlightMiddleware 的服务，handler 由调用方控制何时返回
+func startDrainTestServer(t *testing.T, handler gin.HandlerFunc, grace time.Duration) ([]*gatewayListener, string) {
+	gin.SetMode(gin.TestMode)
+	savedLifecycle, savedShutdown := serverLifecycle, CONFIG.SHUTDOWN
+	serverLifecycle = &lifecycle{}
+	CONFIG.SHUTDOWN.GRACE, CONFIG.SHUTDOWN.READINESS_DELAY = grace, 0
+	t.Cleanup(func() { serverLifecycle, CONFIG.SHUTDOWN = savedLifecycle, savedShutdown })
+
+	r := gin.New()
+	r.GET("/slow", inFlightMiddleware(), handler)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &gatewayListener{
+		spec:     ListenerSpec{Role: LISTENER_ROLE_ALL, Scheme: LISTENER_TCP, Address: listener.Addr().String()},
+		listener: listener,
+		server:   &http.Server{Handler: r},
+	}
+	go l.serve()
+	return []*gatewayListener{l}, "http://" + listener.Addr().String() + "/slow"
+}
+
+// waitInFlight 等待进行中的请求数达到 n
+func waitInFlight(t *testing.T, n int) {
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if requests, _ := serverLifecycle.InFlight(); requests == n {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("进行中的请求数没有达到 %d", n)
+}
+
+func TestShutdownDrainsInFlight(t *testing.T) {
+	release := make(chan struct{})
+	listeners, url := startDrainTestServer(t, func(c *gin.Context) {
+		endStream := serverLifecycle.StreamStarted()
+		defer endStream()
+		<-release
+		c.String(http.StatusOK, "done")
+	}, 2*time.Second)
+
+	result := make(chan int, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			result <- 0
+			return
+		}
+		resp.Body.Close()
+		result <- resp.StatusCode
+	}()
+	waitInFlight(t, 1)
+
+	stopped := make(chan struct{})
+	go func() {
+		shutdownServers(listeners, syscall.SIGTERM)
+		close(stopped)
+	}()
+
+	// 退出期间健康检查返回503并报告进行中的请求
+	deadline := time.Now().Add(time.Second)
+	for !serverLifecycle.ShuttingDown() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	handleReadyz(c)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("退出期间健康检查状态码 = %d，期望 503", w.Code)
+	}
+
+	select {
+	case <-stopped:
+		t.Fatal("进行中的请求完成前不应退出")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	<-stopped
+
+	if status := <-result; status != http.StatusOK {
+		t.Fatalf("进行中的请求应正常完成，状态码 = %d", status)
+	}
+	if serverLifecycle.drainedRequests != 1 || serverLifecycle.drainedStreams != 1 {
+		t.Errorf("退出期间完成请求 %d 个（流式 %d 个），期望各 1 个", serverLifecycle.drainedRequests, serverLifecycle.drainedStreams)
+	}
+}
+
+func TestShutdownGraceExpires(t *testing.T) {
+	listeners, url := startDrainTestServer(t, func(c *gin.Context) {
+		<-c.Request.Context().Done()
+	}, 50*time.Millisecond)
+
+	failed := make(chan bool, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err == nil {
+			resp.Body.Close()
+		}
+		failed <- err != nil
+	}()
+	waitInFlight(t, 1)
+
+	start := time.Now()
+	shutdownServers(listeners, syscall.SIGTERM)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("超过宽限期后应强制退出，实际耗时 %s", elapsed)
+	}
+	if !<-failed {
+		t.Error("被强制中断的请求应以连接错误结束")
+	}
+	// 等被中断的 handler 退出，避免与恢复全局状态竞争
+	waitInFlight(t, 0)
+}
+
+func TestStreamStopsWhenClientDisconnects(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil).WithContext(ctx)
+
+	start := time.Now()
+	handleStreamResponseGin(c, []string{strings.Repeat("content ", 100)}, "test", "test", streamSettings{})
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("调用方断开后应立即停止，实际耗时 %v", elapsed)
+	}
+	body := w.Body.String()
+	if strings.Contains(body, "[DONE]") || strings.Count(body, "data: ") != 1 {
+		t.Errorf("调用方断开后不应继续发送，实际响应: %q", body)
+	}
+}
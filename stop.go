@@ -0,0 +1,116 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/yourusername/e2b-api-gateway/apierror"
+)
+
+// maxStopSequences 与 OpenAI 一致，最多4个停止序列
+const maxStopSequences = 4
+
+// parseStopSequences 解析 stop 字段，支持字符串或字符串数组，忽略空字符串
+func parseStopSequences(value interface{}) ([]string, error) {
+	var stops []string
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		stops = []string{v}
+	case []interface{}:
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, apierror.InvalidRequest("stop", apierror.MsgStopInvalidType)
+			}
+			stops = append(stops, s)
+		}
+	default:
+		return nil, apierror.InvalidRequest("stop", apierror.MsgStopInvalidType)
+	}
+
+	result := stops[:0]
+	for _, s := range stops {
+		if s != "" {
+			result = append(result, s)
+		}
+	}
+	if len(result) > maxStopSequences {
+		return nil, apierror.InvalidRequest("stop", apierror.MsgStopTooMany, maxStopSequences, len(result))
+	}
+	return result, nil
+}
+
+// applyStopSequences 在第一个停止序列处截断文本，返回截断后的文本和是否命中
+func applyStopSequences(text string, stops []string) (string, bool) {
+	cut := -1
+	for _, stop := range stops {
+		if idx := strings.Index(text, stop); idx >= 0 && (cut < 0 || idx < cut) {
+			cut = idx
+		}
+	}
+	if cut < 0 {
+		return text, false
+	}
+	return text[:cut], true
+}
+
+// stopFilter 流式输出的停止序列过滤器。每次写入后只放出不可能成为停止序列前缀的部分，
+// 保留末尾最多 最长序列长度-1 个字节，以便识别跨分块的停止序列
+type stopFilter struct {
+	stops   []string
+	holdLen int
+	pending string
+	stopped bool
+}
+
+func newStopFilter(stops []string) *stopFilter {
+	f := &stopFilter{stops: stops}
+	for _, stop := range stops {
+		if len(stop)-1 > f.holdLen {
+			f.holdLen = len(stop) - 1
+		}
+	}
+	return f
+}
+
+// Write 写入一个分块，返回可以立即发送的文本；命中停止序列后 Stopped 返回 true，之后的写入都被丢弃
+func (f *stopFilter) Write(chunk string) string {
+	if f.stopped {
+		return ""
+	}
+	f.pending += chunk
+	if text, matched := applyStopSequences(f.pending, f.stops); matched {
+		f.stopped = true
+		f.pending = ""
+		return text
+	}
+
+	emit := len(f.pending) - f.holdLen
+	if emit <= 0 {
+		return ""
+	}
+	if emit >= len(f.pending) {
+		return f.Flush()
+	}
+	// 不在多字节字符中间切分
+	for emit > 0 && !utf8.RuneStart(f.pending[emit]) {
+		emit--
+	}
+	out := f.pending[:emit]
+	f.pending = f.pending[emit:]
+	return out
+}
+
+// Flush 输出结束时放出剩余的文本
+func (f *stopFilter) Flush() string {
+	out := f.pending
+	f.pending = ""
+	return out
+}
+
+// Stopped 是否已命中停止序列
+func (f *stopFilter) Stopped() bool {
+	return f.stopped
+}
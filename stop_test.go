@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/yourusername/e2b-api-gateway/apierror"
+)
+
+func TestParseStopSequences(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   interface{}
+		want    []string
+		wantErr bool
+	}{
+		{"未设置", nil, nil, false},
+		{"字符串", "END", []string{"END"}, false},
+		{"空字符串", "", []string{}, false},
+		{"数组并忽略空字符串", []interface{}{"a", "", "b"}, []string{"a", "b"}, false},
+		{"四个序列", []interface{}{"a", "b", "c", "d"}, []string{"a", "b", "c", "d"}, false},
+		{"超过四个", []interface{}{"a", "b", "c", "d", "e"}, nil, true},
+		{"空字符串不计入上限", []interface{}{"a", "b", "c", "d", ""}, []string{"a", "b", "c", "d"}, false},
+		{"数组中有非字符串", []interface{}{"a", 1.0}, nil, true},
+		{"数字", 3.0, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseStopSequences(tt.value)
+			if tt.wantErr {
+				var apiErr *apierror.Error
+				if !errors.As(err, &apiErr) || apiErr.Param != "stop" {
+					t.Fatalf("应返回 stop 的参数错误，实际: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) || (len(got) > 0 && !reflect.DeepEqual(got, tt.want)) {
+				t.Errorf("parseStopSequences = %q，期望 %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyStopSequences(t *testing.T) {
+	tests := []struct {
+		text    string
+		stops   []string
+		want    string
+		matched bool
+	}{
+		{"hello world", nil, "hello world", false},
+		{"hello world", []string{"xyz"}, "hello world", false},
+		{"hello world", []string{"world", "lo"}, "hel", true},
+		{"STOP first", []string{"STOP"}, "", true},
+		{"你好，停止吧", []string{"停止"}, "你好，", true},
+	}
+	for _, tt := range tests {
+		got, matched := applyStopSequences(tt.text, tt.stops)
+		if got != tt.want || matched != tt.matched {
+			t.Errorf("applyStopSequences(%q, %q) = %q, %v，期望 %q, %v", tt.text, tt.stops, got, matched, tt.want, tt.matched)
+		}
+	}
+}
+
+func TestStopFilterHoldBack(t *testing.T) {
+	type write struct {
+		chunk   string
+		want    string
+		stopped bool
+	}
+	tests := []struct {
+		name      string
+		stops     []string
+		writes    []write
+		wantFlush string
+	}{
+		{"没有停止序列时原样放出", nil, []write{{"abc", "abc", false}, {"de", "de", false}}, ""},
+		{"保留可能成为前缀的末尾字节", []string{"END"}, []write{
+			{"abcde", "abc", false},
+			{"x", "d", false},
+		}, "ex"},
+		{"跨分块的停止序列", []string{"END"}, []write{
+			{"abcE", "ab", false},
+			{"N", "c", false},
+			{"D tail", "", true},
+			{"more", "", true},
+		}, ""},
+		{"分块短于保留长度", []string{"STOP"}, []write{
+			{"a", "", false},
+			{"b", "", false},
+			{"c", "", false},
+			{"d", "a", false},
+		}, "bcd"},
+		{"不在多字节字符中间切分", []string{"停止"}, []write{
+			{"你好世界", "你好", false},
+			{"停", "世", false},
+			{"止了", "界", true},
+		}, ""},
+		{"多字节停止序列的前缀不是该字符", []string{"停止"}, []write{
+			{"停下", "", false},
+			{"来吧", "停下", false},
+		}, "来吧"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newStopFilter(tt.stops)
+			for i, w := range tt.writes {
+				if got := f.Write(w.chunk); got != w.want {
+					t.Fatalf("第%d次写入 %q 放出 %q，期望 %q", i, w.chunk, got, w.want)
+				}
+				if f.Stopped() != w.stopped {
+					t.Fatalf("第%d次写入后 Stopped = %v，期望 %v", i, f.Stopped(), w.stopped)
+				}
+			}
+			if got := f.Flush(); got != tt.wantFlush {
+				t.Errorf("Flush = %q，期望 %q", got, tt.wantFlush)
+			}
+		})
+	}
+}
+
+func TestStopFilterMatchesWholeText(t *testing.T) {
+	// 任意切分方式下，流式过滤的结果都与对完整文本截断相同，且每段输出都是完整的UTF-8
+	texts := []string{
+		"The answer is 42. END of story",
+		"你好世界，到此为止吧，后面不要",
+		"mixed 中文 and EN text with 停止 inside",
+		"no stop sequence here at all",
+	}
+	stops := []string{"END", "为止", "停止"}
+	for _, text := range texts {
+		want, _ := applyStopSequences(text, stops)
+		for size := 1; size <= 7; size++ {
+			f := newStopFilter(stops)
+			var out strings.Builder
+			for start := 0; start < len(text) && !f.Stopped(); start += size {
+				end := start + size
+				if end > len(text) {
+					end = len(text)
+				}
+				part := f.Write(text[start:end])
+				if part != "" && !utf8.ValidString(part) {
+					t.Fatalf("按 %d 字节切分 %q 时输出了不完整的字符 %q", size, text, part)
+				}
+				out.WriteString(part)
+			}
+			if !f.Stopped() {
+				out.WriteString(f.Flush())
+			}
+			if out.String() != want {
+				t.Errorf("按 %d 字节切分 %q: %q，期望 %q", size, text, out.String(), want)
+			}
+		}
+	}
+}
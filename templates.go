@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourusername/e2b-api-gateway/apierror"
+)
+
+// 模板相关环境变量
+const (
+	ENV_TEMPLATES_FILE    = "E2B_TEMPLATES_FILE"   // 模板配置JSON文件，会覆盖或追加内置模板
+	ENV_DEFAULT_TEMPLATE  = "E2B_DEFAULT_TEMPLATE" // 请求未指定模板时使用的模板，默认 text
+	TEMPLATE_TEXT         = "text"                 // 纯文本对话模板
+	TEMPLATE_AUTO         = "auto"                 // 发送所有模板，由模型自行选择
+	modelTemplateSplitter = "@"                    // 模型名后缀分隔符，例如 claude-3-5-sonnet-20240620@nextjs-developer
+)
+
+// FragmentTemplate fragments 模板定义，字段与 fragments 的 templates.json 一致
+type FragmentTemplate struct {
+	Name         string   `json:"name"`
+	Lib          []string `json:"lib"`
+	File         string   `json:"file"`
+	Instructions string   `json:"instructions"`
+	Port         *int     `json:"port"`
+}
+
+// TemplateObject /v1/templates 返回的模板信息
+type TemplateObject struct {
+	ID           string   `json:"id"`
+	Object       string   `json:"object"`
+	Name         string   `json:"name"`
+	Lib          []string `json:"lib"`
+	File         string   `json:"file"`
+	Instructions string   `json:"instructions,omitempty"`
+	Port         *int     `json:"port"`
+	Default      bool     `json:"default,omitempty"`
+}
+
+// defaultTemplates 内置模板，text 之外的模板与 fragments 保持一致
+func defaultTemplates() map[string]FragmentTemplate {
+	port := func(p int) *int { return &p }
+	return map[string]FragmentTemplate{
+		// name 和 instructions 为空时分别使用 CONFIG.MODEL_PROMPT 和模型的系统提示词
+		TEMPLATE_TEXT: {
+			Lib:  []string{""},
+			File: "pages/ChatWithUsers.txt",
+		},
+		"code-interpreter-v1": {
+			Name:         "Python data analyst",
+			Lib:          []string{"python", "jupyter", "numpy", "pandas", "matplotlib", "seaborn", "plotly"},
+			File:         "script.py",
+			Instructions: "Runs code as a Jupyter notebook cell. Strong data analysis angle. Can use complex visualisation to explain results.",
+		},
+		"nextjs-developer": {
+			Name:         "Next.js developer",
+			Lib:          []string{"nextjs@14.2.5", "typescript", "@types/node", "@types/react", "@types/react-dom", "postcss", "tailwindcss", "shadcn"},
+			File:         "pages/index.tsx",
+			Instructions: "A Next.js 13+ app that reloads automatically. Using the pages router.",
+			Port:         port(3000),
+		},
+		"vue-developer": {
+			Name:         "Vue.js developer",
+			Lib:          []string{"vue@latest", "nuxt@3.13.0", "tailwindcss"},
+			File:         "app.vue",
+			Instructions: "A Vue.js 3+ app that reloads automatically. Only when asked specifically for a Vue app.",
+			Port:         port(3000),
+		},
+		"streamlit-developer": {
+			Name:         "Streamlit developer",
+			Lib:          []string{"streamlit", "pandas", "numpy", "matplotlib", "request", "seaborn", "plotly"},
+			File:         "app.py",
+			Instructions: "A streamlit app that reloads automatically.",
+			Port:         port(8501),
+		},
+		"gradio-developer": {
+			Name:         "Gradio developer",
+			Lib:          []string{"gradio", "pandas", "numpy", "matplotlib", "request", "seaborn", "plotly"},
+			File:         "app.py",
+			Instructions: "A gradio app. Gradio Blocks/Interface should be called demo.",
+			Port:         port(7860),
+		},
+	}
+}
+
+// loadTemplates 加载模板配置，文件中的模板会覆盖同名的内置模板
+func loadTemplates() map[string]FragmentTemplate {
+	templates := defaultTemplates()
+
+	path := getEnv(ENV_TEMPLATES_FILE, "")
+	if path == "" {
+		return templates
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("读取模板配置文件失败: %v", err)
+	}
+	var configured map[string]FragmentTemplate
+	if err := json.Unmarshal(data, &configured); err != nil {
+		log.Fatalf("解析模板配置失败: %v", err)
+	}
+	for id, tmpl := range configured {
+		if id == TEMPLATE_AUTO {
+			log.Fatalf("模板ID %s 为保留名称", TEMPLATE_AUTO)
+		}
+		templates[id] = tmpl
+	}
+	return templates
+}
+
+// templateIDs 按名称排序返回所有模板ID
+func templateIDs() []string {
+	ids := make([]string, 0, len(CONFIG.TEMPLATES))
+	for id := range CONFIG.TEMPLATES {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// validTemplate 判断模板ID是否可用，auto 表示所有模板
+func validTemplate(id string) bool {
+	if id == TEMPLATE_AUTO {
+		return true
+	}
+	_, ok := CONFIG.TEMPLATES[id]
+	return ok
+}
+
+// splitModelTemplate 拆分 "模型@模板" 格式的模型名
+func splitModelTemplate(model string) (string, string) {
+	if idx := strings.LastIndex(model, modelTemplateSplitter); idx > 0 {
+		return model[:idx], model[idx+len(modelTemplateSplitter):]
+	}
+	return model, ""
+}
+
+// selectTemplate 确定请求使用的模板，扩展字段优先于模型名后缀，都未指定时使用 defaultTemplate
+func selectTemplate(suffix string, extension *E2BExtension, defaultTemplate string) (string, error) {
+	selected := suffix
+	if extension != nil && extension.Template != "" {
+		selected = extension.Template
+	}
+	if selected == "" {
+		return defaultTemplate, nil
+	}
+	if !validTemplate(selected) {
+		return "", apierror.InvalidValue("e2b.template", selected, strings.Join(templateIDs(), ", "))
+	}
+	return selected, nil
+}
+
+// instructionsRenderer 根据模板ID和模板自身的 instructions 生成最终的 instructions
+type instructionsRenderer func(templateID, instructions string) (string, error)
+
+// buildTemplatePayload 构造发送给E2B的 template 字段，render 为空时直接使用模板的 instructions
+func buildTemplatePayload(templateID string, modelConfig ModelConfig, render instructionsRenderer) (map[string]interface{}, error) {
+	ids := []string{templateID}
+	if templateID == TEMPLATE_AUTO {
+		ids = templateIDs()
+	}
+
+	payload := make(map[string]interface{}, len(ids))
+	for _, id := range ids {
+		tmpl := CONFIG.TEMPLATES[id]
+		name := tmpl.Name
+		if name == "" {
+			name = CONFIG.MODEL_PROMPT
+		}
+		instructions := tmpl.Instructions
+		if instructions == "" {
+			instructions = modelConfig.SystemPrompt
+		}
+		if render != nil {
+			rendered, err := render(id, instructions)
+			if err != nil {
+				return nil, err
+			}
+			instructions = rendered
+		}
+		lib := tmpl.Lib
+		if lib == nil {
+			lib = []string{""}
+		}
+		payload[id] = map[string]interface{}{
+			"name":         name,
+			"lib":          lib,
+			"file":         tmpl.File,
+			"instructions": instructions,
+			"port":         tmpl.Port,
+		}
+	}
+	return payload, nil
+}
+
+// 使用 Gin 处理模板列表请求
+func handleTemplatesRequestGin(c *gin.Context) {
+	requestID := GenerateUUID()
+	logInfo(requestID, "获取模板列表")
+
+	ids := templateIDs()
+	data := make([]TemplateObject, 0, len(ids))
+	for _, id := range ids {
+		tmpl := CONFIG.TEMPLATES[id]
+		data = append(data, TemplateObject{
+			ID:           id,
+			Object:       "template",
+			Name:         tmpl.Name,
+			Lib:          tmpl.Lib,
+			File:         tmpl.File,
+			Instructions: tmpl.Instructions,
+			Port:         tmpl.Port,
+			Default:      id == CONFIG.DEFAULT_TEMPLATE,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"object": "list", "data": data})
+}
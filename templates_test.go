@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSplitModelTemplate(t *testing.T) {
+	tests := []struct {
+		model, wantModel, wantTemplate string
+	}{
+		{"claude-3-5-sonnet-20240620", "claude-3-5-sonnet-20240620", ""},
+		{"claude-3-5-sonnet-20240620@nextjs-developer", "claude-3-5-sonnet-20240620", "nextjs-developer"},
+		{"a@b@c", "a@b", "c"},
+		{"@text", "@text", ""},
+	}
+	for _, tt := range tests {
+		model, tmpl := splitModelTemplate(tt.model)
+		if model != tt.wantModel || tmpl != tt.wantTemplate {
+			t.Errorf("splitModelTemplate(%q) = (%q, %q)，期望 (%q, %q)", tt.model, model, tmpl, tt.wantModel, tt.wantTemplate)
+		}
+	}
+}
+
+func TestSelectTemplate(t *testing.T) {
+	tests := []struct {
+		name            string
+		suffix          string
+		extension       *E2BExtension
+		defaultTemplate string
+		want            string
+		wantErr         bool
+	}{
+		{"默认模板", "", nil, TEMPLATE_TEXT, TEMPLATE_TEXT, false},
+		{"调用方默认模板", "", nil, TEMPLATE_AUTO, TEMPLATE_AUTO, false},
+		{"空扩展字段", "", &E2BExtension{}, TEMPLATE_TEXT, TEMPLATE_TEXT, false},
+		{"模型名后缀", "nextjs-developer", nil, TEMPLATE_AUTO, "nextjs-developer", false},
+		{"扩展字段优先", "nextjs-developer", &E2BExtension{Template: "vue-developer"}, TEMPLATE_TEXT, "vue-developer", false},
+		{"auto", TEMPLATE_AUTO, nil, TEMPLATE_TEXT, TEMPLATE_AUTO, false},
+		{"未知后缀", "missing", nil, TEMPLATE_TEXT, "", true},
+		{"未知扩展字段", "", &E2BExtension{Template: "missing"}, TEMPLATE_TEXT, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := selectTemplate(tt.suffix, tt.extension, tt.defaultTemplate)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v，期望出错: %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("selectTemplate = %q，期望 %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildTemplatePayload(t *testing.T) {
+	saved := CONFIG.TEMPLATES
+	t.Cleanup(func() { CONFIG.TEMPLATES = saved })
+	CONFIG.TEMPLATES = defaultTemplates()
+	modelConfig := ModelConfig{SystemPrompt: "model prompt"}
+
+	build := func(templateID string, render instructionsRenderer) map[string]interface{} {
+		t.Helper()
+		payload, err := buildTemplatePayload(templateID, modelConfig, render)
+		if err != nil {
+			t.Fatalf("buildTemplatePayload(%q) 失败: %v", templateID, err)
+		}
+		return payload
+	}
+
+	payload := build(TEMPLATE_TEXT, nil)
+	if len(payload) != 1 {
+		t.Fatalf("text 模板应只发送一个模板，实际 %d 个", len(payload))
+	}
+	text := payload[TEMPLATE_TEXT].(map[string]interface{})
+	if text["name"] != CONFIG.MODEL_PROMPT || text["instructions"] != "model prompt" {
+		t.Errorf("text 模板应回退到全局提示词和模型系统提示词，实际 %v", text)
+	}
+
+	next := build("nextjs-developer", nil)["nextjs-developer"].(map[string]interface{})
+	if next["name"] != "Next.js developer" || next["file"] != "pages/index.tsx" || *next["port"].(*int) != 3000 {
+		t.Errorf("nextjs-developer 模板内容不符: %v", next)
+	}
+
+	CONFIG.TEMPLATES["custom"] = FragmentTemplate{File: "main.go"}
+	custom := build("custom", nil)["custom"].(map[string]interface{})
+	if !reflect.DeepEqual(custom["lib"], []string{""}) {
+		t.Errorf("未配置 lib 时应为 [\"\"]，实际 %v", custom["lib"])
+	}
+
+	if auto := build(TEMPLATE_AUTO, nil); len(auto) != len(CONFIG.TEMPLATES) {
+		t.Errorf("auto 应发送全部 %d 个模板，实际 %d 个", len(CONFIG.TEMPLATES), len(auto))
+	}
+
+	render := func(templateID, instructions string) (string, error) {
+		return templateID + ": " + instructions, nil
+	}
+	rendered := build(TEMPLATE_TEXT, render)[TEMPLATE_TEXT].(map[string]interface{})
+	if rendered["instructions"] != "text: model prompt" {
+		t.Errorf("instructions 应经过 render 处理，实际 %v", rendered["instructions"])
+	}
+
+	failing := func(string, string) (string, error) { return "", errors.New("boom") }
+	if _, err := buildTemplatePayload(TEMPLATE_AUTO, modelConfig, failing); err == nil {
+		t.Error("render 失败时应返回错误")
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// TLS相关环境变量
+const (
+	ENV_TLS_CERT_FILE       = "E2B_TLS_CERT_FILE"       // 证书文件(PEM)，可以包含中间证书
+	ENV_TLS_KEY_FILE        = "E2B_TLS_KEY_FILE"        // 私钥文件(PEM)
+	ENV_TLS_RELOAD_INTERVAL = "E2B_TLS_RELOAD_INTERVAL" // 检查证书文件是否变化的间隔，0表示不自动重新加载
+)
+
+// certReloader 从文件加载证书，文件修改后自动重新加载，已建立的连接不受影响。
+// 重新加载失败时继续使用旧证书
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu          sync.RWMutex
+	cert        *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+	loadedAt    time.Time
+	reloads     int64
+	lastError   string
+}
+
+// TLSStatus 当前证书的状态，用于管理接口
+type TLSStatus struct {
+	CertFile  string    `json:"cert_file"`
+	KeyFile   string    `json:"key_file"`
+	Subject   string    `json:"subject,omitempty"`
+	DNSNames  []string  `json:"dns_names,omitempty"`
+	NotAfter  time.Time `json:"not_after"`
+	LoadedAt  time.Time `json:"loaded_at"`
+	Reloads   int64     `json:"reloads"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// tlsCertificates 全局证书，未配置证书时为 nil
+var tlsCertificates *certReloader
+
+// newCertReloader 加载证书，首次加载失败时返回错误
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) load() error {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return err
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return err
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = &cert
+	r.certModTime = certInfo.ModTime()
+	r.keyModTime = keyInfo.ModTime()
+	r.loadedAt = time.Now()
+	r.lastError = ""
+	return nil
+}
+
+// changed 证书或私钥文件的修改时间是否与已加载的不同
+func (r *certReloader) changed() bool {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return false
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return !certInfo.ModTime().Equal(r.certModTime) || !keyInfo.ModTime().Equal(r.keyModTime)
+}
+
+// watch 按间隔检查证书文件，变化时重新加载。间隔不大于0时不检查
+func (r *certReloader) watch(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if !r.changed() {
+				continue
+			}
+			if err := r.load(); err != nil {
+				// 证书和私钥可能没有同时写完，下次检查时会重试
+				r.mu.Lock()
+				r.lastError = err.Error()
+				r.mu.Unlock()
+				log.Printf("重新加载证书失败，继续使用旧证书: %v", err)
+				continue
+			}
+			r.mu.Lock()
+			r.reloads++
+			notAfter := r.cert.Leaf.NotAfter
+			r.mu.Unlock()
+			log.Printf("已重新加载证书 %s，有效期至 %s", r.certFile, notAfter.Format(time.RFC3339))
+		}
+	}()
+}
+
+// GetCertificate 供 tls.Config 使用，每次握手时返回当前证书
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// TLSConfig 使用当前证书的 tls.Config
+func (r *certReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.GetCertificate,
+	}
+}
+
+// Status 返回当前证书的状态
+func (r *certReloader) Status() TLSStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return TLSStatus{
+		CertFile:  r.certFile,
+		KeyFile:   r.keyFile,
+		Subject:   r.cert.Leaf.Subject.String(),
+		DNSNames:  r.cert.Leaf.DNSNames,
+		NotAfter:  r.cert.Leaf.NotAfter,
+		LoadedAt:  r.loadedAt,
+		Reloads:   r.reloads,
+		LastError: r.lastError,
+	}
+}
+
+// setupTLS 按配置加载证书。只设置了证书或私钥之一时视为配置错误
+func setupTLS(certFile, keyFile string, reloadInterval time.Duration) error {
+	if certFile == "" && keyFile == "" {
+		return nil
+	}
+	if certFile == "" || keyFile == "" {
+		return fmt.Errorf("%s 和 %s 需要同时设置", ENV_TLS_CERT_FILE, ENV_TLS_KEY_FILE)
+	}
+	reloader, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("加载证书失败: %w", err)
+	}
+	reloader.watch(reloadInterval)
+	tlsCertificates = reloader
+	return nil
+}
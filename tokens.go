@@ -0,0 +1,99 @@
+package main
+
+import (
+	"unicode"
+)
+
+// 估算规则：中日韩字符按每字1个token计算，其余字符按每4个字符1个token计算，
+// 每条消息额外计入固定的格式开销。结果用于路由、上下文窗口和输出截断，只需量级准确。
+const (
+	charsPerToken      = 4
+	tokensPerMessage   = 4
+	tokensPerRequest   = 3
+	tokensPerImagePart = 85
+)
+
+// EstimateTokens 估算一段文本的token数
+func EstimateTokens(text string) int {
+	cjk, other := 0, 0
+	for _, r := range text {
+		if isCJK(r) {
+			cjk++
+		} else {
+			other++
+		}
+	}
+	return cjk + (other+charsPerToken-1)/charsPerToken
+}
+
+// EstimateMessagesTokens 估算一组消息的提示词token数
+func EstimateMessagesTokens(messages []ChatMessage) int {
+	total := tokensPerRequest
+	for _, msg := range messages {
+		total += estimateMessageTokens(msg)
+	}
+	return total
+}
+
+// estimateMessageTokens 估算单条消息的token数，含格式开销和图片
+func estimateMessageTokens(msg ChatMessage) int {
+	return tokensPerMessage + EstimateTokens(ProcessMessageContent(msg.Content)) +
+		countImageParts(msg.Content)*tokensPerImagePart
+}
+
+// countImageParts 统计消息内容中的图片数量
+func countImageParts(content interface{}) int {
+	parts, ok := content.([]interface{})
+	if !ok {
+		return 0
+	}
+	count := 0
+	for _, part := range parts {
+		if partMap, ok := part.(map[string]interface{}); ok {
+			if partType, _ := partMap["type"].(string); partType == "image_url" || partType == "image" {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+func isCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) ||
+		unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r)
+}
+
+// tokenBudget 按估算规则逐段累计输出的token数，limit 不大于0时只计数不限制
+type tokenBudget struct {
+	limit int
+	cjk   int
+	other int
+}
+
+// Take 接收一段文本，返回不超出预算的部分；超出时 exceeded 为 true，之后不应再写入
+func (b *tokenBudget) Take(text string) (kept string, exceeded bool) {
+	for i, r := range text {
+		cjk, other := b.cjk, b.other
+		if isCJK(r) {
+			cjk++
+		} else {
+			other++
+		}
+		if b.limit > 0 && cjk+(other+charsPerToken-1)/charsPerToken > b.limit {
+			return text[:i], true
+		}
+		b.cjk, b.other = cjk, other
+	}
+	return text, false
+}
+
+// Used 返回已接收文本的token数
+func (b *tokenBudget) Used() int {
+	return b.cjk + (b.other+charsPerToken-1)/charsPerToken
+}
+
+// TruncateToTokens 把文本截断到不超过 maxTokens 个token，返回截断后的文本和是否发生截断
+func TruncateToTokens(text string, maxTokens int) (string, bool) {
+	budget := tokenBudget{limit: maxTokens}
+	return budget.Take(text)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEstimateTokens(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"abcd", 1},
+		{"abcde", 2},
+		{"你好", 2},
+		{"你好abcde", 4},
+		{"こんにちは", 5},
+	}
+	for _, tt := range tests {
+		if got := EstimateTokens(tt.text); got != tt.want {
+			t.Errorf("EstimateTokens(%q) = %d，期望 %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestTruncateToTokens(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		maxTokens int
+		want      string
+		exceeded  bool
+	}{
+		{"不限制", strings.Repeat("x", 100), 0, strings.Repeat("x", 100), false},
+		{"恰好用满", "abcdefgh", 2, "abcdefgh", false},
+		{"第一个超出的字符处截断", "abcdefghi", 2, "abcdefgh", true},
+		{"中文按字计算", "你好世界", 2, "你好", true},
+		{"中文之后的英文", "你好abc", 2, "你好", true},
+		{"截断在字符边界", "a你好", 1, "a", true},
+		{"空文本", "", 1, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, exceeded := TruncateToTokens(tt.text, tt.maxTokens)
+			if got != tt.want || exceeded != tt.exceeded {
+				t.Errorf("TruncateToTokens(%q, %d) = %q, %v，期望 %q, %v", tt.text, tt.maxTokens, got, exceeded, tt.want, tt.exceeded)
+			}
+		})
+	}
+}
+
+func TestTokenBudgetChunks(t *testing.T) {
+	// 分块累计的结果与一次性截断相同，英文字符跨块时按总数取整
+	text := "ab你cdefg好hij"
+	for limit := 1; limit <= 6; limit++ {
+		want, wantExceeded := TruncateToTokens(text, limit)
+		budget := tokenBudget{limit: limit}
+		var kept strings.Builder
+		exceeded := false
+		for _, chunk := range []string{"ab", "你c", "def", "g好h", "ij"} {
+			part, over := budget.Take(chunk)
+			kept.WriteString(part)
+			if over {
+				exceeded = true
+				break
+			}
+		}
+		if kept.String() != want || exceeded != wantExceeded {
+			t.Errorf("limit=%d 分块结果 = %q, %v，一次性截断 = %q, %v", limit, kept.String(), exceeded, want, wantExceeded)
+		}
+		if budget.Used() > limit {
+			t.Errorf("limit=%d 已用 %d 个token", limit, budget.Used())
+		}
+	}
+}
+
+func TestFinishChoice(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		stops     []string
+		maxTokens int
+		want      string
+		reason    string
+	}{
+		{"正常结束", "hello", nil, 0, "hello", FINISH_REASON_STOP},
+		{"超出长度", "hello world", nil, 1, "hell", FINISH_REASON_LENGTH},
+		{"停止序列先于长度限制", "hi STOP hello world", []string{"STOP"}, 1, "hi ", FINISH_REASON_STOP},
+		{"停止序列之前仍超出长度", "hello world STOP", []string{"STOP"}, 2, "hello wo", FINISH_REASON_LENGTH},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			choice := finishChoice("test", 0, tt.content, tt.stops, tt.maxTokens)
+			if choice.Content != tt.want || choice.FinishReason != tt.reason {
+				t.Errorf("finishChoice = %q, %s，期望 %q, %s", choice.Content, choice.FinishReason, tt.want, tt.reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/yourusername/e2b-api-gateway/apierror"
+)
+
+// 上游重试相关环境变量
+const (
+	ENV_RETRY_MAX_ATTEMPTS = "E2B_RETRY_MAX_ATTEMPTS" // 同一模型最多调用上游的次数，1表示不重试
+	ENV_RETRY_DELAY_BASE   = "E2B_RETRY_DELAY_BASE"   // 第一次重试前的等待时间(毫秒)，之后按指数增长
+	ENV_RETRY_MAX_DELAY    = "E2B_RETRY_MAX_DELAY"    // 单次等待的上限，上游要求的 Retry-After 超过该值时不再重试
+	ENV_UPSTREAM_TIMEOUT   = "E2B_UPSTREAM_TIMEOUT"   // 单次上游调用的超时时间，0表示不限制
+)
+
+// 上游错误分类
+const (
+	UPSTREAM_ERROR_RATE_LIMIT       = "rate_limit"       // 上游限流
+	UPSTREAM_ERROR_CONTENT_POLICY   = "content_policy"   // 上游因内容政策拒绝
+	UPSTREAM_ERROR_CONTEXT_LENGTH   = "context_length"   // 上游认为请求超出上下文长度
+	UPSTREAM_ERROR_OUTAGE           = "outage"           // 上游不可用：5xx 或无法连接
+	UPSTREAM_ERROR_TIMEOUT          = "timeout"          // 上游响应超时
+	UPSTREAM_ERROR_INVALID_RESPONSE = "invalid_response" // 上游返回了无法解析或没有内容的响应
+	UPSTREAM_ERROR_REJECTED         = "rejected"         // 上游以其他 4xx 拒绝了请求
+	UPSTREAM_ERROR_CIRCUIT_OPEN     = "circuit_open"     // 熔断中，没有请求上游
+)
+
+// upstreamErrorMessageMax 返回给调用方的上游信息的最大长度
+const upstreamErrorMessageMax = 300
+
+var (
+	contentPolicyPattern = regexp.MustCompile(`(?i)content[ _-]?policy|content[ _-]?filter|moderation|flagged|safety|harmful|violat`)
+	contextLengthPattern = regexp.MustCompile(`(?i)context[ _-]?(length|window)|too many (input )?tokens|token limit|maximum.*tokens|(prompt|input|conversation) is too long`)
+	rateLimitPattern     = regexp.MustCompile(`(?i)rate[ _-]?limit|too many requests|quota`)
+	secretPattern        = regexp.MustCompile(`(?i)(bearer\s+\S+|sk-[a-z0-9_-]{8,}|[a-z0-9_-]{32,})`)
+)
+
+// upstreamError 一次失败的上游调用，Unwrap 返回对外的 apierror
+type upstreamError struct {
+	Class   string
+	Status  int    // 上游的HTTP状态码，连接失败时为0
+	Message string // 上游返回的信息，已脱敏
+	// RetryAfter 上游通过 Retry-After 要求的等待时间
+	RetryAfter time.Duration
+
+	api *apierror.Error
+}
+
+func (e *upstreamError) Error() string {
+	text := fmt.Sprintf("上游错误 (%s", e.Class)
+	if e.Status != 0 {
+		text += fmt.Sprintf(", HTTP %d", e.Status)
+	}
+	text += ")"
+	if e.Message != "" {
+		text += ": " + e.Message
+	}
+	if cause := errors.Unwrap(e.api); cause != nil {
+		text += ": " + cause.Error()
+	}
+	return text
+}
+
+func (e *upstreamError) Unwrap() error {
+	return e.api
+}
+
+// Retryable 判断是否值得对同一模型重试。内容政策、上下文长度和其他 4xx 重试也会得到相同结果
+func (e *upstreamError) Retryable() bool {
+	switch e.Class {
+	case UPSTREAM_ERROR_RATE_LIMIT, UPSTREAM_ERROR_OUTAGE, UPSTREAM_ERROR_TIMEOUT, UPSTREAM_ERROR_INVALID_RESPONSE:
+		return true
+	}
+	return false
+}
+
+// Fallback 判断是否值得换用备用模型。内容政策针对的是请求内容，换模型也会被拒绝；
+// 熔断针对的是整个上游，所有模型都不可用
+func (e *upstreamError) Fallback() bool {
+	return e.Class != UPSTREAM_ERROR_CONTENT_POLICY && e.Class != UPSTREAM_ERROR_CIRCUIT_OPEN
+}
+
+// classifyUpstreamResponse 根据上游的非2xx状态码和响应体分类错误
+func classifyUpstreamResponse(status int, header http.Header, body []byte) *upstreamError {
+	message := sanitizeUpstreamMessage(upstreamErrorMessage(body))
+	e := &upstreamError{Status: status, Message: message, RetryAfter: parseRetryAfter(header.Get("Retry-After"))}
+
+	switch {
+	case status == http.StatusTooManyRequests || (status < 500 && rateLimitPattern.MatchString(message)):
+		e.Class = UPSTREAM_ERROR_RATE_LIMIT
+		e.api = apierror.UpstreamRateLimited()
+	case status == http.StatusUnavailableForLegalReasons || (status < 500 && contentPolicyPattern.MatchString(message)):
+		e.Class = UPSTREAM_ERROR_CONTENT_POLICY
+		e.api = apierror.ContentPolicyViolation()
+	case status == http.StatusRequestEntityTooLarge || (status < 500 && contextLengthPattern.MatchString(message)):
+		e.Class = UPSTREAM_ERROR_CONTEXT_LENGTH
+		e.api = apierror.UpstreamContextLength()
+	case status == http.StatusRequestTimeout || status == http.StatusGatewayTimeout:
+		e.Class = UPSTREAM_ERROR_TIMEOUT
+		e.api = apierror.Timeout()
+	case status >= 500:
+		e.Class = UPSTREAM_ERROR_OUTAGE
+		e.api = apierror.UpstreamStatus(status)
+	default:
+		e.Class = UPSTREAM_ERROR_REJECTED
+		e.api = apierror.UpstreamStatus(status)
+	}
+	e.api.WithDetail(message).WithExtra("upstream_status", status)
+	return e
+}
+
+// upstreamTransportError 包装连接上游失败的错误
+func upstreamTransportError(err error) *upstreamError {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return &upstreamError{Class: UPSTREAM_ERROR_TIMEOUT, api: apierror.Timeout().Wrap(err)}
+	}
+	return &upstreamError{Class: UPSTREAM_ERROR_OUTAGE, api: apierror.UpstreamUnavailable().Wrap(err)}
+}
+
+// upstreamCircuitOpen 熔断中的错误，wait 为距离冷却结束的时间
+func upstreamCircuitOpen(wait time.Duration) *upstreamError {
+	return &upstreamError{Class: UPSTREAM_ERROR_CIRCUIT_OPEN, RetryAfter: wait, api: apierror.UpstreamCircuitOpen()}
+}
+
+// upstreamInvalidResponse 包装上游响应无法解析或没有内容的错误
+func upstreamInvalidResponse(key string, cause error) *upstreamError {
+	api := apierror.UpstreamInvalidResponse(key)
+	if cause != nil {
+		api.Wrap(cause)
+	}
+	return &upstreamError{Class: UPSTREAM_ERROR_INVALID_RESPONSE, Status: http.StatusOK, api: api}
+}
+
+// upstreamErrorMessage 从上游响应体中提取错误信息，兼容
+// {"error": "..."}、{"error": {"message": "..."}}、{"message": "..."}、{"detail": "..."} 和纯文本
+func upstreamErrorMessage(body []byte) string {
+	var payload struct {
+		Error   json.RawMessage `json:"error"`
+		Message string          `json:"message"`
+		Detail  json.RawMessage `json:"detail"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return string(body)
+	}
+	if len(payload.Error) > 0 {
+		var message string
+		if err := json.Unmarshal(payload.Error, &message); err == nil {
+			return message
+		}
+		var object struct {
+			Message string `json:"message"`
+		}
+		if err := json.Unmarshal(payload.Error, &object); err == nil && object.Message != "" {
+			return object.Message
+		}
+	}
+	if payload.Message != "" {
+		return payload.Message
+	}
+	var detail string
+	if err := json.Unmarshal(payload.Detail, &detail); err == nil {
+		return detail
+	}
+	return ""
+}
+
+// sanitizeUpstreamMessage 清理上游信息后再返回给调用方：去除控制字符和HTML，
+// 隐去疑似密钥的内容，并限制长度
+func sanitizeUpstreamMessage(message string) string {
+	if strings.Contains(message, "<") && strings.Contains(message, ">") {
+		// HTML 错误页对调用方没有意义
+		return ""
+	}
+	message = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, message)
+	message = strings.Join(strings.Fields(message), " ")
+	message = secretPattern.ReplaceAllString(message, redactedValue)
+	return truncateString(message, upstreamErrorMessageMax)
+}
+
+// parseRetryAfter 解析 Retry-After 头，支持秒数和HTTP日期
+func parseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(at); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+// retryDelay 返回第 attempt 次失败后重试前的等待时间。上游给出 Retry-After 时按其等待，
+// 否则按 E2B_RETRY_DELAY_BASE 指数退避并加入最多20%的随机抖动。
+// 返回 false 表示上游要求的等待超过 E2B_RETRY_MAX_DELAY，不再重试
+func retryDelay(attempt int, failure *upstreamError) (time.Duration, bool) {
+	if failure.RetryAfter > 0 {
+		return failure.RetryAfter, failure.RetryAfter <= CONFIG.RETRY.MAX_DELAY
+	}
+	delay := time.Duration(CONFIG.RETRY.DELAY_BASE) * time.Millisecond << uint(attempt-1)
+	if jitter := int64(delay) / 5; jitter > 0 {
+		delay += time.Duration(rand.Int63n(jitter))
+	}
+	if delay > CONFIG.RETRY.MAX_DELAY || delay <= 0 {
+		delay = CONFIG.RETRY.MAX_DELAY
+	}
+	return delay, true
+}
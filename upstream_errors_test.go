@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yourusername/e2b-api-gateway/apierror"
+)
+
+func TestClassifyUpstreamResponse(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		header     http.Header
+		body       string
+		class      string
+		apiStatus  int
+		retryAfter time.Duration
+	}{
+		{"429", 429, http.Header{"Retry-After": {"3"}}, `{"error":"slow down"}`, UPSTREAM_ERROR_RATE_LIMIT, 429, 3 * time.Second},
+		{"400 中的限流信息", 400, nil, `{"error":{"message":"Rate limit reached"}}`, UPSTREAM_ERROR_RATE_LIMIT, 429, 0},
+		{"内容政策", 400, nil, `{"message":"Output blocked by content filtering policy"}`, UPSTREAM_ERROR_CONTENT_POLICY, 400, 0},
+		{"451", 451, nil, ``, UPSTREAM_ERROR_CONTENT_POLICY, 400, 0},
+		{"上下文长度", 400, nil, `{"error":"prompt is too long: 210000 tokens > 200000 maximum"}`, UPSTREAM_ERROR_CONTEXT_LENGTH, 400, 0},
+		{"最大上下文长度", 400, nil, `{"detail":"This model's maximum context length is 8192 tokens"}`, UPSTREAM_ERROR_CONTEXT_LENGTH, 400, 0},
+		{"413", 413, nil, ``, UPSTREAM_ERROR_CONTEXT_LENGTH, 400, 0},
+		{"字段过长不是上下文长度", 400, nil, `{"error":"name is too long"}`, UPSTREAM_ERROR_REJECTED, 502, 0},
+		{"标题过长不是上下文长度", 422, nil, `{"message":"field 'title' too long"}`, UPSTREAM_ERROR_REJECTED, 502, 0},
+		{"504", 504, nil, `gateway timeout`, UPSTREAM_ERROR_TIMEOUT, 504, 0},
+		{"500", 500, nil, `<html>oops</html>`, UPSTREAM_ERROR_OUTAGE, 502, 0},
+		{"5xx 中的限流字样仍是不可用", 503, nil, `{"error":"quota backend down"}`, UPSTREAM_ERROR_OUTAGE, 502, 0},
+		{"其他 4xx", 404, nil, `not found`, UPSTREAM_ERROR_REJECTED, 502, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := tt.header
+			if header == nil {
+				header = http.Header{}
+			}
+			failure := classifyUpstreamResponse(tt.status, header, []byte(tt.body))
+			if failure.Class != tt.class {
+				t.Errorf("分类 = %s，期望 %s", failure.Class, tt.class)
+			}
+			if failure.RetryAfter != tt.retryAfter {
+				t.Errorf("RetryAfter = %s，期望 %s", failure.RetryAfter, tt.retryAfter)
+			}
+			var apiErr *apierror.Error
+			if !errors.As(failure, &apiErr) || apiErr.Status != tt.apiStatus {
+				t.Errorf("对外错误 = %v，期望状态码 %d", apiErr, tt.apiStatus)
+			}
+		})
+	}
+}
+
+func TestUpstreamErrorPolicy(t *testing.T) {
+	tests := []struct {
+		class     string
+		retryable bool
+		fallback  bool
+	}{
+		{UPSTREAM_ERROR_RATE_LIMIT, true, true},
+		{UPSTREAM_ERROR_OUTAGE, true, true},
+		{UPSTREAM_ERROR_TIMEOUT, true, true},
+		{UPSTREAM_ERROR_INVALID_RESPONSE, true, true},
+		{UPSTREAM_ERROR_CONTEXT_LENGTH, false, true},
+		{UPSTREAM_ERROR_REJECTED, false, true},
+		{UPSTREAM_ERROR_CONTENT_POLICY, false, false},
+		{UPSTREAM_ERROR_CIRCUIT_OPEN, false, false},
+	}
+	for _, tt := range tests {
+		failure := &upstreamError{Class: tt.class}
+		if failure.Retryable() != tt.retryable || failure.Fallback() != tt.fallback {
+			t.Errorf("%s: Retryable = %v, Fallback = %v，期望 %v, %v", tt.class, failure.Retryable(), failure.Fallback(), tt.retryable, tt.fallback)
+		}
+	}
+}
+
+func TestSanitizeUpstreamMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    string
+	}{
+		{"普通信息", "model overloaded", "model overloaded"},
+		{"HTML 错误页", "<html><body>502 Bad Gateway</body></html>", ""},
+		{"控制字符和多余空白", "line1\n\tline2\r\n  line3", "line1 line2 line3"},
+		{"Bearer 令牌", "invalid header Bearer abc.def", "invalid header " + redactedValue},
+		{"sk 密钥", "key sk-abcdef123456 revoked", "key " + redactedValue + " revoked"},
+		{"长随机串", "token 0123456789abcdef0123456789abcdef expired", "token " + redactedValue + " expired"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeUpstreamMessage(tt.message); got != tt.want {
+				t.Errorf("sanitizeUpstreamMessage(%q) = %q，期望 %q", tt.message, got, tt.want)
+			}
+		})
+	}
+
+	long := sanitizeUpstreamMessage(strings.Repeat("错误 ", 500))
+	if n := len([]rune(long)); n > upstreamErrorMessageMax+3 {
+		t.Errorf("截断后长度 = %d，超过上限 %d", n, upstreamErrorMessageMax)
+	}
+}
+
+func TestUpstreamErrorMessage(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+	}{
+		{`{"error":"plain"}`, "plain"},
+		{`{"error":{"message":"nested"}}`, "nested"},
+		{`{"message":"top"}`, "top"},
+		{`{"detail":"detail"}`, "detail"},
+		{`{"detail":[{"loc":"x"}]}`, ""},
+		{`not json`, "not json"},
+	}
+	for _, tt := range tests {
+		if got := upstreamErrorMessage([]byte(tt.body)); got != tt.want {
+			t.Errorf("upstreamErrorMessage(%s) = %q，期望 %q", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if got := parseRetryAfter("5"); got != 5*time.Second {
+		t.Errorf("秒数: %s", got)
+	}
+	for _, value := range []string{"", "0", "-1", "soon", http.TimeFormat} {
+		if got := parseRetryAfter(value); got != 0 {
+			t.Errorf("parseRetryAfter(%q) = %s，期望 0", value, got)
+		}
+	}
+	future := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	if got := parseRetryAfter(future); got <= 50*time.Second || got > time.Minute {
+		t.Errorf("HTTP 日期: %s", got)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	saved := CONFIG.RETRY
+	defer func() { CONFIG.RETRY = saved }()
+	CONFIG.RETRY.DELAY_BASE = 100
+	CONFIG.RETRY.MAX_DELAY = time.Second
+
+	tests := []struct {
+		name       string
+		attempt    int
+		retryAfter time.Duration
+		min, max   time.Duration
+		retry      bool
+	}{
+		{"第一次", 1, 0, 100 * time.Millisecond, 120 * time.Millisecond, true},
+		{"指数增长", 3, 0, 400 * time.Millisecond, 480 * time.Millisecond, true},
+		{"不超过上限", 10, 0, time.Second, time.Second, true},
+		{"按 Retry-After 等待", 1, 500 * time.Millisecond, 500 * time.Millisecond, 500 * time.Millisecond, true},
+		{"Retry-After 超过上限时不重试", 1, 2 * time.Second, 2 * time.Second, 2 * time.Second, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 20; i++ {
+				delay, retry := retryDelay(tt.attempt, &upstreamError{RetryAfter: tt.retryAfter})
+				if retry != tt.retry || delay < tt.min || delay > tt.max {
+					t.Fatalf("retryDelay = %s, %v，期望 [%s, %s], %v", delay, retry, tt.min, tt.max, tt.retry)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourusername/e2b-api-gateway/apierror"
+)
+
+// 请求大小相关环境变量
+const (
+	ENV_MAX_BODY_BYTES    = "E2B_MAX_BODY_BYTES"    // 请求体字节数上限
+	ENV_MAX_MESSAGES      = "E2B_MAX_MESSAGES"      // 单个请求的消息数上限，0表示不限制
+	ENV_MAX_MESSAGE_CHARS = "E2B_MAX_MESSAGE_CHARS" // 单条消息文本的字符数上限，0表示不限制
+	ENV_MAX_IMAGES        = "E2B_MAX_IMAGES"        // 单个请求的图片数上限，0表示不限制
+	ENV_MAX_IMAGE_BYTES   = "E2B_MAX_IMAGE_BYTES"   // 内嵌(base64)图片解码后的字节数上限，0表示不限制
+)
+
+// readRequestBody 读取请求体，超过 E2B_MAX_BODY_BYTES 时停止读取并返回 request_too_large 错误，
+// 避免超大请求在解析前就占满内存
+func readRequestBody(c *gin.Context) ([]byte, error) {
+	limit := CONFIG.LIMITS.MAX_BODY_BYTES
+	if c.Request.ContentLength > limit {
+		return nil, apierror.RequestTooLarge(limit)
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, limit))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return nil, apierror.RequestTooLarge(limit)
+	}
+	if err != nil {
+		return nil, apierror.InvalidRequest("", apierror.MsgInvalidBody, err.Error())
+	}
+	return body, nil
+}
+
+// validateMessages 检查消息数量、每条消息的文本长度以及图片的数量和大小
+func validateMessages(messages []ChatMessage) error {
+	if len(messages) == 0 {
+		return apierror.InvalidRequest("messages", apierror.MsgMessagesEmpty)
+	}
+	if max := CONFIG.LIMITS.MAX_MESSAGES; max > 0 && len(messages) > max {
+		return apierror.New(http.StatusBadRequest, apierror.TypeInvalidRequest, apierror.CodeTooManyMessages,
+			apierror.MsgTooManyMessages, max, len(messages)).WithParam("messages")
+	}
+
+	images := 0
+	for i, msg := range messages {
+		if max := CONFIG.LIMITS.MAX_MESSAGE_CHARS; max > 0 {
+			if chars := utf8.RuneCountInString(ProcessMessageContent(msg.Content)); chars > max {
+				return apierror.New(http.StatusBadRequest, apierror.TypeInvalidRequest, apierror.CodeMessageTooLong,
+					apierror.MsgMessageTooLong, i, max, chars).WithParam(fmt.Sprintf("messages[%d].content", i))
+			}
+		}
+
+		parts, _ := msg.Content.([]interface{})
+		for j, part := range parts {
+			size, ok := imagePartSize(part)
+			if !ok {
+				continue
+			}
+			images++
+			if max := CONFIG.LIMITS.MAX_IMAGES; max > 0 && images > max {
+				return apierror.New(http.StatusBadRequest, apierror.TypeInvalidRequest, apierror.CodeTooManyImages,
+					apierror.MsgTooManyImages, max).WithParam("messages")
+			}
+			if max := CONFIG.LIMITS.MAX_IMAGE_BYTES; max > 0 && size > max {
+				return apierror.New(http.StatusBadRequest, apierror.TypeInvalidRequest, apierror.CodeImageTooLarge,
+					apierror.MsgImageTooLarge, i, max, size).WithParam(fmt.Sprintf("messages[%d].content[%d]", i, j))
+			}
+		}
+	}
+	return nil
+}
+
+// imagePartSize 判断内容片段是否为图片，并返回内嵌图片解码后的字节数。
+// 支持 OpenAI 的 {"type": "image_url", "image_url": {"url": "data:..."}} 和
+// {"type": "image", "source": {"data": "..."}} 两种格式，远程URL的大小记为0
+func imagePartSize(part interface{}) (int64, bool) {
+	partMap, ok := part.(map[string]interface{})
+	if !ok {
+		return 0, false
+	}
+	var data string
+	switch partMap["type"] {
+	case "image_url":
+		switch v := partMap["image_url"].(type) {
+		case string:
+			data = v
+		case map[string]interface{}:
+			data, _ = v["url"].(string)
+		}
+		if !strings.HasPrefix(data, "data:") {
+			return 0, true
+		}
+		if comma := strings.IndexByte(data, ','); comma >= 0 {
+			data = data[comma+1:]
+		}
+	case "image":
+		if source, ok := partMap["source"].(map[string]interface{}); ok {
+			data, _ = source["data"].(string)
+		}
+	default:
+		return 0, false
+	}
+	return base64DecodedLen(data), true
+}
+
+// base64DecodedLen 按编码长度估算解码后的字节数，不实际解码
+func base64DecodedLen(data string) int64 {
+	data = strings.TrimRight(strings.TrimSpace(data), "=")
+	return int64(len(data)) * 3 / 4
+}
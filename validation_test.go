@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourusername/e2b-api-gateway/apierror"
+)
+
+// withLimits 临时替换请求大小限制
+func withLimits(t *testing.T, set func()) {
+	saved := CONFIG.LIMITS
+	t.Cleanup(func() { CONFIG.LIMITS = saved })
+	set()
+}
+
+func TestReadRequestBody(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	withLimits(t, func() { CONFIG.LIMITS.MAX_BODY_BYTES = 16 })
+
+	tests := []struct {
+		name          string
+		body          string
+		contentLength int64
+		wantCode      string
+	}{
+		{"未超出上限", `{"a":1}`, 7, ""},
+		{"恰好等于上限", strings.Repeat("x", 16), 16, ""},
+		{"Content-Length 超出上限", strings.Repeat("x", 17), 17, apierror.CodeRequestTooLarge},
+		{"未声明长度但实际超出", strings.Repeat("x", 100), -1, apierror.CodeRequestTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(tt.body))
+			c.Request.ContentLength = tt.contentLength
+
+			body, err := readRequestBody(c)
+			if tt.wantCode == "" {
+				if err != nil || string(body) != tt.body {
+					t.Fatalf("readRequestBody = %q, %v", body, err)
+				}
+				return
+			}
+			var apiErr *apierror.Error
+			if !errors.As(err, &apiErr) || apiErr.Code != tt.wantCode || apiErr.Status != http.StatusRequestEntityTooLarge {
+				t.Fatalf("应返回413 %s，实际: %v", tt.wantCode, err)
+			}
+		})
+	}
+}
+
+func TestValidateMessages(t *testing.T) {
+	withLimits(t, func() {
+		CONFIG.LIMITS.MAX_MESSAGES = 3
+		CONFIG.LIMITS.MAX_MESSAGE_CHARS = 10
+		CONFIG.LIMITS.MAX_IMAGES = 2
+		CONFIG.LIMITS.MAX_IMAGE_BYTES = 30
+	})
+
+	user := func(content interface{}) ChatMessage { return ChatMessage{Role: "user", Content: content} }
+	image := func(url string) map[string]interface{} {
+		return map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{"url": url}}
+	}
+	small := "data:image/png;base64," + strings.Repeat("A", 40) // 解码后30字节
+	large := "data:image/png;base64," + strings.Repeat("A", 44)
+
+	tests := []struct {
+		name      string
+		messages  []ChatMessage
+		wantCode  string
+		wantParam string
+	}{
+		{"没有消息", nil, "", "messages"},
+		{"正常", []ChatMessage{user("hi"), user("你好世界")}, "", ""},
+		{"消息过多", []ChatMessage{user("a"), user("b"), user("c"), user("d")}, apierror.CodeTooManyMessages, "messages"},
+		{"按字符而不是字节计算长度", []ChatMessage{user(strings.Repeat("中", 10))}, "", ""},
+		{"消息过长", []ChatMessage{user("hi"), user(strings.Repeat("x", 11))}, apierror.CodeMessageTooLong, "messages[1].content"},
+		{"远程图片不计大小", []ChatMessage{user([]interface{}{image("https://example.com/a.png"), image(small)})}, "", ""},
+		{"图片过多", []ChatMessage{user([]interface{}{image(small)}), user([]interface{}{image(small), image(small)})}, apierror.CodeTooManyImages, "messages"},
+		{"图片过大", []ChatMessage{user([]interface{}{map[string]interface{}{"type": "text", "text": "x"}, image(large)})}, apierror.CodeImageTooLarge, "messages[0].content[1]"},
+		{"image 格式", []ChatMessage{user([]interface{}{map[string]interface{}{"type": "image", "source": map[string]interface{}{"data": strings.Repeat("A", 44)}}})}, apierror.CodeImageTooLarge, "messages[0].content[0]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateMessages(tt.messages)
+			if tt.wantParam == "" {
+				if err != nil {
+					t.Fatalf("不应出错: %v", err)
+				}
+				return
+			}
+			var apiErr *apierror.Error
+			if !errors.As(err, &apiErr) || apiErr.Status != http.StatusBadRequest || apiErr.Code != tt.wantCode || apiErr.Param != tt.wantParam {
+				t.Fatalf("应返回 %s (%s)，实际: %+v", tt.wantCode, tt.wantParam, err)
+			}
+		})
+	}
+}
+
+func TestValidateMessagesUnlimited(t *testing.T) {
+	withLimits(t, func() {
+		CONFIG.LIMITS.MAX_MESSAGES, CONFIG.LIMITS.MAX_MESSAGE_CHARS = 0, 0
+		CONFIG.LIMITS.MAX_IMAGES, CONFIG.LIMITS.MAX_IMAGE_BYTES = 0, 0
+	})
+	messages := make([]ChatMessage, 100)
+	for i := range messages {
+		messages[i] = ChatMessage{Role: "user", Content: strings.Repeat("x", 1000)}
+	}
+	if err := validateMessages(messages); err != nil {
+		t.Fatalf("限制为0时不应出错: %v", err)
+	}
+}
@@ -0,0 +1,249 @@
+// E2B API Gateway 管理面板
+(function () {
+  'use strict';
+
+  const ADMIN_KEY_STORAGE = 'e2b-gateway-admin-key';
+  const API_KEY_STORAGE = 'e2b-gateway-api-key';
+  const REFRESH_INTERVAL = 2000;
+
+  const $ = (id) => document.getElementById(id);
+
+  let adminKey = localStorage.getItem(ADMIN_KEY_STORAGE) || '';
+  $('admin-key').value = adminKey;
+  $('pg-api-key').value = localStorage.getItem(API_KEY_STORAGE) || '';
+
+  function setStatus(message) {
+    $('status').textContent = message || '';
+  }
+
+  function escapeHTML(value) {
+    return String(value == null ? '' : value).replace(/[&<>"']/g, (c) => ({
+      '&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#39;',
+    }[c]));
+  }
+
+  function formatTime(value) {
+    if (!value || value.startsWith('0001-')) return '-';
+    return new Date(value).toLocaleString();
+  }
+
+  function formatUptime(seconds) {
+    const h = Math.floor(seconds / 3600);
+    const m = Math.floor((seconds % 3600) / 60);
+    return h + 'h ' + m + 'm ' + (seconds % 60) + 's';
+  }
+
+  async function adminFetch(path, options) {
+    const response = await fetch('/admin' + path, Object.assign({}, options, {
+      headers: Object.assign({ 'X-Admin-Key': adminKey, 'Content-Type': 'application/json' }, (options || {}).headers),
+    }));
+    const body = await response.json().catch(() => ({}));
+    if (!response.ok) {
+      const message = body.error && (body.error.message || body.error);
+      throw new Error(message || ('HTTP ' + response.status));
+    }
+    return body;
+  }
+
+  // 标签页切换
+  document.querySelectorAll('.tab').forEach((tab) => {
+    tab.addEventListener('click', () => {
+      document.querySelectorAll('.tab').forEach((t) => t.classList.remove('active'));
+      document.querySelectorAll('.panel').forEach((p) => p.classList.remove('active'));
+      tab.classList.add('active');
+      $(tab.dataset.tab).classList.add('active');
+      if (tab.dataset.tab === 'keys') loadKeys();
+      if (tab.dataset.tab === 'playground') loadModels();
+    });
+  });
+
+  $('save-admin-key').addEventListener('click', () => {
+    adminKey = $('admin-key').value.trim();
+    localStorage.setItem(ADMIN_KEY_STORAGE, adminKey);
+    refreshMetrics();
+    loadKeys();
+  });
+
+  // 概览
+  function renderChart(series) {
+    const svg = $('rate-chart');
+    const max = Math.max(1, ...series.map((p) => p.requests));
+    const step = 600 / Math.max(1, series.length - 1);
+    const toPoints = (field) => series
+      .map((p, i) => (i * step).toFixed(1) + ',' + (115 - (p[field] / max) * 105).toFixed(1))
+      .join(' ');
+    svg.innerHTML =
+      '<polyline fill="none" stroke="#2da44e" stroke-width="2" points="' + toPoints('requests') + '"/>' +
+      '<polyline fill="none" stroke="#cf222e" stroke-width="2" points="' + toPoints('errors') + '"/>' +
+      '<text x="4" y="12" font-size="10" fill="#656d76">峰值 ' + max + ' req/s</text>';
+  }
+
+  function renderRows(tbody, rows, emptyColspan) {
+    $(tbody).innerHTML = rows.length
+      ? rows.join('')
+      : '<tr><td colspan="' + emptyColspan + '">暂无数据</td></tr>';
+  }
+
+  async function refreshMetrics() {
+    if (!adminKey) {
+      setStatus('请先输入管理凭证');
+      return;
+    }
+    try {
+      const data = await adminFetch('/metrics');
+      setStatus('');
+      $('rps-10s').textContent = data.rps_10s.toFixed(2);
+      $('rps-60s').textContent = data.rps_60s.toFixed(2);
+      $('uptime').textContent = formatUptime(data.uptime_seconds);
+      renderChart(data.series || []);
+
+      renderRows('model-table', (data.models || []).map((m) =>
+        '<tr><td>' + escapeHTML(m.model) + '</td><td>' + m.requests + '</td><td>' + m.errors +
+        '</td><td>' + m.avg_ms.toFixed(0) + '</td><td>' + m.p50_ms + '</td><td>' + m.p95_ms + '</td></tr>'), 6);
+
+      renderRows('key-usage-table', (data.keys || []).map((k) =>
+        '<tr><td>' + escapeHTML(k.key_id) + '</td><td>' + escapeHTML(k.name) + '</td><td>' + k.requests +
+        '</td><td>' + k.errors + '</td><td>' + formatTime(k.last_used) + '</td></tr>'), 5);
+
+      renderRows('upstream-error-table', (data.upstream_errors || []).map((u) =>
+        '<tr><td>' + escapeHTML(u.model) + '</td><td>' + escapeHTML(u.class) + '</td><td>' + u.count +
+        '</td><td>' + u.retried + '</td></tr>'), 4);
+
+      renderRows('error-table', (data.recent_errors || []).map((e) =>
+        '<tr><td>' + formatTime(e.time) + '</td><td>' + e.status + '</td><td>' + escapeHTML(e.path) +
+        '</td><td>' + escapeHTML(e.model) + '</td><td>' + escapeHTML(e.key_id) +
+        '</td><td class="message">' + escapeHTML(e.message) + '</td></tr>'), 6);
+    } catch (err) {
+      setStatus('加载指标失败: ' + err.message);
+    }
+  }
+
+  // 密钥管理
+  async function loadKeys() {
+    if (!adminKey) return;
+    try {
+      const data = await adminFetch('/keys');
+      renderRows('key-table', (data.data || []).map((k) =>
+        '<tr><td>' + escapeHTML(k.id) + '</td><td>' + escapeHTML(k.name) + '</td><td>' + escapeHTML(k.tier) +
+        '</td><td><code>' + escapeHTML(k.key) +
+        '</code></td><td>' + (k.active ? '有效' : '已吊销') + '</td><td>' + formatTime(k.created_at) + '</td><td>' +
+        (k.active
+          ? '<button class="secondary" data-rotate="' + escapeHTML(k.id) + '">轮换</button> ' +
+            '<button class="danger" data-revoke="' + escapeHTML(k.id) + '">吊销</button>'
+          : '') +
+        '</td></tr>'), 7);
+    } catch (err) {
+      setStatus('加载密钥失败: ' + err.message);
+    }
+  }
+
+  $('create-key-form').addEventListener('submit', async (event) => {
+    event.preventDefault();
+    try {
+      const data = await adminFetch('/keys', {
+        method: 'POST',
+        body: JSON.stringify({ name: $('new-key-name').value.trim(), tier: $('new-key-tier').value.trim() }),
+      });
+      $('new-key-secret').textContent = '新密钥（只显示一次）: ' + data.secret;
+      $('new-key-name').value = '';
+      $('new-key-tier').value = '';
+      loadKeys();
+    } catch (err) {
+      setStatus('创建密钥失败: ' + err.message);
+    }
+  });
+
+  $('key-table').addEventListener('click', async (event) => {
+    const rotateID = event.target.dataset.rotate;
+    const revokeID = event.target.dataset.revoke;
+    try {
+      if (rotateID && confirm('确定轮换密钥 ' + rotateID + ' 吗？')) {
+        const data = await adminFetch('/keys/' + encodeURIComponent(rotateID) + '/rotate', { method: 'POST' });
+        $('new-key-secret').textContent = '轮换后的新密钥（只显示一次）: ' + data.secret;
+      } else if (revokeID && confirm('确定吊销密钥 ' + revokeID + ' 吗？吊销后立即失效。')) {
+        await adminFetch('/keys/' + encodeURIComponent(revokeID), { method: 'DELETE' });
+      } else {
+        return;
+      }
+      loadKeys();
+    } catch (err) {
+      setStatus('操作失败: ' + err.message);
+    }
+  });
+
+  // Playground：直接调用网关自身的 /v1/chat/completions
+  async function loadModels() {
+    const select = $('pg-model');
+    if (select.options.length) return;
+    try {
+      const response = await fetch('/v1/models');
+      const data = await response.json();
+      (data.data || []).forEach((m) => {
+        const option = document.createElement('option');
+        option.value = option.textContent = m.id;
+        select.appendChild(option);
+      });
+    } catch (err) {
+      setStatus('加载模型列表失败: ' + err.message);
+    }
+  }
+
+  $('pg-send').addEventListener('click', async () => {
+    const apiKey = $('pg-api-key').value.trim();
+    localStorage.setItem(API_KEY_STORAGE, apiKey);
+    const output = $('pg-output');
+    output.textContent = '';
+
+    const messages = [];
+    if ($('pg-system').value.trim()) messages.push({ role: 'system', content: $('pg-system').value });
+    messages.push({ role: 'user', content: $('pg-user').value });
+    const stream = $('pg-stream').checked;
+
+    $('pg-send').disabled = true;
+    try {
+      const response = await fetch('/v1/chat/completions', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json', Authorization: 'Bearer ' + apiKey },
+        body: JSON.stringify({ model: $('pg-model').value, messages: messages, stream: stream }),
+      });
+
+      if (!response.ok || !stream) {
+        const body = await response.json().catch(() => ({}));
+        if (!response.ok) {
+          const message = body.error && (body.error.message || body.error);
+          output.textContent = '错误 (' + response.status + '): ' + (message || JSON.stringify(body));
+          return;
+        }
+        output.textContent = body.choices.map((c) => c.message.content).join('\n\n---\n\n');
+        return;
+      }
+
+      const reader = response.body.getReader();
+      const decoder = new TextDecoder('utf-8');
+      let buffer = '';
+      for (;;) {
+        const { done, value } = await reader.read();
+        if (done) break;
+        buffer += decoder.decode(value, { stream: true });
+        const events = buffer.split('\n\n');
+        buffer = events.pop();
+        for (const event of events) {
+          if (!event.startsWith('data: ') || event === 'data: [DONE]') continue;
+          const chunk = JSON.parse(event.substring(6));
+          (chunk.choices || []).forEach((choice) => {
+            if (choice.delta && choice.delta.content) output.textContent += choice.delta.content;
+          });
+        }
+      }
+    } catch (err) {
+      output.textContent = '请求失败: ' + err.message;
+    } finally {
+      $('pg-send').disabled = false;
+    }
+  });
+
+  refreshMetrics();
+  setInterval(() => {
+    if ($('overview').classList.contains('active')) refreshMetrics();
+  }, REFRESH_INTERVAL);
+})();